@@ -1,82 +1,356 @@
 package apiserver
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/service"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/tools"
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
 	"github.com/bitrise-io/bitrise-workflow-editor/version"
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
 )
 
-func chooseFreePort() (string, error) {
-	address, err := net.ResolveTCPAddr("tcp", "localhost:0")
-	if err != nil {
-		return "", err
+// ServerOptions configures how LaunchServer listens and how the editor is opened.
+type ServerOptions struct {
+	// Port to listen on; empty picks a free port.
+	Port string
+	// BindAddress is the interface to listen on, loopback by default.
+	BindAddress string
+	// PublicHost is the host name printed in the editor URL and accepted in Host/Origin headers,
+	// for reaching the server through a forwarded port or a remote dev box.
+	PublicHost string
+	// Remote prints a ready-to-forward URL instead of opening a browser.
+	Remote bool
+	// NoBrowser disables opening the editor in the default browser.
+	NoBrowser bool
+	// TLSCertFile and TLSKeyFile serve HTTPS with the given certificate.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSSelfSigned serves HTTPS with a certificate generated at launch.
+	TLSSelfSigned bool
+	// UseDevServer serves non-api resources through the frontend dev server.
+	UseDevServer bool
+	// BitriseConfigPath is the config of the default project; bitrise.yml in the working dir if empty.
+	BitriseConfigPath string
+	// SecretsPath is the secrets file of the default project; .bitrise.secrets.yml if empty.
+	SecretsPath string
+	// SecretsPassphrase encrypts the secrets files at rest; they are written in plaintext if empty.
+	SecretsPassphrase string
+	// StrictSecretScan rejects config saves with possible secrets in them instead of warning.
+	StrictSecretScan bool
+	// StacksCatalogPath is a stacks and machines catalog replacing the bundled one, if set.
+	StacksCatalogPath string
+	// SecretProvidersPath is the secret providers config; no secrets are provided if empty.
+	SecretProvidersPath string
+	// ProjectConfigPaths are additional bitrise configs to serve as projects.
+	ProjectConfigPaths []string
+	// ProjectsRoot is searched for bitrise configs to serve as projects.
+	ProjectsRoot string
+	// IdleTimeout is how long the server keeps running once every editor tab is gone; 0 keeps it
+	// running until interrupted.
+	IdleTimeout time.Duration
+	// APIToken is the token API calls must carry, for scripts calling the API of a server started
+	// by them; a random one is generated at launch if empty. Only settable through the env, as
+	// flags are visible to other local users.
+	APIToken string
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to drain on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// DefaultServerOptions returns the options used when no flags are given, honoring the legacy
+// PORT, BITRISE_CONFIG, BITRISE_SECRETS, BITRISE_SECRETS_PASSPHRASE, BITRISE_SECRET_SCAN_STRICT,
+// BITRISE_STACKS_CATALOG, BITRISE_SECRET_PROVIDERS, USE_DEV_SERVER and WORKFLOW_EDITOR_API_TOKEN
+// env vars.
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		Port:                os.Getenv("PORT"),
+		BindAddress:         utility.EnvString("BIND_ADDRESS", config.DefaultBindAddress),
+		BitriseConfigPath:   os.Getenv("BITRISE_CONFIG"),
+		SecretsPath:         os.Getenv("BITRISE_SECRETS"),
+		SecretsPassphrase:   os.Getenv("BITRISE_SECRETS_PASSPHRASE"),
+		StrictSecretScan:    utility.EnvString("BITRISE_SECRET_SCAN_STRICT", "false") == "true",
+		StacksCatalogPath:   os.Getenv("BITRISE_STACKS_CATALOG"),
+		SecretProvidersPath: os.Getenv("BITRISE_SECRET_PROVIDERS"),
+		UseDevServer:        utility.EnvString("USE_DEV_SERVER", "false") == "true",
+		IdleTimeout:         service.DefaultIdleTimeout,
+		APIToken:            os.Getenv("WORKFLOW_EDITOR_API_TOKEN"),
 	}
+}
 
-	listener, err := net.ListenTCP("tcp", address)
+func (opts ServerOptions) isTLS() bool {
+	return opts.TLSSelfSigned || opts.TLSCertFile != ""
+}
+
+func (opts ServerOptions) tlsConfig() (*tls.Config, error) {
+	if opts.TLSCertFile != "" {
+		if opts.TLSKeyFile == "" {
+			return nil, fmt.Errorf("TLS key file is required with a TLS cert file")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS key pair: %s", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	cert, err := generateSelfSignedCertificate(opts.allowedHosts())
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to generate self-signed certificate: %s", err)
 	}
-	defer func() {
-		if err := listener.Close(); err != nil {
-			log.Errorf("Failed to close port, error: %s", err)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// allowedHosts lists the host names requests may address the server by.
+func (opts ServerOptions) allowedHosts() []string {
+	hosts := append([]string{}, loopbackHosts...)
+	for _, host := range []string{opts.PublicHost, opts.BindAddress} {
+		host = strings.ToLower(strings.Trim(host, "[]"))
+		if host != "" && !isWildcardAddress(host) {
+			hosts = append(hosts, host)
 		}
-	}()
+	}
+	return hosts
+}
 
-	addr, ok := listener.Addr().(*net.TCPAddr)
-	if !ok {
-		return "", fmt.Errorf("listener did not return *net.TCPAddr")
+// allowedOrigins lists the origins (scheme, host and port) pages calling the API may be loaded
+// from: the server's own under any of its host names, and the frontend dev server's if it's used.
+func (opts ServerOptions) allowedOrigins(scheme, port string) []string {
+	var origins []string
+	for _, host := range opts.allowedHosts() {
+		origins = append(origins, normalizeOrigin(scheme+"://"+net.JoinHostPort(host, port)))
+	}
+	if opts.UseDevServer {
+		devServerHost, devServerPort := devServerHostPort()
+		origins = append(origins, normalizeOrigin("http://"+net.JoinHostPort(devServerHost, devServerPort)))
 	}
+	return origins
+}
+
+// editorHost is the host the printed editor URL uses. In remote mode with a loopback listener the
+// public host isn't reachable directly, so the URL points at the forwarded localhost port.
+func (opts ServerOptions) editorHost() string {
+	if opts.PublicHost == "" || (opts.Remote && isLoopbackAddress(opts.BindAddress)) {
+		return "localhost"
+	}
+	return opts.PublicHost
+}
+
+func isLoopbackAddress(host string) bool {
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isWildcardAddress(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
 
-	port := addr.Port
-	return fmt.Sprintf("%d", port), nil
+// registerProjects registers the default project (BITRISE_CONFIG) followed by the additional and
+// discovered ones. Without a default config on disk, the first other project becomes the default.
+func registerProjects(opts ServerOptions) error {
+	if exist, err := pathutil.IsPathExists(config.BitriseYMLPath); err != nil {
+		return fmt.Errorf("Failed to check bitrise config (%s), error: %s", config.BitriseYMLPath, err)
+	} else if exist {
+		service.Projects.Register(config.BitriseYMLPath, config.SecretsYMLPath)
+	}
+
+	configPaths := append([]string{}, opts.ProjectConfigPaths...)
+	if opts.ProjectsRoot != "" {
+		discovered, err := service.DiscoverProjects(opts.ProjectsRoot)
+		if err != nil {
+			return fmt.Errorf("Failed to discover projects in %s, error: %s", opts.ProjectsRoot, err)
+		}
+		configPaths = append(configPaths, discovered...)
+	}
+	for _, pth := range configPaths {
+		service.Projects.Register(pth, filepath.Join(filepath.Dir(pth), ".bitrise.secrets.yml"))
+	}
+
+	projects := service.Projects.List()
+	if len(projects) == 0 {
+		return fmt.Errorf("No bitrise config found")
+	}
+	config.BitriseYMLPath = projects[0].BitriseYMLPath
+	config.SecretsYMLPath = projects[0].SecretsYMLPath
+	for _, project := range projects {
+		log.Printf("Serving project %s: %s", project.ID, project.BitriseYMLPath)
+	}
+	return nil
 }
 
 // LaunchServer ...
-func LaunchServer() error {
-	port := os.Getenv("PORT")
-	if port == "" {
-		var err error
-		if port, err = chooseFreePort(); err != nil {
-			return fmt.Errorf("Failed to find free port, error: %s", err)
+func LaunchServer(opts ServerOptions) error {
+	if opts.BindAddress == "" {
+		opts.BindAddress = config.DefaultBindAddress
+	}
+	if opts.Port == "" {
+		opts.Port = "0"
+	}
+	if opts.Remote {
+		opts.NoBrowser = true
+		if opts.PublicHost == "" {
+			if hostname, err := os.Hostname(); err == nil {
+				opts.PublicHost = hostname
+			}
 		}
 	}
 
-	log.Printf("Starting API server at http://localhost:%s", port)
+	listener, err := net.Listen("tcp", net.JoinHostPort(opts.BindAddress, opts.Port))
+	if err != nil {
+		return fmt.Errorf("Can't start HTTP listener: %v", err)
+	}
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("listener did not return *net.TCPAddr")
+	}
+	port := fmt.Sprintf("%d", addr.Port)
 
-	isServeFilesThroughMiddlemanServer := (utility.EnvString("USE_DEV_SERVER", "false") == "true")
-	if isServeFilesThroughMiddlemanServer {
+	scheme := "http"
+	if opts.isTLS() {
+		scheme = "https"
+	}
+
+	log.Printf("Starting API server at %s://%s", scheme, net.JoinHostPort(opts.BindAddress, port))
+
+	if opts.UseDevServer {
 		log.Printf(" (!) Serving non api resources through middleman server!")
 	}
 
-	config.BitriseYMLPath = utility.EnvString("BITRISE_CONFIG", "bitrise.yml")
-	config.SecretsYMLPath = utility.EnvString("BITRISE_SECRETS", ".bitrise.secrets.yml")
+	config.BitriseYMLPath = opts.BitriseConfigPath
+	if config.BitriseYMLPath == "" {
+		config.BitriseYMLPath = "bitrise.yml"
+	}
+	config.SecretsYMLPath = opts.SecretsPath
+	if config.SecretsYMLPath == "" {
+		config.SecretsYMLPath = ".bitrise.secrets.yml"
+	}
+	if err := registerProjects(opts); err != nil {
+		return err
+	}
+	config.SecretsPassphrase = opts.SecretsPassphrase
+	if config.SecretsPassphrase != "" {
+		log.Printf("Secrets files are encrypted at rest")
+	}
+	config.StrictSecretScan = opts.StrictSecretScan
+	config.StacksCatalogPath = opts.StacksCatalogPath
+	if config.StacksCatalogPath != "" {
+		log.Printf("Serving stacks and machines from: %s", config.StacksCatalogPath)
+	}
+	config.SecretProvidersPath = opts.SecretProvidersPath
+	if config.SecretProvidersPath != "" {
+		log.Printf("Providing secrets as configured in: %s", config.SecretProvidersPath)
+	}
+	config.IncludeMirrorDir = utility.EnvString("BITRISE_INCLUDE_MIRROR_DIR", "")
+	if config.IncludeMirrorDir != "" {
+		log.Printf("Resolving cross-repository includes from local mirrors at: %s", config.IncludeMirrorDir)
+	}
+	config.IncludeCacheDir = service.DefaultIncludeCacheDir()
 
-	if _, err := SetupRoutes(isServeFilesThroughMiddlemanServer); err != nil {
+	tools.BitriseBinary = utility.EnvString("BITRISE_CLI_PATH", tools.BitriseBinary)
+
+	if _, err := SetupRoutes(opts.UseDevServer); err != nil {
 		return fmt.Errorf("Failed to setup routes, error: %s", err)
 	}
 
+	apiToken := opts.APIToken
+	if apiToken == "" {
+		var err error
+		if apiToken, err = GenerateAPIToken(); err != nil {
+			return fmt.Errorf("Failed to generate API token, error: %s", err)
+		}
+	}
+
+	idle := make(chan struct{})
+	var idleOnce sync.Once
+	service.Sessions = service.NewSessionManager(opts.IdleTimeout, service.DefaultHeartbeatTimeout, func() {
+		idleOnce.Do(func() { close(idle) })
+	})
+
+	server := &http.Server{Handler: newAuthHandler(apiToken, opts.allowedHosts(), opts.allowedOrigins(scheme, port), service.Sessions.TrackRequests(http.DefaultServeMux))}
+	// Runs are canceled on shutdown, which also ends their event streams.
+	server.RegisterOnShutdown(service.Runs.Shutdown)
+	if opts.isTLS() {
+		if server.TLSConfig, err = opts.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
 	{
-		openCmd := "open"
-		if runtime.GOOS == "linux" {
-			openCmd = "xdg-open"
+		workflowEditorURL := fmt.Sprintf("%s://%s/%s/?%s=%s", scheme, net.JoinHostPort(opts.editorHost(), port), version.VERSION, APITokenQueryParam, apiToken)
+		if opts.Remote && isLoopbackAddress(opts.BindAddress) {
+			sshHost := opts.PublicHost
+			if sshHost == "" {
+				sshHost = "<this host>"
+			}
+			log.Printf("To reach the editor from your machine, forward the port, e.g.:")
+			log.Printf("  ssh -L %s:localhost:%s %s", port, port, sshHost)
+			log.Printf("then open: %s", workflowEditorURL)
+		} else {
+			log.Printf("Workflow editor URL: %s", workflowEditorURL)
 		}
-		workflowEditorURL := fmt.Sprintf("http://localhost:%s/%s/", port, version.VERSION)
-		log.Printf("Workflow editor URL: %s", workflowEditorURL)
-		if err := command.NewWithStandardOuts(openCmd, workflowEditorURL).Run(); err != nil {
-			log.Printf(" [!] Failed to open workflow editor in browser, error: %s", err)
+
+		if !opts.NoBrowser {
+			openCmd := "open"
+			if runtime.GOOS == "linux" {
+				openCmd = "xdg-open"
+			}
+			if err := command.NewWithStandardOuts(openCmd, workflowEditorURL).Run(); err != nil {
+				log.Printf(" [!] Failed to open workflow editor in browser, error: %s", err)
+			}
 		}
 	}
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	shutdownErr := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		select {
+		case <-idle:
+			log.Printf("All editor sessions closed, shutting down")
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down", sig)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	if opts.isTLS() {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("Can't start HTTP listener: %v", err)
 	}
+
+	// Serve returns as soon as shutdown starts; wait for in-flight requests to drain.
+	if err := <-shutdownErr; err != nil {
+		return fmt.Errorf("Failed to shut down gracefully: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,67 @@
+package apiserver
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerOptions_allowedHosts(t *testing.T) {
+	opts := ServerOptions{BindAddress: "0.0.0.0", PublicHost: "DevBox.internal"}
+	require.Equal(t, []string{"localhost", "127.0.0.1", "::1", "devbox.internal"}, opts.allowedHosts())
+
+	opts = ServerOptions{BindAddress: "10.0.0.5"}
+	require.Contains(t, opts.allowedHosts(), "10.0.0.5")
+}
+
+func TestServerOptions_editorHost(t *testing.T) {
+	require.Equal(t, "localhost", ServerOptions{BindAddress: "127.0.0.1"}.editorHost())
+	require.Equal(t, "devbox", ServerOptions{BindAddress: "127.0.0.1", PublicHost: "devbox"}.editorHost())
+
+	t.Log("remote mode on a loopback listener prints the forwarded port")
+	require.Equal(t, "localhost", ServerOptions{BindAddress: "127.0.0.1", PublicHost: "devbox", Remote: true}.editorHost())
+	require.Equal(t, "localhost", ServerOptions{BindAddress: "::1", PublicHost: "devbox", Remote: true}.editorHost())
+	require.Equal(t, "devbox", ServerOptions{BindAddress: "0.0.0.0", PublicHost: "devbox", Remote: true}.editorHost())
+}
+
+func TestServerOptions_tlsConfig(t *testing.T) {
+	opts := ServerOptions{TLSSelfSigned: true, PublicHost: "devbox.internal"}
+	cfg, err := opts.tlsConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	require.NoError(t, cert.VerifyHostname("localhost"))
+	require.NoError(t, cert.VerifyHostname("127.0.0.1"))
+	require.NoError(t, cert.VerifyHostname("devbox.internal"))
+
+	_, err = ServerOptions{TLSCertFile: "cert.pem"}.tlsConfig()
+	require.Error(t, err)
+}
+
+func TestServerOptions_allowedOrigins(t *testing.T) {
+	opts := ServerOptions{BindAddress: "0.0.0.0", PublicHost: "devbox.internal"}
+	require.Equal(t, []string{
+		"https://localhost:443",
+		"https://127.0.0.1:443",
+		"https://[::1]:443",
+		"https://devbox.internal:443",
+	}, opts.allowedOrigins("https", "443"))
+
+	opts = ServerOptions{BindAddress: "0.0.0.0", UseDevServer: true}
+	require.Equal(t, []string{
+		"http://localhost:4000",
+		"http://127.0.0.1:4000",
+		"http://[::1]:4000",
+		"http://localhost:4567",
+	}, opts.allowedOrigins("http", "4000"))
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	require.Equal(t, "http://localhost:80", normalizeOrigin("http://LocalHost"))
+	require.Equal(t, "https://[::1]:8443", normalizeOrigin("https://[::1]:8443"))
+	require.Equal(t, "", normalizeOrigin("null"))
+	require.Equal(t, "", normalizeOrigin("file://localhost"))
+}
@@ -0,0 +1,148 @@
+package apiserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/service"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// APITokenCookie carries the per-launch API token for requests made by the editor page.
+	APITokenCookie = "workflow_editor_token"
+	// APITokenHeader lets non-browser clients pass the API token.
+	APITokenHeader = "Bitrise-Workflow-Editor-Token"
+	// APITokenQueryParam is how the launch URL hands the token to the browser.
+	APITokenQueryParam = "token"
+)
+
+// GenerateAPIToken returns a random token for a single server launch.
+func GenerateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// authHandler guards the server against other local processes and against web pages reaching it
+// through the browser (CSRF, DNS rebinding):
+//   - the Host header must name an allowed host and, when sent, the Origin header must be an
+//     allowed origin (scheme, host and port),
+//   - every /api call must carry the launch token, as a cookie or a header.
+//
+// The launch URL carries the token as a query param; it is moved into an HttpOnly cookie and
+// stripped from the URL by a redirect.
+type authHandler struct {
+	token          string
+	allowedHosts   []string
+	allowedOrigins []string
+	next           http.Handler
+}
+
+func newAuthHandler(token string, allowedHosts, allowedOrigins []string, next http.Handler) http.Handler {
+	return authHandler{token: token, allowedHosts: allowedHosts, allowedOrigins: allowedOrigins, next: next}
+}
+
+func (h authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.isAllowedHost(r.Host) {
+		log.Warnf("Rejected request with unexpected Host header: %s", r.Host)
+		service.RespondWithJSON(w, http.StatusForbidden, service.NewErrorResponse("Forbidden host"))
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !h.isAllowedOrigin(origin) {
+		log.Warnf("Rejected request with unexpected Origin header: %s", origin)
+		service.RespondWithJSON(w, http.StatusForbidden, service.NewErrorResponse("Forbidden origin"))
+		return
+	}
+
+	query := r.URL.Query()
+	if token := query.Get(APITokenQueryParam); token != "" && h.isValidToken(token) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     APITokenCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		query.Del(APITokenQueryParam)
+		redirectURL := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") && !h.isValidToken(requestToken(r)) {
+		service.RespondWithJSON(w, http.StatusUnauthorized, service.NewErrorResponse("Missing or invalid API token"))
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(APITokenHeader); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(APITokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (h authHandler) isValidToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h authHandler) isAllowedHost(hostport string) bool {
+	host := hostport
+	if name, _, err := net.SplitHostPort(hostport); err == nil {
+		host = name
+	}
+	host = strings.Trim(strings.ToLower(host), "[]")
+
+	for _, allowed := range h.allowedHosts {
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (h authHandler) isAllowedOrigin(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range h.allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeOrigin returns the origin as scheme://host:port with the scheme's default port spelled
+// out, or "" if it isn't an http(s) origin.
+func normalizeOrigin(origin string) string {
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	port := u.Port()
+	if port == "" && u.Scheme == "http" {
+		port = "80"
+	} else if port == "" {
+		port = "443"
+	}
+	return u.Scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// loopbackHosts are the host names the server is reachable under locally.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "::1"}
@@ -0,0 +1,85 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := newAuthHandler("secret-token", loopbackHosts, []string{"http://localhost:4000", "http://127.0.0.1:4000"}, next)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Log("api calls require the token")
+	{
+		req := httptest.NewRequest("GET", "http://localhost:4000/api/secrets", nil)
+		require.Equal(t, http.StatusUnauthorized, serve(req).Code)
+
+		req = httptest.NewRequest("GET", "http://localhost:4000/api/secrets", nil)
+		req.Header.Set(APITokenHeader, "wrong")
+		require.Equal(t, http.StatusUnauthorized, serve(req).Code)
+
+		req = httptest.NewRequest("GET", "http://localhost:4000/api/secrets", nil)
+		req.Header.Set(APITokenHeader, "secret-token")
+		require.Equal(t, http.StatusOK, serve(req).Code)
+
+		req = httptest.NewRequest("GET", "http://127.0.0.1:4000/api/secrets", nil)
+		req.AddCookie(&http.Cookie{Name: APITokenCookie, Value: "secret-token"})
+		require.Equal(t, http.StatusOK, serve(req).Code)
+	}
+
+	t.Log("assets don't require the token")
+	{
+		req := httptest.NewRequest("GET", "http://localhost:4000/1.0.0/index.html", nil)
+		require.Equal(t, http.StatusOK, serve(req).Code)
+	}
+
+	t.Log("the launch URL token is moved into a cookie")
+	{
+		req := httptest.NewRequest("GET", "http://localhost:4000/1.0.0/?token=secret-token&x=1", nil)
+		rr := serve(req)
+		require.Equal(t, http.StatusFound, rr.Code)
+		require.Equal(t, "/1.0.0/?x=1", rr.Header().Get("Location"))
+		cookies := rr.Result().Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, APITokenCookie, cookies[0].Name)
+		require.True(t, cookies[0].HttpOnly)
+	}
+
+	t.Log("foreign hosts and origins are rejected (DNS rebinding, CSRF)")
+	{
+		req := httptest.NewRequest("GET", "http://evil.example.com:4000/api/secrets", nil)
+		req.Header.Set(APITokenHeader, "secret-token")
+		require.Equal(t, http.StatusForbidden, serve(req).Code)
+
+		req = httptest.NewRequest("POST", "http://localhost:4000/api/bitrise-yml", nil)
+		req.Header.Set(APITokenHeader, "secret-token")
+		req.Header.Set("Origin", "https://evil.example.com")
+		require.Equal(t, http.StatusForbidden, serve(req).Code)
+
+		req = httptest.NewRequest("POST", "http://localhost:4000/api/bitrise-yml", nil)
+		req.Header.Set(APITokenHeader, "secret-token")
+		req.Header.Set("Origin", "http://LOCALHOST:4000")
+		require.Equal(t, http.StatusOK, serve(req).Code)
+	}
+
+	t.Log("loopback origins on other ports or schemes are rejected (other local web servers)")
+	{
+		for _, origin := range []string{"http://localhost:4567", "http://127.0.0.1:8080", "https://localhost:4000", "http://localhost", "null"} {
+			req := httptest.NewRequest("POST", "http://localhost:4000/api/bitrise-yml", nil)
+			req.Header.Set(APITokenHeader, "secret-token")
+			req.Header.Set("Origin", origin)
+			require.Equal(t, http.StatusForbidden, serve(req).Code, origin)
+		}
+	}
+}
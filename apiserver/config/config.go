@@ -3,6 +3,8 @@ package config
 const (
 	// DefaultPort ...
 	DefaultPort = "3645"
+	// DefaultBindAddress is loopback, so the editor is only reachable from this machine by default.
+	DefaultBindAddress = "127.0.0.1"
 	// DefaultFrontendHost ...
 	DefaultFrontendHost = "localhost"
 	// DefaultFrontendPort ...
@@ -14,9 +16,31 @@ const (
 	MinimalValidBitriseYML = "format_version: 1.3.0"
 )
 
+// Project is one bitrise config (and its secrets) served by the editor.
+type Project struct {
+	ID             string `json:"id"`
+	BitriseYMLPath string `json:"bitrise_yml_path"`
+	SecretsYMLPath string `json:"secrets_yml_path"`
+}
+
 var (
-	// BitriseYMLPath ...
+	// BitriseYMLPath is the config of the default project, served by the unscoped /api routes.
 	BitriseYMLPath string
-	// SecretsYMLPath ...
+	// SecretsYMLPath is the secrets file of the default project.
 	SecretsYMLPath string
+	// SecretsPassphrase encrypts the secrets files at rest; they are written in plaintext if empty.
+	SecretsPassphrase string
+	// StrictSecretScan rejects config saves with possible secrets in them instead of warning.
+	StrictSecretScan bool
+	// StacksCatalogPath is a stacks and machines catalog replacing the bundled one, if set.
+	StacksCatalogPath string
+	// SecretProvidersPath is the secret providers config; no secrets are provided if empty. It's
+	// set by the user, never read from a project, as providers run commands and send tokens.
+	SecretProvidersPath string
+	// IncludeMirrorDir is a directory of local git mirrors/checkouts used to resolve cross-repository
+	// includes offline. Repositories not mirrored there are fetched into IncludeCacheDir.
+	IncludeMirrorDir string
+	// IncludeCacheDir holds the commits fetched to resolve cross-repository includes; such includes
+	// can't be resolved over the network if empty.
+	IncludeCacheDir string
 )
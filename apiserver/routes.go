@@ -17,24 +17,20 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// devServerHostPort is where the frontend dev server listens.
+func devServerHostPort() (string, string) {
+	return utility.EnvString("DEV_SERVER_HOST", config.DefaultFrontendHost), utility.EnvString("DEV_SERVER_PORT", config.DefaultFrontendPort)
+}
+
 // SetupRoutes ...
 func SetupRoutes(isServeFilesThroughMiddlemanServer bool) (*mux.Router, error) {
 	r := mux.NewRouter()
 
-	r.HandleFunc("/api/bitrise-yml", wrapHandlerFunc(service.GetBitriseYMLHandler)).Methods("GET")
-	r.HandleFunc("/api/bitrise-yml", wrapHandlerFunc(service.PostBitriseYMLHandler)).Methods("POST")
-
-	r.HandleFunc("/api/bitrise-yml.json", wrapHandlerFunc(service.GetBitriseYMLAsJSONHandler)).Methods("GET")
-	r.HandleFunc("/api/bitrise-yml.json", wrapHandlerFunc(service.PostBitriseYMLFromJSONHandler)).Methods("POST")
-
-	// Modular config (include tree): resolve the tree from disk, save changed module files, and
-	// merge the live tree. The FE drives these in local mode exactly like the hosted /config/tree.
-	r.HandleFunc("/api/bitrise-yml/tree", wrapHandlerFunc(service.GetBitriseYMLTreeHandler)).Methods("GET")
-	r.HandleFunc("/api/bitrise-yml/tree", wrapHandlerFunc(service.PostBitriseYMLTreeHandler)).Methods("POST")
-	r.HandleFunc("/api/bitrise-yml/tree/merge", wrapHandlerFunc(service.PostBitriseYMLTreeMergeHandler)).Methods("POST")
-
-	r.HandleFunc("/api/secrets", wrapHandlerFunc(service.GetSecretsAsJSONHandler)).Methods("GET")
-	r.HandleFunc("/api/secrets", wrapHandlerFunc(service.PostSecretsYMLFromJSONHandler)).Methods("POST")
+	// The unscoped routes serve the default project; every project is also reachable under
+	// /api/projects/{project_id}/....
+	registerProjectRoutes(r, "/api", func(h http.Handler) http.Handler { return h })
+	registerProjectRoutes(r, "/api/projects/{project_id}", service.ProjectScopeMiddleware)
+	r.HandleFunc("/api/projects", wrapHandlerFunc(service.GetProjectsHandler)).Methods("GET")
 
 	r.HandleFunc("/api/default-outputs", wrapHandlerFunc(service.GetDefaultOutputsHandler)).Methods("GET")
 
@@ -44,14 +40,33 @@ func SetupRoutes(isServeFilesThroughMiddlemanServer bool) (*mux.Router, error) {
 	r.HandleFunc("/api/connection", wrapHandlerFunc(service.DeleteConnectionHandler)).Methods("DELETE")
 	r.HandleFunc("/api/connection", wrapHandlerFunc(service.PostConnectionHandler)).Methods("POST")
 
+	// Multi-tab awareness: who is connected, and advisory edit locks per file or tree node.
+	r.HandleFunc("/api/sessions", wrapHandlerFunc(service.GetSessionsHandler)).Methods("GET")
+	r.HandleFunc("/api/locks", wrapHandlerFunc(service.PostEditLockHandler)).Methods("POST")
+	r.HandleFunc("/api/locks", wrapHandlerFunc(service.DeleteEditLockHandler)).Methods("DELETE")
+
+	// Local workflow runs: started per project (see registerProjectRoutes), history and logs are global.
+	r.HandleFunc("/api/runs", wrapHandlerFunc(service.GetRunsHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{run_id}", wrapHandlerFunc(service.GetRunHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{run_id}/events", wrapHandlerFunc(service.GetRunEventsHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{run_id}/cancel", wrapHandlerFunc(service.PostCancelRunHandler)).Methods("POST")
+
+	r.HandleFunc("/api/run-if/evaluate", wrapHandlerFunc(service.PostEvaluateRunIfHandler)).Methods("POST")
+
+	// Starter config templates, also used by `workflow-editor init`.
+	r.HandleFunc("/api/templates", wrapHandlerFunc(service.GetTemplatesHandler)).Methods("GET")
+	r.HandleFunc("/api/templates/render", wrapHandlerFunc(service.PostRenderTemplateHandler)).Methods("POST")
+
+	// Stacks and machine types for the stack pickers, from the bundled or --stacks-catalog catalog.
+	r.HandleFunc("/api/stacks-and-machines", wrapHandlerFunc(service.GetStacksAndMachinesHandler)).Methods("GET")
+
 	r.HandleFunc("/api/cli/format", wrapHandlerFunc(service.PostFormatHandler)).Methods("POST")
 
 	var assetServer http.Handler
 
 	// Anything else: pass to the frontend
 	if isServeFilesThroughMiddlemanServer {
-		frontendServerHost := utility.EnvString("DEV_SERVER_HOST", config.DefaultFrontendHost)
-		frontendServerPort := utility.EnvString("DEV_SERVER_PORT", config.DefaultFrontendPort)
+		frontendServerHost, frontendServerPort := devServerHostPort()
 
 		log.Printf("Starting reverse proxy for frontend => http://%s:%s", frontendServerHost, frontendServerPort)
 
@@ -103,6 +118,47 @@ func SetupRoutes(isServeFilesThroughMiddlemanServer bool) (*mux.Router, error) {
 	return r, nil
 }
 
+// registerProjectRoutes registers the routes working on a project's config and secrets under prefix.
+func registerProjectRoutes(r *mux.Router, prefix string, scope func(http.Handler) http.Handler) {
+	handle := func(path, method string, h func(http.ResponseWriter, *http.Request)) {
+		r.Handle(prefix+path, scope(http.HandlerFunc(wrapHandlerFunc(h)))).Methods(method)
+	}
+
+	handle("/bitrise-yml", "GET", service.GetBitriseYMLHandler)
+	handle("/bitrise-yml", "POST", service.PostBitriseYMLHandler)
+
+	handle("/bitrise-yml.json", "GET", service.GetBitriseYMLAsJSONHandler)
+	handle("/bitrise-yml.json", "POST", service.PostBitriseYMLFromJSONHandler)
+
+	// Modular config (include tree): resolve the tree from disk, save changed module files, and
+	// merge the live tree. The FE drives these in local mode exactly like the hosted /config/tree.
+	handle("/bitrise-yml/tree", "GET", service.GetBitriseYMLTreeHandler)
+	handle("/bitrise-yml/tree", "POST", service.PostBitriseYMLTreeHandler)
+	handle("/bitrise-yml/tree/merge", "POST", service.PostBitriseYMLTreeMergeHandler)
+
+	handle("/runs", "POST", service.PostRunHandler)
+	handle("/runs/plan", "POST", service.PostExecutionPlanHandler)
+	handle("/triggers/simulate", "POST", service.PostSimulateTriggersHandler)
+	handle("/run-if/lint", "POST", service.PostLintRunIfsHandler)
+
+	handle("/project-recommendations", "GET", service.GetProjectRecommendationsHandler)
+
+	// App and workflow env vars as items, written to the module defining them.
+	handle("/env-vars", "GET", service.GetEnvVarsHandler)
+	for _, scope := range []string{"/env-vars/app", "/env-vars/workflows/{workflow}"} {
+		handle(scope, "POST", service.PostEnvVarHandler)
+		handle(scope, "PUT", service.PutEnvVarsOrderHandler)
+		handle(scope+"/{key}", "PUT", service.PutEnvVarHandler)
+		handle(scope+"/{key}", "DELETE", service.DeleteEnvVarHandler)
+	}
+
+	handle("/secrets", "GET", service.GetSecretsAsJSONHandler)
+	handle("/secrets", "POST", service.PostSecretsYMLFromJSONHandler)
+	handle("/secrets/{key}", "GET", service.GetSecretValueHandler)
+	handle("/secrets/usage", "POST", service.PostSecretUsageHandler)
+	handle("/secrets/move-leak", "POST", service.PostMoveSecretLeakHandler)
+}
+
 func wrapHandlerFunc(h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	requestWrap := func(w http.ResponseWriter, req *http.Request) {
 		startTime := time.Now()
@@ -0,0 +1,182 @@
+// Package secrets reads and writes secrets files, optionally encrypted at rest with a key derived
+// from a passphrase (scrypt + AES-256-GCM). Encrypted files are decrypted in memory; the one
+// exception is a local run, which hands the bitrise CLI a plaintext inventory (see prepareRun).
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// FilePerm is the permission secrets files are written with.
+const FilePerm = 0600
+
+// header starts every encrypted file, and is authenticated along with the ciphertext.
+const header = "bitrise-workflow-editor-secrets:v1:scrypt"
+
+const (
+	saltSize = 16
+	keySize  = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	// ErrPassphraseRequired is returned when reading an encrypted file without a passphrase.
+	ErrPassphraseRequired = errors.New("the secrets file is encrypted, set BITRISE_SECRETS_PASSPHRASE to decrypt it")
+	// ErrWrongPassphrase is returned when the file can't be decrypted with the passphrase.
+	ErrWrongPassphrase = errors.New("failed to decrypt the secrets file: wrong passphrase or corrupted file")
+)
+
+// IsEncrypted reports whether cont is an encrypted secrets file.
+func IsEncrypted(cont []byte) bool {
+	return bytes.HasPrefix(cont, []byte(header+":"))
+}
+
+// Encrypt encrypts plaintext with a key derived from passphrase and a random salt.
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, []byte(header))
+	encoding := base64.StdEncoding
+	return []byte(fmt.Sprintf("%s:%s:%s:%s\n", header, encoding.EncodeToString(salt), encoding.EncodeToString(nonce), encoding.EncodeToString(ciphertext))), nil
+}
+
+// Decrypt decrypts an encrypted secrets file.
+func Decrypt(cont []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(cont) {
+		return nil, errors.New("not an encrypted secrets file")
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	parts := strings.Split(strings.TrimSpace(strings.TrimPrefix(string(cont), header+":")), ":")
+	if len(parts) != 3 {
+		return nil, ErrWrongPassphrase
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(part); err != nil {
+			return nil, ErrWrongPassphrase
+		}
+	}
+	salt, nonce, ciphertext := decoded[0], decoded[1], decoded[2]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(header))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadFile returns the plaintext content of a secrets file, decrypting it if it's encrypted.
+func ReadFile(pth, passphrase string) ([]byte, error) {
+	cont, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(cont) {
+		return cont, nil
+	}
+	return Decrypt(cont, passphrase)
+}
+
+// WriteFile writes a secrets file with FilePerm, encrypted if passphrase isn't empty. The content
+// goes to a temp file in the same directory first, which then replaces the file, so the secrets are
+// never readable with the old file's permission and a failed write leaves the old file intact.
+func WriteFile(pth string, plaintext []byte, passphrase string) error {
+	cont := plaintext
+	if passphrase != "" {
+		var err error
+		if cont, err = Encrypt(plaintext, passphrase); err != nil {
+			return err
+		}
+	}
+
+	// A symlinked secrets file is replaced at its target, keeping the link.
+	if target, err := filepath.EvalSymlinks(pth); err == nil {
+		pth = target
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(pth), "."+filepath.Base(pth)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPth := tmp.Name()
+	defer func() {
+		if tmpPth != "" {
+			_ = os.Remove(tmpPth)
+		}
+	}()
+
+	if err := tmp.Chmod(FilePerm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(cont); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPth, pth); err != nil {
+		return err
+	}
+	tmpPth = ""
+	return nil
+}
@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plaintext := []byte("envs:\n- API_TOKEN: s3cr3t\n")
+
+	encrypted, err := Encrypt(plaintext, "passphrase")
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(encrypted))
+	require.NotContains(t, string(encrypted), "s3cr3t")
+
+	t.Log("decrypts with the passphrase")
+	{
+		decrypted, err := Decrypt(encrypted, "passphrase")
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+	}
+
+	t.Log("wrong or missing passphrase")
+	{
+		_, err := Decrypt(encrypted, "other")
+		require.Equal(t, ErrWrongPassphrase, err)
+
+		_, err = Decrypt(encrypted, "")
+		require.Equal(t, ErrPassphraseRequired, err)
+	}
+
+	t.Log("tampered file")
+	{
+		tampered := append([]byte{}, encrypted...)
+		tampered[len(tampered)-3] ^= 1
+		_, err := Decrypt(tampered, "passphrase")
+		require.Error(t, err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), ".bitrise.secrets.yml")
+	require.NoError(t, os.WriteFile(pth, []byte("envs: []"), 0644))
+
+	require.NoError(t, WriteFile(pth, []byte("envs:\n- KEY: value\n"), "passphrase"))
+	info, err := os.Stat(pth)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(FilePerm), info.Mode().Perm())
+
+	_, err = ReadFile(pth, "")
+	require.Equal(t, ErrPassphraseRequired, err)
+	cont, err := ReadFile(pth, "passphrase")
+	require.NoError(t, err)
+	require.Equal(t, "envs:\n- KEY: value\n", string(cont))
+
+	require.NoError(t, WriteFile(pth, []byte("envs: []"), ""))
+	cont, err = ReadFile(pth, "")
+	require.NoError(t, err)
+	require.Equal(t, "envs: []", string(cont))
+
+	t.Log("no temp files are left behind")
+	{
+		entries, err := os.ReadDir(filepath.Dir(pth))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	}
+
+	t.Log("a symlinked secrets file is written at its target")
+	{
+		dir := t.TempDir()
+		target := filepath.Join(dir, "secrets.yml")
+		require.NoError(t, os.WriteFile(target, []byte("envs: []"), 0644))
+		link := filepath.Join(dir, ".bitrise.secrets.yml")
+		require.NoError(t, os.Symlink(target, link))
+
+		require.NoError(t, WriteFile(link, []byte("envs:\n- KEY: value\n"), ""))
+		info, err := os.Lstat(link)
+		require.NoError(t, err)
+		require.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+		cont, err := os.ReadFile(target)
+		require.NoError(t, err)
+		require.Equal(t, "envs:\n- KEY: value\n", string(cont))
+	}
+}
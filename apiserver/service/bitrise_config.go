@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 
@@ -36,9 +37,10 @@ func HasConfigVersionConflict(r *http.Request, contStr string) bool {
 
 // GetBitriseYMLHandler ...
 func GetBitriseYMLHandler(w http.ResponseWriter, r *http.Request) {
-	contStr, err := fileutil.ReadStringFromFile(config.BitriseYMLPath)
+	project := projectFor(r)
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 	if err != nil {
-		log.Errorf("Failed to read bitrise.yml (%s), error: %s", config.BitriseYMLPath, err)
+		log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to read content of bitrise.yml file, error: %s", err)
 		return
 	}
@@ -51,11 +53,18 @@ func GetBitriseYMLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// saveConfigResponse lists the possible secrets of the saved config next to the validation warnings.
+type saveConfigResponse struct {
+	utility.ValidationResponse
+	SecretLeaks []SecretLeak `json:"secret_leaks,omitempty"`
+}
+
 // PostBitriseYMLHandler ...
 func PostBitriseYMLHandler(w http.ResponseWriter, r *http.Request) {
-	contStr, err := fileutil.ReadStringFromFile(config.BitriseYMLPath)
+	project := projectFor(r)
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 	if err != nil {
-		log.Warnf("Failed to read bitrise.yml (%s), error: %s", config.BitriseYMLPath, err)
+		log.Warnf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
 	} else if HasConfigVersionConflict(r, contStr) {
 		w.WriteHeader(http.StatusConflict)
 		return
@@ -90,20 +99,38 @@ func PostBitriseYMLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := fileutil.WriteStringToFile(config.BitriseYMLPath, reqObj.BitriseYML); err != nil {
+	leaks, err := ScanSecretLeaks(filepath.Base(project.BitriseYMLPath), reqObj.BitriseYML, config.StrictSecretScan)
+	if err != nil {
+		log.Errorf("Failed to scan for secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to scan for secrets, error: %s", err)
+		return
+	}
+	if config.StrictSecretScan && len(leaks) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, secretLeaksResponse{
+			Response:    NewErrorResponseWithConfig(reqObj.BitriseYML, "%s", secretLeaksError(leaks)),
+			SecretLeaks: leaks,
+		})
+		return
+	}
+
+	if err := fileutil.WriteStringToFile(project.BitriseYMLPath, reqObj.BitriseYML); err != nil {
 		log.Errorf("Failed to write content into file, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to write content into file, error: %s", err)
 		return
 	}
 
-	RespondWithJSON(w, 200, utility.ValidationResponse{Warnings: warnings})
+	RespondWithJSON(w, 200, saveConfigResponse{
+		ValidationResponse: utility.ValidationResponse{Warnings: withStackWarnings(withSecretLeakWarnings(warnings, leaks), reqObj.BitriseYML)},
+		SecretLeaks:        leaks,
+	})
 }
 
 // GetBitriseYMLAsJSONHandler ...
 func GetBitriseYMLAsJSONHandler(w http.ResponseWriter, r *http.Request) {
-	contStr, err := fileutil.ReadStringFromFile(config.BitriseYMLPath)
+	project := projectFor(r)
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 	if err != nil {
-		log.Errorf("Failed to read bitrise.yml (%s), error: %s", config.BitriseYMLPath, err)
+		log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to read content of bitrise.yml file, error: %s", err)
 		return
 	}
@@ -133,9 +160,10 @@ func GetBitriseYMLAsJSONHandler(w http.ResponseWriter, r *http.Request) {
 
 // PostBitriseYMLFromJSONHandler ...
 func PostBitriseYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
-	contStr, err := fileutil.ReadStringFromFile(config.BitriseYMLPath)
+	project := projectFor(r)
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 	if err != nil {
-		log.Warnf("Failed to read bitrise.yml (%s), error: %s", config.BitriseYMLPath, err)
+		log.Warnf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
 	} else if HasConfigVersionConflict(r, contStr) {
 		w.WriteHeader(http.StatusConflict)
 		return
@@ -177,13 +205,30 @@ func PostBitriseYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := fileutil.WriteBytesToFile(config.BitriseYMLPath, contAsYAML); err != nil {
+	leaks, err := ScanSecretLeaks(filepath.Base(project.BitriseYMLPath), string(contAsYAML), config.StrictSecretScan)
+	if err != nil {
+		log.Errorf("Failed to scan for secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to scan for secrets, error: %s", err)
+		return
+	}
+	if config.StrictSecretScan && len(leaks) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, secretLeaksResponse{
+			Response:    NewErrorResponseWithConfig(string(contAsYAML), "%s", secretLeaksError(leaks)),
+			SecretLeaks: leaks,
+		})
+		return
+	}
+
+	if err := fileutil.WriteBytesToFile(project.BitriseYMLPath, contAsYAML); err != nil {
 		log.Errorf("Failed to write content into file, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to write content into file, error: %s", err)
 		return
 	}
 
-	RespondWithJSON(w, 200, utility.ValidationResponse{Warnings: warnings})
+	RespondWithJSON(w, 200, saveConfigResponse{
+		ValidationResponse: utility.ValidationResponse{Warnings: withStackWarnings(withSecretLeakWarnings(warnings, leaks), string(contAsYAML))},
+		SecretLeaks:        leaks,
+	})
 }
 
 // PostFormatHandler ...
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/bitrise-io/bitrise/v2/configmerge"
+	"github.com/bitrise-io/bitrise/v2/models"
+)
+
+const (
+	mergedSubtreeCacheSize  = 1024
+	resolvedModuleCacheSize = 1024
+)
+
+// lruCache is a small, size-bounded, concurrency-safe string cache.
+type lruCache struct {
+	mu      sync.Mutex
+	limit   int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruCacheEntry struct {
+	key   string
+	value string
+}
+
+func newLRUCache(limit int) *lruCache {
+	return &lruCache{limit: limit, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lruCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruCacheEntry).value, true
+}
+
+func (c *lruCache) put(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruCacheEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruCacheEntry{key: key, value: value})
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+var (
+	// mergedSubtrees maps a subtree hash (the node's path and contents plus its children's hashes)
+	// to that subtree's merged YAML, so re-merging an edited tree only redoes the changed branches.
+	mergedSubtrees = newLRUCache(mergedSubtreeCacheSize)
+	// resolvedModules holds module contents read at a resolved commit, which never change.
+	resolvedModules = newLRUCache(resolvedModuleCacheSize)
+)
+
+// mergeWireTree merges the tree bottom-up, reusing the cached merge of every unchanged subtree.
+// Unresolved nodes (see wireTreeNode.Error) are left out of the merge.
+func mergeWireTree(root wireTreeNode) (string, error) {
+	merged, _, err := mergeSubtree(root)
+	return merged, err
+}
+
+func mergeSubtree(node wireTreeNode) (string, string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(node.Path))
+	hash.Write([]byte{0})
+	hash.Write([]byte(node.Contents))
+
+	// A merged subtree stands in for the subtree itself: merging a node with its children's merged
+	// configs as leaves gives the same result as merging the full tree.
+	leaves := make([]models.ConfigFileTreeModel, 0, len(node.Includes))
+	for _, child := range node.Includes {
+		if child.Error != "" {
+			continue
+		}
+		childMerged, childHash, err := mergeSubtree(child)
+		if err != nil {
+			return "", "", err
+		}
+		hash.Write([]byte{0})
+		hash.Write([]byte(childHash))
+		leaves = append(leaves, models.ConfigFileTreeModel{Path: child.Path, Contents: childMerged})
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	if merged, ok := mergedSubtrees.get(key); ok {
+		return merged, key, nil
+	}
+
+	tree := models.ConfigFileTreeModel{Path: node.Path, Contents: node.Contents, Includes: leaves}
+	merged, err := tree.Merge()
+	if err != nil {
+		return "", "", err
+	}
+	mergedSubtrees.put(key, merged)
+	return merged, key, nil
+}
+
+func resolvedModuleKey(repoDir, commit, path string) string {
+	return repoDir + "\x00" + commit + "\x00" + path
+}
+
+// mergeConfigFromDisk is the bitrise CLI's MergeConfig. Each merge gets a new config reader: a
+// reader caches the repositories it fetched by ref, so a long-lived one would keep serving the
+// first fetch of a branch included by ref.
+func mergeConfigFromDisk(pth string) (string, *models.ConfigFileTreeModel, error) {
+	reader, err := configmerge.NewConfigReader(configMergeLogger())
+	if err != nil {
+		return "", nil, err
+	}
+	return configmerge.NewMerger(reader, configMergeLogger()).MergeConfig(pth)
+}
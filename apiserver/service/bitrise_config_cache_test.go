@@ -0,0 +1,98 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/bitrise/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2)
+	cache.put("a", "1")
+	cache.put("b", "2")
+
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	cache.put("c", "3")
+	_, ok = cache.get("b")
+	require.False(t, ok, "least recently used entry should be evicted")
+
+	value, ok := cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, "1", value)
+	require.Equal(t, 2, cache.len())
+}
+
+func TestMergeWireTree_reusesUnchangedSubtrees(t *testing.T) {
+	mergedSubtrees = newLRUCache(mergedSubtreeCacheSize)
+
+	root := wireTreeNode{
+		Path:     "bitrise.yml",
+		Contents: "format_version: \"13\"\ninclude:\n  - path: a.yml\n  - path: b.yml\n",
+		Includes: []wireTreeNode{
+			{Path: "a.yml", Contents: "workflows:\n  a: {}\n", Includes: []wireTreeNode{}},
+			{Path: "b.yml", Contents: "workflows:\n  b: {}\n", Includes: []wireTreeNode{}},
+		},
+	}
+
+	merged, err := mergeWireTree(root)
+	require.NoError(t, err)
+	require.Contains(t, merged, "a: {}")
+	require.Contains(t, merged, "b: {}")
+	require.Equal(t, 3, mergedSubtrees.len())
+
+	t.Log("an unchanged tree is served entirely from the cache")
+	again, err := mergeWireTree(root)
+	require.NoError(t, err)
+	require.Equal(t, merged, again)
+	require.Equal(t, 3, mergedSubtrees.len())
+
+	t.Log("editing one module re-merges only that module and its ancestors")
+	root.Includes[1].Contents = "workflows:\n  b2: {}\n"
+	edited, err := mergeWireTree(root)
+	require.NoError(t, err)
+	require.Contains(t, edited, "b2: {}")
+	require.NotContains(t, edited, "b: {}")
+	require.Equal(t, 5, mergedSubtrees.len())
+}
+
+func TestMergeWireTree_matchesFullMerge(t *testing.T) {
+	mergedSubtrees = newLRUCache(mergedSubtreeCacheSize)
+
+	root := wireTreeNode{
+		Path:     "bitrise.yml",
+		Contents: "format_version: \"13\"\ninclude:\n  - path: a.yml\n  - path: b.yml\ntitle: root\nworkflows:\n  shared:\n    title: from root\n",
+		Includes: []wireTreeNode{
+			{
+				Path:     "a.yml",
+				Contents: "include:\n  - path: a1.yml\ntitle: a\nworkflows:\n  shared:\n    title: from a\n    summary: from a\n  a: {}\n",
+				Includes: []wireTreeNode{
+					{Path: "a1.yml", Contents: "title: a1\nworkflows:\n  shared:\n    summary: from a1\n    description: from a1\n", Includes: []wireTreeNode{}},
+				},
+			},
+			{Path: "b.yml", Contents: "workflows:\n  shared:\n    summary: from b\n  b: {}\n", Includes: []wireTreeNode{}},
+		},
+	}
+
+	var toConfigFileTree func(node wireTreeNode) models.ConfigFileTreeModel
+	toConfigFileTree = func(node wireTreeNode) models.ConfigFileTreeModel {
+		tree := models.ConfigFileTreeModel{Path: node.Path, Contents: node.Contents}
+		for _, child := range node.Includes {
+			tree.Includes = append(tree.Includes, toConfigFileTree(child))
+		}
+		return tree
+	}
+	expected, err := toConfigFileTree(root).Merge()
+	require.NoError(t, err)
+
+	merged, err := mergeWireTree(root)
+	require.NoError(t, err)
+	require.Equal(t, expected, merged)
+
+	t.Log("from the cache too")
+	merged, err = mergeWireTree(root)
+	require.NoError(t, err)
+	require.Equal(t, expected, merged)
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/tools"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// maxIncludeDepth bounds how deep the mirror resolver follows nested includes, so a
+// misconfigured module can't send it down an unbounded chain.
+const maxIncludeDepth = 5
+
+// includeEntry is a single item of a config module's `include:` list.
+type includeEntry struct {
+	Path       string `yaml:"path"`
+	Repository string `yaml:"repository"`
+	Branch     string `yaml:"branch"`
+	Tag        string `yaml:"tag"`
+	Commit     string `yaml:"commit"`
+}
+
+// key builds the same reference key configmerge uses for tree nodes, so nodes resolved from a
+// mirror get the same node ids (and parse back with parseNodeKey) as ones resolved by the CLI.
+func (e includeEntry) key() string {
+	key := ""
+	if e.Repository != "" {
+		key += "repo:" + e.Repository + ","
+	}
+	key += e.Path
+	switch {
+	case e.Commit != "":
+		key += "@commit:" + e.Commit
+	case e.Tag != "":
+		key += "@tag:" + e.Tag
+	case e.Branch != "":
+		key += "@branch:" + e.Branch
+	}
+	return key
+}
+
+func (e includeEntry) hasRef() bool {
+	return e.Branch != "" || e.Tag != "" || e.Commit != ""
+}
+
+// revisions lists the git revisions to try, in order, when reading this entry from a repository.
+func (e includeEntry) revisions() []string {
+	switch {
+	case e.Commit != "":
+		return []string{e.Commit}
+	case e.Tag != "":
+		return []string{"refs/tags/" + e.Tag}
+	case e.Branch != "":
+		return []string{"refs/heads/" + e.Branch, "refs/remotes/origin/" + e.Branch}
+	default:
+		return []string{"HEAD"}
+	}
+}
+
+// remoteRefs lists the refs to resolve, in order, when reading this entry from a remote repository.
+// An annotated tag's peeled ref points at its commit.
+func (e includeEntry) remoteRefs() []string {
+	switch {
+	case e.Tag != "":
+		return []string{"refs/tags/" + e.Tag + "^{}", "refs/tags/" + e.Tag}
+	case e.Branch != "":
+		return []string{"refs/heads/" + e.Branch}
+	default:
+		return []string{"HEAD"}
+	}
+}
+
+func (e includeEntry) refDescription() string {
+	switch {
+	case e.Commit != "":
+		return "commit " + e.Commit
+	case e.Tag != "":
+		return "tag " + e.Tag
+	case e.Branch != "":
+		return "branch " + e.Branch
+	default:
+		return "the default branch"
+	}
+}
+
+func parseIncludes(contents string) ([]includeEntry, error) {
+	var cfg struct {
+		Include []includeEntry `yaml:"include"`
+	}
+	if err := yaml.Unmarshal([]byte(contents), &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Include, nil
+}
+
+// mirrorTreeResolver resolves the include tree: local includes are read from the working repo,
+// cross-repo includes from git mirrors/checkouts under mirrorDir, or else fetched by commit into
+// config.IncludeCacheDir. A node that can't be resolved carries an error instead of failing the
+// whole tree.
+type mirrorTreeResolver struct {
+	repoRoot  string
+	mirrorDir string
+}
+
+func (r mirrorTreeResolver) resolve(rootPath, rootContents string) wireTreeNode {
+	return wireTreeNode{
+		NodeID:   nodeID(rootPath),
+		Path:     rootPath,
+		Contents: rootContents,
+		Editable: true,
+		Includes: r.resolveIncludes(rootContents, includeEntry{}, []string{rootPath}),
+	}
+}
+
+func (r mirrorTreeResolver) resolveIncludes(contents string, parent includeEntry, ancestors []string) []wireTreeNode {
+	nodes := []wireTreeNode{}
+
+	entries, err := parseIncludes(contents)
+	if err != nil {
+		// The parent's own YAML is broken; the merge reports that, there is nothing to follow here.
+		return nodes
+	}
+
+	for _, entry := range entries {
+		// A module included from another repository refers to its siblings in that same repository
+		// and ref unless it names a repository of its own.
+		if entry.Repository == "" && parent.Repository != "" {
+			entry.Repository = parent.Repository
+			if !entry.hasRef() {
+				entry.Branch, entry.Tag, entry.Commit = parent.Branch, parent.Tag, parent.Commit
+			}
+		}
+		nodes = append(nodes, r.resolveNode(entry, ancestors))
+	}
+	return nodes
+}
+
+func (r mirrorTreeResolver) resolveNode(entry includeEntry, ancestors []string) wireTreeNode {
+	key := entry.key()
+	path, source, editable := parseNodeKey(key)
+	node := wireTreeNode{
+		NodeID:   nodeID(key),
+		Path:     path,
+		Source:   &source,
+		Editable: editable,
+		Includes: []wireTreeNode{},
+	}
+
+	for _, ancestor := range ancestors {
+		if ancestor == key {
+			node.Error = fmt.Sprintf("include cycle: %s includes itself", key)
+			return node
+		}
+	}
+	if len(ancestors) > maxIncludeDepth {
+		node.Error = fmt.Sprintf("include depth exceeds %d", maxIncludeDepth)
+		return node
+	}
+
+	contents, commit, err := r.read(entry)
+	if err != nil {
+		log.Warnf("Failed to resolve include (%s), error: %s", key, err)
+		node.Error = err.Error()
+		return node
+	}
+
+	node.Contents = contents
+	node.CommitSha = commit
+	node.Includes = r.resolveIncludes(contents, entry, append(ancestors, key))
+	return node
+}
+
+func (r mirrorTreeResolver) read(entry includeEntry) (contents string, commit string, err error) {
+	if entry.Repository == "" && !entry.hasRef() {
+		// Local includes are confined to the repository like module writes are.
+		pth, err := sandboxedModulePath(r.repoRoot, entry.Path)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid include path (%s): %w", entry.Path, err)
+		}
+		contents, err := fileutil.ReadStringFromFile(pth)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %w", entry.Path, err)
+		}
+		return contents, "", nil
+	}
+
+	if err := validateModulePath(entry.Path); err != nil {
+		return "", "", fmt.Errorf("invalid include path (%s): %w", entry.Path, err)
+	}
+
+	// A pinned ref in the working repo is read from the working repo's own git history.
+	repoDir := r.repoRoot
+	if entry.Repository != "" {
+		var found bool
+		if r.mirrorDir != "" {
+			repoDir, found = mirrorRepoDir(r.mirrorDir, entry.Repository)
+		}
+		switch {
+		case found:
+		case config.IncludeCacheDir != "":
+			return readRemoteModule(entry)
+		case r.mirrorDir == "":
+			return "", "", fmt.Errorf("repository %s can't be resolved: no include mirror directory configured", entry.Repository)
+		default:
+			return "", "", fmt.Errorf("repository %s is not mirrored in %s", entry.Repository, r.mirrorDir)
+		}
+	}
+
+	commit, err = tools.GitResolveCommit(repoDir, entry.revisions()...)
+	if errors.Is(err, tools.ErrGitRefNotFound) {
+		return "", "", fmt.Errorf("%s is not available locally in %s", entry.refDescription(), repoDir)
+	} else if err != nil {
+		return "", "", err
+	}
+
+	cacheKey := resolvedModuleKey(repoDir, commit, entry.Path)
+	if contents, ok := resolvedModules.get(cacheKey); ok {
+		return contents, commit, nil
+	}
+
+	contents, err = tools.GitShowFile(repoDir, commit, entry.Path)
+	if err != nil {
+		return "", "", err
+	}
+	resolvedModules.put(cacheKey, contents)
+	return contents, commit, nil
+}
+
+// DefaultIncludeCacheDir is where cross-repo include commits are fetched to: the user cache dir, or
+// the temp dir if there is none.
+func DefaultIncludeCacheDir() string {
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "bitrise-workflow-editor", "includes")
+	}
+	return filepath.Join(os.TempDir(), "bitrise-workflow-editor-includes")
+}
+
+// remoteFetchLock serializes fetches into config.IncludeCacheDir, as concurrent fetches into one
+// repository fail on git's locks.
+var remoteFetchLock sync.Mutex
+
+// readRemoteModule reads an include from its remote repository. The ref is resolved to a commit on
+// every read, so a branch include follows the branch; the module contents are cached by commit.
+func readRemoteModule(entry includeEntry) (contents string, commit string, err error) {
+	commit = entry.Commit
+	if commit == "" {
+		commit, err = tools.GitLsRemote(entry.Repository, entry.remoteRefs()...)
+		if errors.Is(err, tools.ErrGitRefNotFound) {
+			return "", "", fmt.Errorf("%s is not found in %s", entry.refDescription(), entry.Repository)
+		} else if err != nil {
+			return "", "", err
+		}
+	}
+
+	cacheKey := resolvedModuleKey(entry.Repository, commit, entry.Path)
+	if contents, ok := resolvedModules.get(cacheKey); ok {
+		return contents, commit, nil
+	}
+
+	sum := sha256.Sum256([]byte(entry.Repository))
+	repoDir := filepath.Join(config.IncludeCacheDir, hex.EncodeToString(sum[:8])+".git")
+	remoteFetchLock.Lock()
+	err = tools.GitFetchCommit(repoDir, entry.Repository, commit)
+	remoteFetchLock.Unlock()
+	if err != nil {
+		return "", "", err
+	}
+
+	contents, err = tools.GitShowFile(repoDir, commit, entry.Path)
+	if err != nil {
+		return "", "", err
+	}
+	resolvedModules.put(cacheKey, contents)
+	return contents, commit, nil
+}
+
+// mirrorRepoDir finds the mirror of `repository` under mirrorDir. The repository may be given as
+// a bare name, an owner/name slug or a clone URL; both `<name>` checkouts and `<name>.git` bare
+// mirrors are accepted.
+func mirrorRepoDir(mirrorDir, repository string) (string, bool) {
+	name := strings.TrimSuffix(strings.TrimRight(repository, "/"), ".git")
+	candidates := []string{name}
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		candidates = append(candidates, name[i+1:])
+	}
+
+	for _, candidate := range candidates {
+		for _, dir := range []string{candidate, candidate + ".git"} {
+			pth := filepath.Join(mirrorDir, filepath.FromSlash(dir))
+			if !strings.HasPrefix(pth, filepath.Clean(mirrorDir)+string(filepath.Separator)) {
+				continue
+			}
+			if info, err := os.Stat(pth); err == nil && info.IsDir() {
+				return pth, true
+			}
+		}
+	}
+	return "", false
+}
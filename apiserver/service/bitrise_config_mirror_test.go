@@ -0,0 +1,181 @@
+package service
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/stretchr/testify/require"
+)
+
+func gitInDir(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+// createMirror sets up `<mirrorDir>/<name>` as a git checkout with `files` committed on `main`
+// and tagged `v1.0.0`.
+func createMirror(t *testing.T, mirrorDir, name string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(mirrorDir, name)
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	gitInDir(t, dir, "init", "-q", "-b", "main")
+	for pth, contents := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, pth)), 0o755))
+		require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, pth), contents))
+	}
+	gitInDir(t, dir, "add", "-A")
+	gitInDir(t, dir, "commit", "-q", "-m", "init")
+	gitInDir(t, dir, "tag", "v1.0.0")
+}
+
+func TestIncludeEntryKey(t *testing.T) {
+	require.Equal(t, "modules/wf.yml", includeEntry{Path: "modules/wf.yml"}.key())
+	require.Equal(t, "repo:shared,shared/build.yml@branch:main", includeEntry{Path: "shared/build.yml", Repository: "shared", Branch: "main"}.key())
+	require.Equal(t, "pinned/release.yml@tag:v1", includeEntry{Path: "pinned/release.yml", Tag: "v1", Branch: "main"}.key())
+}
+
+func TestMirrorTreeResolver(t *testing.T) {
+	repoRoot := t.TempDir()
+	mirrorDir := t.TempDir()
+	createMirror(t, mirrorDir, "shared-modules", map[string]string{
+		"shared/build.yml":  "include:\n  - path: shared/steps.yml\nworkflows:\n  build: {}\n",
+		"shared/steps.yml":  "step_bundles:\n  setup: {}\n",
+		"shared/deploy.yml": "workflows:\n  deploy: {}\n",
+	})
+	require.NoError(t, os.MkdirAll(filepath.Join(repoRoot, "modules"), 0o755))
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(repoRoot, "modules", "local.yml"), "workflows:\n  test: {}\n"))
+
+	rootContents := `format_version: "13"
+include:
+  - path: modules/local.yml
+  - path: shared/build.yml
+    repository: shared-modules
+    branch: main
+  - path: shared/deploy.yml
+    repository: shared-modules
+    tag: v2.0.0
+  - path: other.yml
+    repository: not-mirrored
+`
+	resolver := mirrorTreeResolver{repoRoot: repoRoot, mirrorDir: mirrorDir}
+	root := resolver.resolve("bitrise.yml", rootContents)
+
+	require.Equal(t, "bitrise.yml", root.Path)
+	require.Len(t, root.Includes, 4)
+
+	local := root.Includes[0]
+	require.Empty(t, local.Error)
+	require.True(t, local.Editable)
+	require.Contains(t, local.Contents, "test")
+
+	shared := root.Includes[1]
+	require.Empty(t, shared.Error)
+	require.False(t, shared.Editable)
+	require.Equal(t, "shared/build.yml", shared.Path)
+	require.Equal(t, "shared-modules", *shared.Source.Repository)
+	require.Equal(t, "main", *shared.Source.Branch)
+	require.NotEmpty(t, shared.CommitSha)
+	require.Contains(t, shared.Contents, "build")
+
+	t.Log("nested include inherits the parent's repository and ref")
+	require.Len(t, shared.Includes, 1)
+	nested := shared.Includes[0]
+	require.Empty(t, nested.Error)
+	require.Equal(t, "shared-modules", *nested.Source.Repository)
+	require.Contains(t, nested.Contents, "setup")
+
+	t.Log("missing ref and missing mirror are reported on the node")
+	require.Contains(t, root.Includes[2].Error, "tag v2.0.0 is not available locally")
+	require.Empty(t, root.Includes[2].Contents)
+	require.Contains(t, root.Includes[3].Error, "repository not-mirrored is not mirrored")
+
+	t.Log("unresolved nodes are left out of the merge")
+	merged, err := mergeWireTree(root)
+	require.NoError(t, err)
+	require.Contains(t, merged, "test:")
+	require.Contains(t, merged, "setup:")
+	require.NotContains(t, merged, "deploy:")
+}
+
+func TestMirrorTreeResolver_outsideRepo(t *testing.T) {
+	parent := t.TempDir()
+	repoRoot := filepath.Join(parent, "repo")
+	require.NoError(t, os.MkdirAll(repoRoot, 0o755))
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(parent, "outside.yml"), "workflows:\n  outside: {}\n"))
+	require.NoError(t, os.Symlink(filepath.Join(parent, "outside.yml"), filepath.Join(repoRoot, "link.yml")))
+
+	rootContents := `format_version: "13"
+include:
+  - path: ../outside.yml
+  - path: link.yml
+  - path: /etc/hostname
+`
+	root := mirrorTreeResolver{repoRoot: repoRoot}.resolve("bitrise.yml", rootContents)
+	require.Len(t, root.Includes, 3)
+	require.Contains(t, root.Includes[0].Error, "path points outside the repository")
+	require.Contains(t, root.Includes[1].Error, "path resolves outside the repository")
+	require.Contains(t, root.Includes[2].Error, "absolute path")
+	for _, include := range root.Includes {
+		require.Empty(t, include.Contents)
+	}
+}
+
+func TestMirrorRepoDir(t *testing.T) {
+	mirrorDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(mirrorDir, "bare.git"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(mirrorDir, "checkout"), 0o755))
+
+	dir, found := mirrorRepoDir(mirrorDir, "bare")
+	require.True(t, found)
+	require.Equal(t, filepath.Join(mirrorDir, "bare.git"), dir)
+
+	dir, found = mirrorRepoDir(mirrorDir, "git@github.com:org/checkout.git")
+	require.True(t, found)
+	require.Equal(t, filepath.Join(mirrorDir, "checkout"), dir)
+
+	_, found = mirrorRepoDir(mirrorDir, "../outside")
+	require.False(t, found)
+}
+
+func TestMirrorTreeResolver_remote(t *testing.T) {
+	remoteDir := t.TempDir()
+	createMirror(t, remoteDir, "shared-modules", map[string]string{"shared/build.yml": "workflows:\n  build: {}\n"})
+	remote := filepath.Join(remoteDir, "shared-modules")
+	config.IncludeCacheDir = t.TempDir()
+	defer func() { config.IncludeCacheDir = "" }()
+
+	rootContents := "include:\n  - path: shared/build.yml\n    repository: " + remote + "\n    branch: main\n"
+	resolver := mirrorTreeResolver{repoRoot: t.TempDir()}
+	root := resolver.resolve("bitrise.yml", rootContents)
+	require.Empty(t, root.Includes[0].Error)
+	require.Equal(t, "workflows:\n  build: {}\n", root.Includes[0].Contents)
+	firstCommit := root.Includes[0].CommitSha
+
+	t.Log("a branch include follows the branch")
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(remote, "shared", "build.yml"), "workflows:\n  build2: {}\n"))
+	gitInDir(t, remote, "commit", "-q", "-am", "update")
+	root = resolver.resolve("bitrise.yml", rootContents)
+	require.Empty(t, root.Includes[0].Error)
+	require.Equal(t, "workflows:\n  build2: {}\n", root.Includes[0].Contents)
+	require.NotEqual(t, firstCommit, root.Includes[0].CommitSha)
+
+	t.Log("modules are cached by commit")
+	cached, ok := resolvedModules.get(resolvedModuleKey(remote, root.Includes[0].CommitSha, "shared/build.yml"))
+	require.True(t, ok)
+	require.Equal(t, "workflows:\n  build2: {}\n", cached)
+
+	t.Log("a missing branch is reported on the node")
+	root = resolver.resolve("bitrise.yml", "include:\n  - path: shared/build.yml\n    repository: "+remote+"\n    branch: missing\n")
+	require.Contains(t, root.Includes[0].Error, "branch missing is not found in")
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// entityProvenance records which tree node first defined an entity of the merged config and which
+// nodes later overrode (or extended) it, in merge order.
+type entityProvenance struct {
+	DefinedBy    string   `json:"defined_by"`
+	OverriddenBy []string `json:"overridden_by,omitempty"`
+}
+
+// provenanceIndex maps the entities of the merged config to the node_ids that contributed them.
+// Triggers are keyed as `trigger_map[<conditions> -> <target>]` for trigger_map items and as
+// `workflows.<id>.triggers` / `pipelines.<id>.triggers` for target-based triggers.
+type provenanceIndex struct {
+	Workflows   map[string]*entityProvenance `json:"workflows"`
+	StepBundles map[string]*entityProvenance `json:"step_bundles"`
+	Pipelines   map[string]*entityProvenance `json:"pipelines"`
+	AppEnvs     map[string]*entityProvenance `json:"app_envs"`
+	Triggers    map[string]*entityProvenance `json:"triggers"`
+}
+
+type provenanceModule struct {
+	App struct {
+		Envs []yaml.MapSlice `yaml:"envs"`
+	} `yaml:"app"`
+	TriggerMap  []yaml.MapSlice `yaml:"trigger_map"`
+	Workflows   yaml.MapSlice   `yaml:"workflows"`
+	StepBundles yaml.MapSlice   `yaml:"step_bundles"`
+	Pipelines   yaml.MapSlice   `yaml:"pipelines"`
+}
+
+// triggerMapConditionKeys are the trigger_map item keys that select an event (everything else
+// on the item is the target).
+var triggerMapConditionKeys = []string{
+	"type", "pattern", "push_branch", "pull_request_source_branch", "pull_request_target_branch",
+	"tag", "is_pull_request_allowed", "draft_pull_request_enabled",
+}
+
+func newProvenanceIndex() provenanceIndex {
+	return provenanceIndex{
+		Workflows:   map[string]*entityProvenance{},
+		StepBundles: map[string]*entityProvenance{},
+		Pipelines:   map[string]*entityProvenance{},
+		AppEnvs:     map[string]*entityProvenance{},
+		Triggers:    map[string]*entityProvenance{},
+	}
+}
+
+// buildProvenanceIndex walks the tree in merge order (a node's includes first, then the node itself,
+// so later definitions win) and records the contributing node of every entity. Unresolved nodes and
+// nodes with invalid YAML contribute nothing; the merge reports those.
+func buildProvenanceIndex(root wireTreeNode) provenanceIndex {
+	index := newProvenanceIndex()
+	index.add(root)
+	return index
+}
+
+func (index provenanceIndex) add(node wireTreeNode) {
+	if node.Error != "" {
+		return
+	}
+	for _, child := range node.Includes {
+		index.add(child)
+	}
+
+	var module provenanceModule
+	if err := yaml.Unmarshal([]byte(node.Contents), &module); err != nil {
+		return
+	}
+
+	for _, item := range module.Workflows {
+		id := fmt.Sprint(item.Key)
+		record(index.Workflows, id, node.NodeID)
+		if hasKey(item.Value, "triggers") {
+			record(index.Triggers, "workflows."+id+".triggers", node.NodeID)
+		}
+	}
+	for _, item := range module.StepBundles {
+		record(index.StepBundles, fmt.Sprint(item.Key), node.NodeID)
+	}
+	for _, item := range module.Pipelines {
+		id := fmt.Sprint(item.Key)
+		record(index.Pipelines, id, node.NodeID)
+		if hasKey(item.Value, "triggers") {
+			record(index.Triggers, "pipelines."+id+".triggers", node.NodeID)
+		}
+	}
+	for _, env := range module.App.Envs {
+		for _, item := range env {
+			if key := fmt.Sprint(item.Key); key != "opts" {
+				record(index.AppEnvs, key, node.NodeID)
+			}
+		}
+	}
+	for _, item := range module.TriggerMap {
+		record(index.Triggers, triggerMapItemID(item), node.NodeID)
+	}
+}
+
+func record(entities map[string]*entityProvenance, id, nodeID string) {
+	if existing, ok := entities[id]; ok {
+		existing.OverriddenBy = append(existing.OverriddenBy, nodeID)
+		return
+	}
+	entities[id] = &entityProvenance{DefinedBy: nodeID}
+}
+
+func hasKey(value interface{}, key string) bool {
+	slice, ok := value.(yaml.MapSlice)
+	if !ok {
+		return false
+	}
+	for _, item := range slice {
+		if fmt.Sprint(item.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerMapItemID identifies a trigger_map item by its conditions and target, e.g.
+// `trigger_map[push_branch=main -> workflow:primary]`.
+func triggerMapItemID(item yaml.MapSlice) string {
+	var conditions, targets []string
+	for _, field := range item {
+		key := fmt.Sprint(field.Key)
+		value := fmt.Sprint(field.Value)
+		switch {
+		case key == "workflow" || key == "pipeline":
+			targets = append(targets, key+":"+value)
+		case isTriggerMapConditionKey(key):
+			conditions = append(conditions, key+"="+value)
+		}
+	}
+	sort.Strings(conditions)
+	return fmt.Sprintf("trigger_map[%s -> %s]", strings.Join(conditions, ","), strings.Join(targets, ","))
+}
+
+func isTriggerMapConditionKey(key string) bool {
+	for _, conditionKey := range triggerMapConditionKeys {
+		if key == conditionKey {
+			return true
+		}
+	}
+	return false
+}
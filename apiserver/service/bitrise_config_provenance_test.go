@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildProvenanceIndex(t *testing.T) {
+	root := wireTreeNode{
+		NodeID: "n_root",
+		Path:   "bitrise.yml",
+		Contents: `format_version: "13"
+include:
+  - path: modules/a.yml
+  - path: modules/b.yml
+app:
+  envs:
+  - PROJECT: app
+trigger_map:
+- push_branch: main
+  pipeline: release
+workflows:
+  build:
+    envs:
+    - DEBUG: "true"
+`,
+		Includes: []wireTreeNode{
+			{
+				NodeID: "n_a",
+				Path:   "modules/a.yml",
+				Contents: `app:
+  envs:
+  - PROJECT: base
+    opts:
+      is_expand: false
+workflows:
+  build:
+    steps:
+    - script: {}
+    triggers:
+      push:
+      - branch: main
+step_bundles:
+  setup: {}
+`,
+				Includes: []wireTreeNode{},
+			},
+			{
+				NodeID:   "n_b",
+				Path:     "modules/b.yml",
+				Contents: "pipelines:\n  release:\n    workflows:\n      build: {}\n",
+				Includes: []wireTreeNode{},
+			},
+			{
+				NodeID:   "n_missing",
+				Path:     "shared/x.yml",
+				Error:    "not available",
+				Contents: "workflows:\n  ghost: {}\n",
+				Includes: []wireTreeNode{},
+			},
+		},
+	}
+
+	index := buildProvenanceIndex(root)
+
+	require.Equal(t, &entityProvenance{DefinedBy: "n_a", OverriddenBy: []string{"n_root"}}, index.Workflows["build"])
+	require.Equal(t, &entityProvenance{DefinedBy: "n_a"}, index.StepBundles["setup"])
+	require.Equal(t, &entityProvenance{DefinedBy: "n_b"}, index.Pipelines["release"])
+	require.Equal(t, &entityProvenance{DefinedBy: "n_a", OverriddenBy: []string{"n_root"}}, index.AppEnvs["PROJECT"])
+	require.NotContains(t, index.AppEnvs, "opts")
+	require.Equal(t, &entityProvenance{DefinedBy: "n_a"}, index.Triggers["workflows.build.triggers"])
+	require.Equal(t, &entityProvenance{DefinedBy: "n_root"}, index.Triggers["trigger_map[push_branch=main -> pipeline:release]"])
+	require.NotContains(t, index.Workflows, "ghost")
+}
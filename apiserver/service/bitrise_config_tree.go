@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 
@@ -14,15 +13,14 @@ import (
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
 	"github.com/bitrise-io/bitrise/v2/configmerge"
 	bitriselog "github.com/bitrise-io/bitrise/v2/log"
-	"github.com/bitrise-io/bitrise/v2/models"
 	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 )
 
 // The config tree wire shape mirrors what the Workflow Editor frontend expects from the
 // hosted `/config/tree` endpoint (see BitriseYmlApi.ts `WireTreeNode`), so the same FE
-// code drives cloud and local. The frontend builds the entity index itself from these
-// files, so no index is sent here.
+// code drives cloud and local. Alongside the files the response carries a provenance index
+// (see bitrise_config_provenance.go) telling which node defined or overrode each entity.
 
 type wireTreeNodeSource struct {
 	Path       string  `json:"path"`
@@ -41,12 +39,16 @@ type wireTreeNode struct {
 	Editable  bool                `json:"editable"`
 	Modified  bool                `json:"modified,omitempty"`
 	Includes  []wireTreeNode      `json:"includes"`
+	// Error is set when the node couldn't be resolved (e.g. a ref missing from the local mirror);
+	// such a node has no contents and is left out of the merge.
+	Error string `json:"error,omitempty"`
 }
 
 type getConfigTreeResponse struct {
-	Root      wireTreeNode `json:"root"`
-	MergedYML string       `json:"merged_yml"`
-	Branch    string       `json:"branch"`
+	Root       wireTreeNode    `json:"root"`
+	MergedYML  string          `json:"merged_yml"`
+	Branch     string          `json:"branch"`
+	Provenance provenanceIndex `json:"provenance"`
 }
 
 func configMergeLogger() bitriselog.Logger {
@@ -98,101 +100,71 @@ func parseNodeKey(key string) (path string, source wireTreeNodeSource, editable
 	return path, source, editable
 }
 
-func toWireTreeNode(node models.ConfigFileTreeModel, rootPath string, isRoot bool) wireTreeNode {
-	includes := make([]wireTreeNode, 0, len(node.Includes))
-	for _, child := range node.Includes {
-		includes = append(includes, toWireTreeNode(child, rootPath, false))
-	}
-
-	wire := wireTreeNode{
-		NodeID:   nodeID(node.Path),
-		Contents: node.Contents,
-		Editable: true,
-		Includes: includes,
-	}
-
-	if isRoot {
-		// The root is loaded directly (not via an include), so it has no source; normalize its
-		// path to the repo-relative bitrise.yml regardless of how the CLI addressed it.
-		wire.Path = rootPath
-		wire.Source = nil
-	} else {
-		path, source, editable := parseNodeKey(node.Path)
-		wire.Path = path
-		wire.Source = &source
-		wire.Editable = editable
-	}
-	return wire
-}
-
-// GetBitriseYMLTreeHandler resolves the modular include tree from disk (via the bitrise CLI's
-// configmerge) and returns it in the FE wire shape, plus the merged config. A non-modular config
+// GetBitriseYMLTreeHandler resolves the modular include tree from disk and returns it in the FE wire shape, plus the merged config. A non-modular config
 // comes back as a single root node, so the FE consumes one shape either way.
 func GetBitriseYMLTreeHandler(w http.ResponseWriter, r *http.Request) {
-	rootPath := filepath.Base(config.BitriseYMLPath)
+	project := projectFor(r)
+	rootPath := filepath.Base(project.BitriseYMLPath)
 
-	isModular, err := configmerge.IsModularConfig(config.BitriseYMLPath)
+	isModular, err := configmerge.IsModularConfig(project.BitriseYMLPath)
 	if err != nil {
-		log.Errorf("Failed to detect modular config (%s), error: %s", config.BitriseYMLPath, err)
+		log.Errorf("Failed to detect modular config (%s), error: %s", project.BitriseYMLPath, err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
 		return
 	}
 
 	if !isModular {
-		contStr, err := fileutil.ReadStringFromFile(config.BitriseYMLPath)
+		contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 		if err != nil {
-			log.Errorf("Failed to read bitrise.yml (%s), error: %s", config.BitriseYMLPath, err)
+			log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
 			RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
 			return
 		}
+		root := wireTreeNode{NodeID: nodeID(rootPath), Path: rootPath, Contents: contStr, Editable: true, Includes: []wireTreeNode{}}
 		RespondWithJSON(w, http.StatusOK, getConfigTreeResponse{
-			Root:      wireTreeNode{NodeID: nodeID(rootPath), Path: rootPath, Contents: contStr, Editable: true, Includes: []wireTreeNode{}},
-			MergedYML: contStr,
+			Root:       root,
+			MergedYML:  contStr,
+			Provenance: buildProvenanceIndex(root),
 		})
 		return
 	}
 
-	reader, err := configmerge.NewConfigReader(configMergeLogger())
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
 	if err != nil {
-		log.Errorf("Failed to init config reader, error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Failed to resolve config tree, error: %s", err)
+		log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
 		return
 	}
-	merger := configmerge.NewMerger(reader, configMergeLogger())
 
-	mergedYML, tree, err := merger.MergeConfig(config.BitriseYMLPath)
+	root, mergedYML, err := resolveConfigTree(project.BitriseYMLPath, contStr)
 	if err != nil {
-		log.Errorf("Failed to merge modular config (%s), error: %s", config.BitriseYMLPath, err)
+		log.Errorf("Failed to merge modular config (%s), error: %s", project.BitriseYMLPath, err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to resolve config tree, error: %s", err)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, getConfigTreeResponse{
-		Root:      toWireTreeNode(*tree, rootPath, true),
-		MergedYML: mergedYML,
-	})
+	RespondWithJSON(w, http.StatusOK, getConfigTreeResponse{Root: root, MergedYML: mergedYML, Provenance: buildProvenanceIndex(root)})
 }
 
-func toConfigFileTree(node wireTreeNode) models.ConfigFileTreeModel {
-	includes := make([]models.ConfigFileTreeModel, 0, len(node.Includes))
-	for _, child := range node.Includes {
-		includes = append(includes, toConfigFileTree(child))
-	}
-	return models.ConfigFileTreeModel{Path: node.Path, Contents: node.Contents, Includes: includes}
-}
-
-func collectEditableModified(node wireTreeNode, out *[]wireTreeNode) {
-	if node.Editable && node.Modified {
-		*out = append(*out, node)
-	}
-	for _, child := range node.Includes {
-		collectEditableModified(child, out)
+// resolveConfigTree resolves the include tree of a project's config, with its contents, and merges
+// it. Cross-repo includes are resolved to a commit on every call and their modules are cached by
+// commit, so branch includes are never stale and unchanged modules aren't re-read.
+func resolveConfigTree(bitriseYMLPath, contStr string) (wireTreeNode, string, error) {
+	resolver := mirrorTreeResolver{repoRoot: filepath.Dir(bitriseYMLPath), mirrorDir: config.IncludeMirrorDir}
+	root := resolver.resolve(filepath.Base(bitriseYMLPath), contStr)
+	mergedYML, err := mergeWireTree(root)
+	if err != nil {
+		return wireTreeNode{}, "", err
 	}
+	return root, mergedYML, nil
 }
 
-// PostBitriseYMLTreeHandler validates the merged tree, then writes each changed, editable module
-// file back to disk. Read-only (cross-ref) files are never written; unmodified files are skipped.
+// PostBitriseYMLTreeHandler validates the merged tree, then syncs the editable module files on disk
+// with it: new modules are created, changed ones written, and modules dropped from the tree are
+// deleted (unless `keep_removed_files` is set). Read-only (cross-ref) files are never written;
+// unmodified files are skipped. The response lists every created, updated and deleted path.
 func PostBitriseYMLTreeHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
 	if r.Body == nil {
 		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
 		return
@@ -204,7 +176,8 @@ func PostBitriseYMLTreeHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	var reqObj struct {
-		Root wireTreeNode `json:"root"`
+		Root             wireTreeNode `json:"root"`
+		KeepRemovedFiles bool         `json:"keep_removed_files"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
 		log.Errorf("Failed to read JSON input, error: %s", err)
@@ -213,8 +186,7 @@ func PostBitriseYMLTreeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validation is merged-only (a single module isn't a complete config), mirroring cloud.
-	tree := toConfigFileTree(reqObj.Root)
-	mergedYML, err := tree.Merge()
+	mergedYML, err := mergeWireTree(reqObj.Root)
 	if err != nil {
 		log.Errorf("Failed to merge config tree, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to merge config tree, error: %s", err)
@@ -227,31 +199,71 @@ func PostBitriseYMLTreeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	repoRoot := filepath.Dir(config.BitriseYMLPath)
-	var toWrite []wireTreeNode
-	collectEditableModified(reqObj.Root, &toWrite)
-	for _, node := range toWrite {
-		target := node.Path
-		if !filepath.IsAbs(target) {
-			target = filepath.Join(repoRoot, node.Path)
-		}
-		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
-			log.Errorf("Failed to create module dir (%s), error: %s", filepath.Dir(target), err)
+	leaks, err := scanWireTreeForSecretLeaks(reqObj.Root, config.StrictSecretScan)
+	if err != nil {
+		log.Errorf("Failed to scan for secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to scan for secrets, error: %s", err)
+		return
+	}
+	if config.StrictSecretScan && len(leaks) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, secretLeaksResponse{
+			Response:    NewErrorResponseWithConfig(mergedYML, "%s", secretLeaksError(leaks)),
+			SecretLeaks: leaks,
+		})
+		return
+	}
+	warnings = withStackWarnings(withSecretLeakWarnings(warnings, leaks), mergedYML)
+
+	repoRoot := filepath.Dir(project.BitriseYMLPath)
+	rootContents, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
+	if err != nil {
+		log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
+		return
+	}
+	disk := mirrorTreeResolver{repoRoot: repoRoot}.resolve(filepath.Base(project.BitriseYMLPath), rootContents)
+
+	plan, err := planTreeWrite(reqObj.Root, repoRoot, disk)
+	if err != nil {
+		log.Errorf("Invalid config tree, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid config tree, error: %s", err)
+		return
+	}
+
+	resp := postConfigTreeResponse{Warnings: warnings, SecretLeaks: leaks, Created: []string{}, Updated: []string{}, Deleted: []string{}}
+	for _, node := range plan.create {
+		if err := writeModuleFile(repoRoot, node); err != nil {
+			log.Errorf("Failed to create module file (%s), error: %s", node.Path, err)
 			RespondWithJSONBadRequestErrorMessage(w, "Failed to write module file (%s), error: %s", node.Path, err)
 			return
 		}
-		if err := fileutil.WriteStringToFile(target, node.Contents); err != nil {
-			log.Errorf("Failed to write module file (%s), error: %s", target, err)
+		resp.Created = append(resp.Created, node.Path)
+	}
+	for _, node := range plan.update {
+		if err := writeModuleFile(repoRoot, node); err != nil {
+			log.Errorf("Failed to write module file (%s), error: %s", node.Path, err)
 			RespondWithJSONBadRequestErrorMessage(w, "Failed to write module file (%s), error: %s", node.Path, err)
 			return
 		}
+		resp.Updated = append(resp.Updated, node.Path)
+	}
+	if !reqObj.KeepRemovedFiles {
+		for _, pth := range plan.delete {
+			if err := deleteModuleFile(repoRoot, pth); err != nil {
+				log.Errorf("Failed to delete module file (%s), error: %s", pth, err)
+				RespondWithJSONBadRequestErrorMessage(w, "Failed to delete module file (%s), error: %s", pth, err)
+				return
+			}
+			resp.Deleted = append(resp.Deleted, pth)
+		}
 	}
 
-	RespondWithJSON(w, http.StatusOK, utility.ValidationResponse{Warnings: warnings})
+	RespondWithJSON(w, http.StatusOK, resp)
 }
 
 // PostBitriseYMLTreeMergeHandler flattens the posted (possibly-edited) tree so the merged-config
-// view reflects in-memory edits without a reload.
+// view reflects in-memory edits without a reload. Only subtrees that changed since an earlier
+// merge are re-merged.
 func PostBitriseYMLTreeMergeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
@@ -271,8 +283,7 @@ func PostBitriseYMLTreeMergeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tree := toConfigFileTree(reqObj.Root)
-	mergedYML, err := tree.Merge()
+	mergedYML, err := mergeWireTree(reqObj.Root)
 	if err != nil {
 		log.Errorf("Failed to merge config tree, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to merge config tree, error: %s", err)
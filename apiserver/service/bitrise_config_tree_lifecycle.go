@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// treeWritePlan is what saving a posted tree does to the module files on disk.
+type treeWritePlan struct {
+	create []wireTreeNode
+	update []wireTreeNode
+	delete []string
+}
+
+// postConfigTreeResponse lists every module path the save touched, relative to the repo root.
+type postConfigTreeResponse struct {
+	Warnings    *utility.WarningItems `json:"warnings"`
+	SecretLeaks []SecretLeak          `json:"secret_leaks,omitempty"`
+	Created     []string              `json:"created"`
+	Updated     []string              `json:"updated"`
+	Deleted     []string              `json:"deleted"`
+}
+
+func cleanModulePath(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// validateModulePath rejects module paths the server must never write to: empty, absolute,
+// pointing outside the repository, or into its .git directory.
+func validateModulePath(p string) error {
+	if strings.TrimSpace(p) == "" {
+		return fmt.Errorf("empty path")
+	}
+	if filepath.IsAbs(p) || path.IsAbs(filepath.ToSlash(p)) {
+		return fmt.Errorf("absolute path")
+	}
+	if cleaned := cleanModulePath(p); cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("path points outside the repository")
+	}
+	for _, segment := range strings.Split(cleanModulePath(p), "/") {
+		if strings.EqualFold(segment, ".git") {
+			return fmt.Errorf("path points into .git")
+		}
+	}
+	return nil
+}
+
+// isIncludedBy reports whether the parent's `include:` list has a local (working repo, current
+// branch) entry for the child path.
+func isIncludedBy(parent wireTreeNode, childPath string) (bool, error) {
+	entries, err := parseIncludes(parent.Contents)
+	if err != nil {
+		return false, fmt.Errorf("invalid YAML in %s: %w", parent.Path, err)
+	}
+	for _, entry := range entries {
+		if entry.Repository == "" && !entry.hasRef() && cleanModulePath(entry.Path) == cleanModulePath(childPath) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// localModulePaths lists the editable modules of the include tree resolved from disk, excluding the
+// root config itself: all of them, and the ones that exist (resolved without an error).
+func localModulePaths(disk wireTreeNode) (included, existing map[string]bool) {
+	included, existing = map[string]bool{}, map[string]bool{}
+	var walk func(node wireTreeNode)
+	walk = func(node wireTreeNode) {
+		for _, child := range node.Includes {
+			if child.Editable {
+				included[cleanModulePath(child.Path)] = true
+				if child.Error == "" {
+					existing[cleanModulePath(child.Path)] = true
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(disk)
+	return included, existing
+}
+
+// planTreeWrite compares the posted tree with the include tree resolved from disk. Editable nodes
+// without a file are created, modified ones are updated, and editable modules on disk that are no
+// longer part of the tree are deleted. Unresolved nodes (see wireTreeNode.Error) are neither written
+// nor deleted.
+//
+// Nothing outside the include tree is ever written: an editable node must stay inside the
+// repository, have an editable parent including it, and be either included on disk or newly
+// included by a parent that's saved along with it.
+func planTreeWrite(root wireTreeNode, repoRoot string, disk wireTreeNode) (treeWritePlan, error) {
+	var plan treeWritePlan
+	if cleanModulePath(root.Path) != cleanModulePath(disk.Path) {
+		return treeWritePlan{}, fmt.Errorf("root node path (%s) is not %s", root.Path, disk.Path)
+	}
+	included, existing := localModulePaths(disk)
+	posted := map[string]bool{}
+
+	var walk func(parent wireTreeNode, parentSaved bool) error
+	walk = func(parent wireTreeNode, parentSaved bool) error {
+		for _, child := range parent.Includes {
+			saved := false
+			if child.Editable {
+				if !parent.Editable {
+					return fmt.Errorf("module %s is included by the read-only %s", child.Path, parent.Path)
+				}
+				if _, err := sandboxedModulePath(repoRoot, child.Path); err != nil {
+					return fmt.Errorf("invalid module path (%s): %w", child.Path, err)
+				}
+				isIncluded, err := isIncludedBy(parent, child.Path)
+				if err != nil {
+					return err
+				}
+				if !isIncluded {
+					return fmt.Errorf("module %s is not included by %s", child.Path, parent.Path)
+				}
+				if !included[cleanModulePath(child.Path)] && !parentSaved {
+					return fmt.Errorf("module %s is not included on disk, and %s isn't saved", child.Path, parent.Path)
+				}
+
+				posted[cleanModulePath(child.Path)] = true
+
+				// An unresolved module is left out of the validated merge, so it's never written;
+				// it's kept on disk as is.
+				if child.Error == "" {
+					exists, err := pathutil.IsPathExists(filepath.Join(repoRoot, child.Path))
+					if err != nil {
+						return fmt.Errorf("failed to check module file (%s): %w", child.Path, err)
+					}
+					if !exists {
+						plan.create = append(plan.create, child)
+						saved = true
+					} else if child.Modified {
+						plan.update = append(plan.update, child)
+						saved = true
+					}
+				}
+			}
+			if err := walk(child, saved); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if root.Modified {
+		plan.update = append(plan.update, root)
+	}
+	if err := walk(root, root.Modified); err != nil {
+		return treeWritePlan{}, err
+	}
+
+	for _, p := range sortedKeys(existing) {
+		if !posted[p] {
+			plan.delete = append(plan.delete, p)
+		}
+	}
+	return plan, nil
+}
+
+// sandboxedModulePath resolves a repo-relative module path to the file to write, following
+// symlinks, and fails unless the result stays inside the repository root.
+func sandboxedModulePath(repoRoot, p string) (string, error) {
+	if err := validateModulePath(p); err != nil {
+		return "", err
+	}
+
+	absRoot, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", err
+	}
+
+	// Resolve the deepest part of the path that exists; whatever is missing below it will be
+	// created as plain directories/files, so it can't redirect the write.
+	existing := filepath.Join(absRoot, filepath.FromSlash(cleanModulePath(p)))
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = filepath.Dir(existing)
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(append([]string{realExisting}, missing...)...)
+
+	rel, err := filepath.Rel(realRoot, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path resolves outside the repository")
+	}
+	return target, nil
+}
+
+func writeModuleFile(repoRoot string, node wireTreeNode) error {
+	target, err := sandboxedModulePath(repoRoot, node.Path)
+	if err != nil {
+		return err
+	}
+	if err := pathutil.EnsureDirExist(filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := fileutil.WriteStringToFile(target, node.Contents); err != nil {
+		return err
+	}
+	log.Printf("[audit] Wrote module file: %s (%d bytes)", target, len(node.Contents))
+	return nil
+}
+
+func deleteModuleFile(repoRoot, p string) error {
+	target, err := sandboxedModulePath(repoRoot, p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	log.Printf("[audit] Deleted module file: %s", target)
+	return nil
+}
@@ -173,3 +173,171 @@ func TestPostBitriseYMLTreeMergeHandler(t *testing.T) {
 	require.Contains(t, resp["merged_yml"], "build")
 	require.Contains(t, resp["merged_yml"], "release")
 }
+
+func TestPostBitriseYMLTreeHandler_lifecycle(t *testing.T) {
+	dir := t.TempDir()
+	withWorkdir(t, dir)
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, "bitrise.yml"), "format_version: \"13\"\ninclude:\n  - path: modules/old.yml\n"))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "modules"), 0o755))
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, "modules", "old.yml"), "workflows:\n  old: {}\n"))
+	config.BitriseYMLPath = "bitrise.yml"
+
+	post := func(payload map[string]any) *httptest.ResponseRecorder {
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/bitrise-yml/tree", bytes.NewReader(body))
+		http.HandlerFunc(PostBitriseYMLTreeHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Log("a node that isn't reachable from an include entry is rejected")
+	rr := post(map[string]any{
+		"root": wireTreeNode{
+			Path:     "bitrise.yml",
+			Contents: "format_version: \"13\"\ninclude:\n  - path: modules/old.yml\n",
+			Editable: true,
+			Includes: []wireTreeNode{
+				{Path: "modules/old.yml", Contents: "workflows:\n  old: {}\n", Editable: true, Includes: []wireTreeNode{}},
+				{Path: "modules/stray.yml", Contents: "workflows:\n  stray: {}\n", Editable: true, Includes: []wireTreeNode{}},
+			},
+		},
+	})
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	require.Contains(t, rr.Body.String(), "modules/stray.yml is not included by bitrise.yml")
+	require.NoFileExists(t, filepath.Join(dir, "modules", "stray.yml"))
+
+	t.Log("an unresolved module isn't validated, so it's not written")
+	require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, "bitrise.yml"), "format_version: \"13\"\ninclude:\n  - path: modules/old.yml\n  - path: modules/missing.yml\n"))
+	rr = post(map[string]any{
+		"root": wireTreeNode{
+			Path:     "bitrise.yml",
+			Contents: "format_version: \"13\"\ninclude:\n  - path: modules/old.yml\n  - path: modules/missing.yml\n",
+			Editable: true,
+			Includes: []wireTreeNode{
+				{Path: "modules/old.yml", Contents: "workflows:\n  old: {}\n", Editable: true, Includes: []wireTreeNode{}},
+				{Path: "modules/missing.yml", Contents: "format_version: [", Editable: true, Modified: true, Error: "failed to read modules/missing.yml", Includes: []wireTreeNode{}},
+			},
+		},
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoFileExists(t, filepath.Join(dir, "modules", "missing.yml"))
+
+	t.Log("replacing a module creates the new file and deletes the dropped one")
+	rr = post(map[string]any{
+		"root": wireTreeNode{
+			Path:     "bitrise.yml",
+			Contents: "format_version: \"13\"\ninclude:\n  - path: modules/new.yml\n",
+			Editable: true,
+			Modified: true,
+			Includes: []wireTreeNode{
+				{Path: "modules/new.yml", Contents: "workflows:\n  new: {}\n", Editable: true, Modified: true, Includes: []wireTreeNode{}},
+			},
+		},
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp postConfigTreeResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, []string{"modules/new.yml"}, resp.Created)
+	require.Equal(t, []string{"bitrise.yml"}, resp.Updated)
+	require.Equal(t, []string{"modules/old.yml"}, resp.Deleted)
+	require.FileExists(t, filepath.Join(dir, "modules", "new.yml"))
+	require.NoFileExists(t, filepath.Join(dir, "modules", "old.yml"))
+}
+
+func TestPlanTreeWrite(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "modules"), 0o755))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, "modules", name+".yml"), "workflows: {}\n"))
+	}
+	disk := mirrorTreeResolver{repoRoot: dir}.resolve("bitrise.yml", "include:\n  - path: modules/a.yml\n  - path: modules/d.yml\n  - path: modules/b.yml\n  - path: modules/c.yml\n")
+
+	root := wireTreeNode{
+		Path:     "bitrise.yml",
+		Contents: "include:\n  - path: ./modules/a.yml\n  - path: ../escape.yml\n",
+		Editable: true,
+		Includes: []wireTreeNode{
+			{Path: "modules/a.yml", Editable: true, Includes: []wireTreeNode{}},
+			{Path: "../escape.yml", Editable: true, Includes: []wireTreeNode{}},
+		},
+	}
+	_, err := planTreeWrite(root, dir, disk)
+	require.ErrorContains(t, err, "invalid module path (../escape.yml)")
+
+	bad := root
+	bad.Path = "../bitrise.yml"
+	_, err = planTreeWrite(bad, dir, disk)
+	require.ErrorContains(t, err, "root node path")
+
+	root.Includes = root.Includes[:1]
+	plan, err := planTreeWrite(root, dir, disk)
+	require.NoError(t, err)
+	require.Empty(t, plan.create)
+	require.Empty(t, plan.update)
+	require.Equal(t, []string{"modules/b.yml", "modules/c.yml", "modules/d.yml"}, plan.delete)
+
+	t.Log("a new include is only written along with the module adding it")
+	crafted := wireTreeNode{
+		Path:     "bitrise.yml",
+		Contents: "include:\n  - path: modules/a.yml\n  - path: .github/workflows/ci.yml\n",
+		Editable: true,
+		Includes: []wireTreeNode{
+			{Path: "modules/a.yml", Editable: true, Includes: []wireTreeNode{}},
+			{Path: ".github/workflows/ci.yml", Contents: "on: push\n", Editable: true, Modified: true, Includes: []wireTreeNode{}},
+		},
+	}
+	_, err = planTreeWrite(crafted, dir, disk)
+	require.EqualError(t, err, "module .github/workflows/ci.yml is not included on disk, and bitrise.yml isn't saved")
+
+	crafted.Modified = true
+	plan, err = planTreeWrite(crafted, dir, disk)
+	require.NoError(t, err)
+	require.Len(t, plan.create, 1)
+	require.Equal(t, ".github/workflows/ci.yml", plan.create[0].Path)
+
+	t.Log("nothing is written into .git")
+	crafted.Contents = "include:\n  - path: .git/hooks/pre-commit\n"
+	crafted.Includes = []wireTreeNode{{Path: ".git/hooks/pre-commit", Contents: "#!/bin/sh\n", Editable: true, Modified: true, Includes: []wireTreeNode{}}}
+	_, err = planTreeWrite(crafted, dir, disk)
+	require.ErrorContains(t, err, "path points into .git")
+
+	t.Log("an editable module can't hang off a read-only one")
+	crafted.Contents = "include:\n  - path: shared.yml\n    repository: shared\n"
+	crafted.Includes = []wireTreeNode{{
+		Path:     "shared.yml",
+		Contents: "include:\n  - path: modules/a.yml\n",
+		Includes: []wireTreeNode{{Path: "modules/a.yml", Contents: "workflows: {}\n", Editable: true, Modified: true, Includes: []wireTreeNode{}}},
+	}}
+	_, err = planTreeWrite(crafted, dir, disk)
+	require.EqualError(t, err, "module modules/a.yml is included by the read-only shared.yml")
+}
+
+func TestSandboxedModulePath(t *testing.T) {
+	repoRoot := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repoRoot, "modules"), 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(repoRoot, "linked")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "target.yml"), filepath.Join(repoRoot, "modules", "link.yml")))
+
+	realRoot, err := filepath.EvalSymlinks(repoRoot)
+	require.NoError(t, err)
+
+	target, err := sandboxedModulePath(repoRoot, "modules/wf.yml")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(realRoot, "modules", "wf.yml"), target)
+
+	target, err = sandboxedModulePath(repoRoot, "new/dir/wf.yml")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(realRoot, "new", "dir", "wf.yml"), target)
+
+	for _, p := range []string{"", "/etc/passwd", "../x.yml", "modules/../../x.yml", "linked/x.yml", "modules/link.yml"} {
+		_, err := sandboxedModulePath(repoRoot, p)
+		require.Error(t, err, p)
+	}
+	for _, p := range []string{".git/config", "modules/.git/hooks/post-checkout", ".GIT/config"} {
+		_, err := sandboxedModulePath(repoRoot, p)
+		require.ErrorContains(t, err, "path points into .git", p)
+	}
+}
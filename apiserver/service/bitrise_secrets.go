@@ -4,16 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
 	envmanModels "github.com/bitrise-io/envman/models"
-	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/gorilla/mux"
 )
 
 func ymlToJSONKeyTypeConversion(i interface{}) interface{} {
@@ -43,14 +44,12 @@ func ymlToJSONKeyTypeConversion(i interface{}) interface{} {
 	return i
 }
 
-func createEmptySecretsFileIfNotExist() error {
-	secretsYMLPth := config.SecretsYMLPath
-
+func createEmptySecretsFileIfNotExist(secretsYMLPth string) error {
 	if isExist, err := pathutil.IsPathExists(secretsYMLPth); err != nil {
 		log.Errorf("Failed to check .bitrise.secrets.yml file, error: %s", err)
 		return err
 	} else if !isExist {
-		if err := os.WriteFile(secretsYMLPth, []byte("envs: []"), 0644); err != nil {
+		if err := secrets.WriteFile(secretsYMLPth, []byte("envs: []"), config.SecretsPassphrase); err != nil {
 			log.Errorf("Failed to create .bitrise.secrets.yml file, error: %s", err)
 			return err
 		}
@@ -60,51 +59,68 @@ func createEmptySecretsFileIfNotExist() error {
 	return nil
 }
 
-// GetSecretsAsJSONHandler ...
-func GetSecretsAsJSONHandler(w http.ResponseWriter, r *http.Request) {
-	secretsYMLPth := config.SecretsYMLPath
+// MaskedSecretValue stands in for secret values sent to the browser. Saving it back keeps the
+// saved value, so a value only crosses the wire when it's revealed or changed.
+const MaskedSecretValue = "__BITRISE_SECRET_UNCHANGED__"
 
+// readSecrets reads and validates a secrets file; a missing file has no secrets.
+func readSecrets(secretsYMLPth string) (envmanModels.EnvsSerializeModel, error) {
 	if isExist, err := pathutil.IsPathExists(secretsYMLPth); err != nil {
-		log.Errorf("Failed to check .bitrise.secrets.yml file, error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Failed to check .bitrise.secrets.yml file, error: %s", err)
-		return
+		return envmanModels.EnvsSerializeModel{}, fmt.Errorf("failed to check .bitrise.secrets.yml file: %w", err)
 	} else if !isExist {
-		log.Errorf(".bitrise.secrets.yml does not exist")
-		RespondWithJSON(w, 200, envmanModels.EnvsSerializeModel{Envs: []envmanModels.EnvironmentItemModel{}})
-		return
+		return envmanModels.EnvsSerializeModel{Envs: []envmanModels.EnvironmentItemModel{}}, nil
 	}
 
-	contBytes, err := fileutil.ReadBytesFromFile(secretsYMLPth)
+	contBytes, err := secrets.ReadFile(secretsYMLPth, config.SecretsPassphrase)
 	if err != nil {
-		log.Errorf("Failed to read content of .bitrise.secrets.yml file, error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Failed to read content of .bitrise.secrets.yml file, error: %s", err)
-		return
+		return envmanModels.EnvsSerializeModel{}, fmt.Errorf("failed to read content of .bitrise.secrets.yml file: %w", err)
 	}
 
 	if _, err := utility.ValidateBitriseConfigAndSecret(config.MinimalValidBitriseYML, string(contBytes)); err != nil {
-		log.Errorf("Validation error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Invalid secrets: %s", err)
-		return
+		return envmanModels.EnvsSerializeModel{}, fmt.Errorf("invalid secrets: %w", err)
 	}
 
 	var envsSerializeModel envmanModels.EnvsSerializeModel
 	if err := yaml.Unmarshal(contBytes, &envsSerializeModel); err != nil {
-		log.Errorf("Failed to parse the content of .bitrise.secrets.yml file (invalid YML), error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Failed to parse the content of .bitrise.secrets.yml file (invalid YML), error: %s", err)
-		return
+		return envmanModels.EnvsSerializeModel{}, fmt.Errorf("failed to parse the content of .bitrise.secrets.yml file (invalid YML): %w", err)
 	}
 
 	if err := envsSerializeModel.Normalize(); err != nil {
-		log.Errorf("Failed to normalize the content of .bitrise.secrets.yml file (invalid YML), error: %s", err)
-		RespondWithJSONBadRequestErrorMessage(w, "Failed to normalize the content of .bitrise.secrets.yml file (invalid YML), error: %s", err)
+		return envmanModels.EnvsSerializeModel{}, fmt.Errorf("failed to normalize the content of .bitrise.secrets.yml file (invalid YML): %w", err)
+	}
+	return envsSerializeModel, nil
+}
+
+// GetSecretsAsJSONHandler returns the secrets' keys and options, with masked values.
+func GetSecretsAsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	secretsYMLPth := projectFor(r).SecretsYMLPath
+
+	envsSerializeModel, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		log.Errorf("Failed to read secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read secrets, error: %s", err)
 		return
 	}
 
-	for i, env := range envsSerializeModel.Envs {
+	// A failing provider doesn't block editing the saved secrets.
+	resp := secretsResponseModel{Envs: envsSerializeModel.Envs}
+	provided, err := providedSecretKeys(secretsYMLPth, envsSerializeModel)
+	if err != nil {
+		log.Warnf("Failed to get provided secrets, error: %s", err)
+		resp.Warnings = append(resp.Warnings, err.Error())
+	}
+
+	for i, env := range resp.Envs {
+		key, _, err := env.GetKeyValuePair()
+		if err != nil {
+			log.Errorf("Failed to get key of env: %v, error: %s", env, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to get key of env, error: %s", err)
+			return
+		}
 		opts, err := env.GetOptions()
 		if err != nil {
-			log.Errorf("Failed to get options of env: %v, error: %s", env, err)
-			RespondWithJSONBadRequestErrorMessage(w, "Failed to get options of env: %v, error: %s", env, err)
+			log.Errorf("Failed to get options of env: %s, error: %s", key, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to get options of env: %s, error: %s", key, err)
 			return
 		}
 		if len(opts.Meta) > 0 {
@@ -118,15 +134,149 @@ func GetSecretsAsJSONHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		env[envmanModels.OptionsKey] = opts
-		envsSerializeModel.Envs[i] = env
+		resp.Envs[i] = envmanModels.EnvironmentItemModel{
+			key:                     MaskedSecretValue,
+			envmanModels.OptionsKey: withSecretSource(opts, secretsFileSource),
+		}
+	}
+	for _, secret := range provided {
+		resp.Envs = append(resp.Envs, envmanModels.EnvironmentItemModel{
+			secret.Key:              MaskedSecretValue,
+			envmanModels.OptionsKey: withSecretSource(envmanModels.EnvironmentItemOptionsModel{}, secret.Source),
+		})
+	}
+
+	RespondWithJSON(w, 200, resp)
+}
+
+type secretsResponseModel struct {
+	Envs []envmanModels.EnvironmentItemModel `json:"envs"`
+	// Warnings are the errors of the secret providers.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type secretValueResponseModel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// isProtectedSecret reports whether the secret is marked protected (bitrise.io is_protected meta),
+// whose value is never revealed.
+func isProtectedSecret(opts envmanModels.EnvironmentItemOptionsModel) bool {
+	meta, ok := ymlToJSONKeyTypeConversion(opts.Meta).(map[string]interface{})
+	if !ok {
+		return false
+	}
+	bitriseMeta, ok := meta["bitrise.io"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	protected, ok := bitriseMeta["is_protected"].(bool)
+	return ok && protected
+}
+
+// GetSecretValueHandler reveals the value of one secret.
+func GetSecretValueHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	secretsYMLPth := projectFor(r).SecretsYMLPath
+
+	envsSerializeModel, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		log.Errorf("Failed to read secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read secrets, error: %s", err)
+		return
+	}
+
+	for _, env := range envsSerializeModel.Envs {
+		envKey, value, err := env.GetKeyValuePair()
+		if err != nil || envKey != key {
+			continue
+		}
+		opts, err := env.GetOptions()
+		if err != nil {
+			log.Errorf("Failed to get options of env: %s, error: %s", key, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to get options of env: %s, error: %s", key, err)
+			return
+		}
+		if isProtectedSecret(opts) {
+			RespondWithJSON(w, http.StatusForbidden, NewErrorResponse("Secret %s is protected, its value can't be revealed", key))
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, secretValueResponseModel{Key: key, Value: value})
+		return
+	}
+
+	provided, err := providedSecrets(secretsYMLPth, envsSerializeModel)
+	if err != nil {
+		log.Errorf("Failed to get provided secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to get provided secrets, error: %s", err)
+		return
+	}
+	for _, secret := range provided {
+		if secret.Key == key {
+			RespondWithJSON(w, http.StatusOK, secretValueResponseModel{Key: key, Value: secret.Value})
+			return
+		}
 	}
 
-	RespondWithJSON(w, 200, envsSerializeModel)
+	RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("Unknown secret: %s", key))
 }
 
-// PostSecretsYMLFromJSONHandler ...
+// unmaskSecrets replaces the masked values of envs with the saved values, and drops the masked
+// provided secrets, which stay with their provider. Provided secrets with a changed value are
+// kept, to be saved as overrides.
+func unmaskSecrets(secretsYMLPth string, envs []envmanModels.EnvironmentItemModel, saved envmanModels.EnvsSerializeModel) ([]envmanModels.EnvironmentItemModel, error) {
+	savedValues := map[string]string{}
+	for _, env := range saved.Envs {
+		key, value, err := env.GetKeyValuePair()
+		if err != nil {
+			return nil, err
+		}
+		savedValues[key] = value
+	}
+
+	var providedKeys map[string]bool
+	unmasked := make([]envmanModels.EnvironmentItemModel, 0, len(envs))
+	for _, env := range envs {
+		if err := withoutSecretSource(env); err != nil {
+			return nil, err
+		}
+
+		// Invalid items are left to the validation.
+		key, value, err := env.GetKeyValuePairWithType()
+		if err != nil || value != MaskedSecretValue {
+			unmasked = append(unmasked, env)
+			continue
+		}
+		if savedValue, ok := savedValues[key]; ok {
+			env[key] = savedValue
+			unmasked = append(unmasked, env)
+			continue
+		}
+
+		if providedKeys == nil {
+			provided, err := providedSecretKeys(secretsYMLPth, saved)
+			if err != nil {
+				return nil, err
+			}
+			providedKeys = map[string]bool{}
+			for _, secret := range provided {
+				providedKeys[secret.Key] = true
+			}
+		}
+		if !providedKeys[key] {
+			return nil, fmt.Errorf("%s has no saved value to keep", key)
+		}
+	}
+	return unmasked, nil
+}
+
+// PostSecretsYMLFromJSONHandler saves the secrets; masked values keep the saved (or provided) value
+// and omitted options the saved options. Invalid items are rejected with per-item errors.
 func PostSecretsYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
+	secretsYMLPth := projectFor(r).SecretsYMLPath
+
 	if r.Body == nil {
 		log.Errorf("Empty request body")
 		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
@@ -140,7 +290,7 @@ func PostSecretsYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// Check if the secrets file exists, if not create an empty one
-	if err := createEmptySecretsFileIfNotExist(); err != nil {
+	if err := createEmptySecretsFileIfNotExist(secretsYMLPth); err != nil {
 		log.Errorf("Failed to create empty .bitrise.secrets.yml file, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to create empty .bitrise.secrets.yml file, error: %s", err)
 		return
@@ -153,6 +303,35 @@ func PostSecretsYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	saved, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		log.Errorf("Failed to read secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read secrets, error: %s", err)
+		return
+	}
+	if errs := validateSecretItems(reqObj.Envs, saved); len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for _, itemErr := range errs {
+			messages = append(messages, itemErr.String())
+		}
+		log.Errorf("Invalid secrets: %s", strings.Join(messages, "; "))
+		RespondWithJSON(w, http.StatusBadRequest, secretsValidationResponse{
+			Response: NewErrorResponse("Invalid secrets: %s", strings.Join(messages, "; ")),
+			Errors:   errs,
+		})
+		return
+	}
+	if reqObj.Envs, err = unmaskSecrets(secretsYMLPth, reqObj.Envs, saved); err != nil {
+		log.Errorf("Invalid secrets: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid secrets: %s", err)
+		return
+	}
+	if err := keepSavedSecretOptions(reqObj.Envs, saved); err != nil {
+		log.Errorf("Invalid secrets: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid secrets: %s", err)
+		return
+	}
+
 	contAsYAML, err := yaml.Marshal(reqObj)
 	if err != nil {
 		log.Errorf("Failed to serialize env model as YAML, error: %s", err)
@@ -167,7 +346,7 @@ func PostSecretsYMLFromJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := fileutil.WriteBytesToFile(config.SecretsYMLPath, contAsYAML); err != nil {
+	if err := secrets.WriteFile(secretsYMLPth, contAsYAML, config.SecretsPassphrase); err != nil {
 		log.Errorf("Failed to write content into file, error: %s", err)
 		RespondWithJSONBadRequestErrorMessage(w, "Failed to write content into file, error: %s", err)
 		return
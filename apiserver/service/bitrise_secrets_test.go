@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -9,8 +10,10 @@ import (
 	"testing"
 
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
 	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,7 +39,7 @@ func TestGetSecretsAsJSONHandler(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
-	require.Equal(t, "{\"envs\":[{\"KEY\":\"Value\",\"opts\":{}}]}\n", rr.Body.String())
+	require.Equal(t, "{\"envs\":[{\"KEY\":\"__BITRISE_SECRET_UNCHANGED__\",\"opts\":{\"meta\":{\"workflow_editor\":{\"source\":\"secrets-file\"}}}}]}\n", rr.Body.String())
 }
 
 func TestPostSecretsYMLFromJSONHandler(t *testing.T) {
@@ -63,3 +66,176 @@ func TestPostSecretsYMLFromJSONHandler(t *testing.T) {
 	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
 	require.Equal(t, "{\"warnings\":null}\n", rr.Body.String())
 }
+
+func TestSecretsHandlers_encrypted(t *testing.T) {
+	bitriseSecretsPth := filepath.Join(t.TempDir(), ".bitrise.secrets.yml")
+	config.SecretsYMLPath = bitriseSecretsPth
+	config.SecretsPassphrase = "passphrase"
+	defer func() {
+		config.SecretsPassphrase = ""
+	}()
+
+	req, err := http.NewRequest("POST", "/api/secrets", bytes.NewBufferString(`{"envs":[{"KEY":"s3cr3t","opts":{}}]}`))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(PostSecretsYMLFromJSONHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	cont, err := os.ReadFile(bitriseSecretsPth)
+	require.NoError(t, err)
+	require.True(t, secrets.IsEncrypted(cont))
+	require.NotContains(t, string(cont), "s3cr3t")
+	info, err := os.Stat(bitriseSecretsPth)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	req, err = http.NewRequest("GET", "/api/secrets/KEY", nil)
+	require.NoError(t, err)
+	req = mux.SetURLVars(req, map[string]string{"key": "KEY"})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(GetSecretValueHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "{\"key\":\"KEY\",\"value\":\"s3cr3t\"}\n", rr.Body.String())
+
+	t.Log("without the passphrase")
+	{
+		config.SecretsPassphrase = ""
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(GetSecretValueHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "BITRISE_SECRETS_PASSPHRASE")
+	}
+}
+
+func TestSecretsHandlers_masked(t *testing.T) {
+	bitriseSecretsPth := filepath.Join(t.TempDir(), ".bitrise.secrets.yml")
+	require.NoError(t, fileutil.WriteStringToFile(bitriseSecretsPth, `envs:
+- API_TOKEN: s3cr3t
+- SIGNING_KEY: k3y
+  opts:
+    meta:
+      bitrise.io:
+        is_protected: true
+`))
+	config.SecretsYMLPath = bitriseSecretsPth
+
+	reveal := func(key string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/api/secrets/"+key, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetSecretValueHandler).ServeHTTP(rr, mux.SetURLVars(req, map[string]string{"key": key}))
+		return rr
+	}
+	save := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/secrets", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostSecretsYMLFromJSONHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Log("values are masked")
+	{
+		req, err := http.NewRequest("GET", "/api/secrets", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetSecretsAsJSONHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.NotContains(t, rr.Body.String(), "s3cr3t")
+		require.NotContains(t, rr.Body.String(), "k3y")
+	}
+
+	t.Log("reveal")
+	{
+		rr := reveal("API_TOKEN")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Equal(t, "{\"key\":\"API_TOKEN\",\"value\":\"s3cr3t\"}\n", rr.Body.String())
+
+		require.Equal(t, http.StatusForbidden, reveal("SIGNING_KEY").Code)
+		require.Equal(t, http.StatusNotFound, reveal("UNKNOWN").Code)
+	}
+
+	t.Log("masked values keep the saved value")
+	{
+		rr := save(`{"envs":[{"API_TOKEN":"__BITRISE_SECRET_UNCHANGED__","opts":{}},{"SIGNING_KEY":"n3w","opts":{}},{"NEW":"v","opts":{}}]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		cont, err := fileutil.ReadStringFromFile(bitriseSecretsPth)
+		require.NoError(t, err)
+		require.Contains(t, cont, "API_TOKEN: s3cr3t")
+		require.Contains(t, cont, "SIGNING_KEY: n3w")
+		require.Contains(t, cont, "NEW: v")
+	}
+
+	t.Log("a masked value needs a saved one")
+	{
+		rr := save(`{"envs":[{"OTHER":"__BITRISE_SECRET_UNCHANGED__","opts":{}}]}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "OTHER has no saved value to keep")
+	}
+}
+
+func TestSecretsHandlers_validation(t *testing.T) {
+	bitriseSecretsPth := filepath.Join(t.TempDir(), ".bitrise.secrets.yml")
+	require.NoError(t, os.WriteFile(bitriseSecretsPth, []byte(`envs:
+- PROTECTED: s3cr3t
+  opts:
+    is_sensitive: true
+    skip_if_empty: true
+    meta:
+      bitrise.io:
+        is_protected: true
+      other_tool:
+        tag: ci
+`), 0600))
+	config.SecretsYMLPath = bitriseSecretsPth
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/secrets", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostSecretsYMLFromJSONHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Log("per-item errors")
+	{
+		rr := post(`{"envs":[
+			{"PROTECTED":"__BITRISE_SECRET_UNCHANGED__","opts":{"meta":{"bitrise.io":{"is_protected":false}}}},
+			{"1_INVALID":"value","opts":{"is_expand":"yes","scope":"app"}},
+			{"DUPLICATE":"a"},
+			{"DUPLICATE":"b","opts":{"meta":{"bitrise.io":{"expose":true,"is_public":true}}}},
+			{"NUMBER":42}
+		]}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		var resp secretsValidationResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, []SecretItemError{
+			{Index: 0, Key: "PROTECTED", Field: "opts.meta.bitrise.io.is_protected", Message: "a protected secret can't be unprotected"},
+			{Index: 1, Key: "1_INVALID", Field: "key", Message: "must start with a letter or underscore and contain only letters, digits and underscores"},
+			{Index: 1, Key: "1_INVALID", Field: "opts.is_expand", Message: "must be a boolean, got string"},
+			{Index: 1, Key: "1_INVALID", Field: "opts.scope", Message: "unknown option"},
+			{Index: 3, Key: "DUPLICATE", Field: "key", Message: "duplicate key, already defined by secret #3"},
+			{Index: 3, Key: "DUPLICATE", Field: "opts.meta.bitrise.io.is_public", Message: "unknown option, expected one of: is_expose, expose, is_protected"},
+			{Index: 4, Key: "NUMBER", Field: "value", Message: "must be a string, got number"},
+		}, resp.Errors)
+	}
+
+	t.Log("omitted options are kept")
+	{
+		rr := post(`{"envs":[{"PROTECTED":"__BITRISE_SECRET_UNCHANGED__","opts":{"is_expand":false,"meta":{"bitrise.io":{"is_protected":true,"is_expose":true}}}}]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		saved, err := readSecrets(bitriseSecretsPth)
+		require.NoError(t, err)
+		require.Len(t, saved.Envs, 1)
+		opts, err := saved.Envs[0].GetOptions()
+		require.NoError(t, err)
+		require.False(t, *opts.IsExpand)
+		require.True(t, *opts.IsSensitive)
+		require.True(t, *opts.SkipIfEmpty)
+		require.Equal(t, map[string]interface{}{
+			"bitrise.io": map[string]interface{}{"is_protected": true, "is_expose": true},
+			"other_tool": map[string]interface{}{"tag": "ci"},
+		}, opts.Meta)
+	}
+}
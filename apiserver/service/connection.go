@@ -1,33 +1,54 @@
 package service
 
 import (
+	"encoding/json"
 	"net/http"
-	"os"
-	"time"
+
+	"github.com/bitrise-io/go-utils/log"
 )
 
-var terminateTimer *time.Timer
-var terminateWaitTime = 5 * time.Second
+// SessionIDHeader identifies the editor tab a request comes from.
+const SessionIDHeader = "Bitrise-Session-Id"
 
-func startTerminateTimer() {
-	terminateTimer = time.AfterFunc(terminateWaitTime, func() {
-		os.Exit(0)
-	})
-}
+// sessionIDFromRequest reads the session id from the header or the JSON body; empty if the client
+// didn't send one (older frontends).
+func sessionIDFromRequest(r *http.Request) string {
+	if id := r.Header.Get(SessionIDHeader); id != "" {
+		return id
+	}
+	if r.Body == nil {
+		return ""
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
 
-func stopTerminateTimer() {
-	if terminateTimer != nil {
-		terminateTimer.Stop()
-		terminateTimer = nil
+	var reqObj connectionRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		return ""
 	}
+	return reqObj.SessionID
 }
 
-// DeleteConnectionHandler ...
+// DeleteConnectionHandler unregisters the session of a closing editor tab. The server shuts down
+// once the last session is gone for the idle timeout.
 func DeleteConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	startTerminateTimer()
+	Sessions.Disconnect(sessionIDFromRequest(r))
+	RespondWithJSON(w, http.StatusOK, connectionResponseModel{Sessions: Sessions.Count()})
 }
 
-// PostConnectionHandler ...
+// PostConnectionHandler registers an editor tab's session, or refreshes it when called again as
+// a heartbeat.
 func PostConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	stopTerminateTimer()
+	id := sessionIDFromRequest(r)
+	if id == "" {
+		Sessions.ConnectAnonymous()
+		RespondWithJSON(w, http.StatusOK, connectionResponseModel{Sessions: Sessions.Count()})
+		return
+	}
+
+	id = Sessions.Connect(id, r.UserAgent())
+	RespondWithJSON(w, http.StatusOK, connectionResponseModel{SessionID: id, Sessions: Sessions.Count()})
 }
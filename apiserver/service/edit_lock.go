@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+type editLockRequestModel struct {
+	SessionID string `json:"session_id"`
+	Resource  string `json:"resource"`
+}
+
+type editLockResponseModel struct {
+	Lock    EditLock `json:"lock"`
+	Granted bool     `json:"granted"`
+}
+
+type sessionsResponseModel struct {
+	Sessions []SessionInfo `json:"sessions"`
+	Locks    []EditLock    `json:"locks"`
+}
+
+func decodeEditLockRequest(w http.ResponseWriter, r *http.Request) (editLockRequestModel, bool) {
+	var reqObj editLockRequestModel
+	if r.Body == nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return reqObj, false
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return reqObj, false
+	}
+	if id := r.Header.Get(SessionIDHeader); id != "" {
+		reqObj.SessionID = id
+	}
+	if reqObj.SessionID == "" || reqObj.Resource == "" {
+		RespondWithJSONBadRequestErrorMessage(w, "session_id and resource are required")
+		return reqObj, false
+	}
+	return reqObj, true
+}
+
+// GetSessionsHandler lists the connected editor tabs and the edit locks they hold.
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, sessionsResponseModel{Sessions: Sessions.List(), Locks: Sessions.Locks()})
+}
+
+// PostEditLockHandler asks for the advisory edit lock on a resource (a config file path or a tree
+// node id). Responds with 409 and the current holder when another tab has it, so the editor can
+// open the resource read-only.
+func PostEditLockHandler(w http.ResponseWriter, r *http.Request) {
+	reqObj, ok := decodeEditLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lock, granted, err := Sessions.AcquireLock(reqObj.SessionID, reqObj.Resource)
+	if errors.Is(err, ErrUnknownSession) {
+		RespondWithJSONBadRequestErrorMessage(w, "Unknown session (%s), connect first", reqObj.SessionID)
+		return
+	}
+
+	status := http.StatusOK
+	if !granted {
+		status = http.StatusConflict
+	}
+	RespondWithJSON(w, status, editLockResponseModel{Lock: lock, Granted: granted})
+}
+
+// DeleteEditLockHandler releases the session's edit lock on a resource.
+func DeleteEditLockHandler(w http.ResponseWriter, r *http.Request) {
+	reqObj, ok := decodeEditLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	Sessions.ReleaseLock(reqObj.SessionID, reqObj.Resource)
+	RespondWithJSON(w, http.StatusOK, sessionsResponseModel{Sessions: Sessions.List(), Locks: Sessions.Locks()})
+}
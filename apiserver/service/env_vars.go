@@ -0,0 +1,798 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/gorilla/mux"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// EnvVar is an item of the app envs or of a workflow's envs.
+type EnvVar struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	IsExpand bool   `json:"is_expand"`
+	// Opts are the other options of the env, kept as they are.
+	Opts map[string]interface{} `json:"opts,omitempty"`
+	// File is the config module defining the env, relative to the repository root.
+	File     string `json:"file"`
+	Editable bool   `json:"editable"`
+}
+
+type envVarsResponseModel struct {
+	App       []EnvVar            `json:"app"`
+	Workflows map[string][]EnvVar `json:"workflows"`
+	// Warnings and SecretLeaks are only set by saves.
+	Warnings    *utility.WarningItems `json:"warnings,omitempty"`
+	SecretLeaks []SecretLeak          `json:"secret_leaks,omitempty"`
+}
+
+type envVarRequestModel struct {
+	Key      string                 `json:"key"`
+	Value    string                 `json:"value"`
+	IsExpand *bool                  `json:"is_expand"`
+	Opts     map[string]interface{} `json:"opts"`
+	// File picks the module a new env is added to; the root config for app envs and the module
+	// defining the workflow for workflow envs by default. For updates, it picks the module whose
+	// definition is updated instead of the effective one.
+	File string `json:"file"`
+}
+
+type reorderEnvVarsRequestModel struct {
+	// File is the module whose list is reordered, defaulting like for new envs.
+	File string   `json:"file"`
+	Keys []string `json:"keys"`
+}
+
+// envVarScope is the app envs if workflow is empty, the envs of the workflow otherwise.
+type envVarScope struct {
+	workflow string
+}
+
+func (s envVarScope) String() string {
+	if s.workflow == "" {
+		return "app"
+	}
+	return "workflow " + s.workflow
+}
+
+// envVarList is the env list of a scope in one module of the config tree. The list and its owner
+// mapping are nil if the module doesn't have them.
+type envVarList struct {
+	module *wireTreeNode
+	// ownerKey is the `app` or `<workflow id>` key node, owner its value.
+	ownerKey *yaml3.Node
+	owner    *yaml3.Node
+	envsKey  *yaml3.Node
+	envs     *yaml3.Node
+}
+
+func (l envVarList) checkBlockStyle(scope envVarScope) error {
+	if l.envs != nil && l.envs.Style&yaml3.FlowStyle != 0 && len(l.envs.Content) > 0 {
+		return fmt.Errorf("%s envs are in flow style in %s, edit them in the YAML", scope, l.module.Path)
+	}
+	return nil
+}
+
+func (l envVarList) items() []*yaml3.Node {
+	if l.envs == nil || l.envs.Kind != yaml3.SequenceNode {
+		return nil
+	}
+	return l.envs.Content
+}
+
+func mappingValue(mapping *yaml3.Node, key string) (*yaml3.Node, *yaml3.Node) {
+	if mapping == nil || mapping.Kind != yaml3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func findEnvVarList(module *wireTreeNode, scope envVarScope) (envVarList, error) {
+	list := envVarList{module: module}
+	var root yaml3.Node
+	if err := yaml3.Unmarshal([]byte(module.Contents), &root); err != nil {
+		return list, fmt.Errorf("invalid config (%s): %w", module.Path, err)
+	}
+	if len(root.Content) == 0 {
+		return list, nil
+	}
+
+	doc := root.Content[0]
+	if scope.workflow == "" {
+		list.ownerKey, list.owner = mappingValue(doc, "app")
+	} else {
+		_, workflows := mappingValue(doc, "workflows")
+		list.ownerKey, list.owner = mappingValue(workflows, scope.workflow)
+	}
+	list.envsKey, list.envs = mappingValue(list.owner, "envs")
+	return list, nil
+}
+
+func envVarFromNode(item *yaml3.Node, module *wireTreeNode) (EnvVar, error) {
+	// Decoded as a plain map: yaml.v3 would give the nested opts map the named type too.
+	var raw map[string]interface{}
+	if err := item.Decode(&raw); err != nil {
+		return EnvVar{}, err
+	}
+	env := envmanModels.EnvironmentItemModel(raw)
+	key, value, err := env.GetKeyValuePair()
+	if err != nil {
+		return EnvVar{}, err
+	}
+
+	envVar := EnvVar{Key: key, Value: value, IsExpand: true, File: module.Path, Editable: module.Editable}
+	if opts, ok := env[envmanModels.OptionsKey].(map[string]interface{}); ok {
+		if isExpand, ok := opts["is_expand"].(bool); ok {
+			envVar.IsExpand = isExpand
+		}
+		delete(opts, "is_expand")
+		if len(opts) > 0 {
+			envVar.Opts = opts
+		}
+	}
+	return envVar, nil
+}
+
+// envVarLists returns the lists of the scope in every resolved module, in merge order.
+func envVarLists(tree *wireTreeNode, scope envVarScope) ([]envVarList, error) {
+	var lists []envVarList
+	var walk func(node *wireTreeNode) error
+	walk = func(node *wireTreeNode) error {
+		if node.Error != "" {
+			return nil
+		}
+		for i := range node.Includes {
+			if err := walk(&node.Includes[i]); err != nil {
+				return err
+			}
+		}
+		list, err := findEnvVarList(node, scope)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, list)
+		return nil
+	}
+	return lists, walk(tree)
+}
+
+func listEnvVars(tree *wireTreeNode) (envVarsResponseModel, error) {
+	resp := envVarsResponseModel{App: []EnvVar{}, Workflows: map[string][]EnvVar{}}
+	collect := func(scope envVarScope) ([]EnvVar, error) {
+		lists, err := envVarLists(tree, scope)
+		if err != nil {
+			return nil, err
+		}
+		envVars := []EnvVar{}
+		for _, list := range lists {
+			for _, item := range list.items() {
+				envVar, err := envVarFromNode(item, list.module)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s envs: %w", list.module.Path, scope, err)
+				}
+				envVars = append(envVars, envVar)
+			}
+		}
+		return envVars, nil
+	}
+
+	var err error
+	if resp.App, err = collect(envVarScope{}); err != nil {
+		return resp, err
+	}
+
+	var workflowIDs []string
+	seen := map[string]bool{}
+	var walk func(node wireTreeNode)
+	walk = func(node wireTreeNode) {
+		for _, child := range node.Includes {
+			walk(child)
+		}
+		var module struct {
+			Workflows yaml3.Node `yaml:"workflows"`
+		}
+		if node.Error != "" || yaml3.Unmarshal([]byte(node.Contents), &module) != nil {
+			return
+		}
+		for i := 0; i+1 < len(module.Workflows.Content); i += 2 {
+			if id := module.Workflows.Content[i].Value; !seen[id] {
+				seen[id] = true
+				workflowIDs = append(workflowIDs, id)
+			}
+		}
+	}
+	walk(*tree)
+
+	for _, id := range workflowIDs {
+		if resp.Workflows[id], err = collect(envVarScope{workflow: id}); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// lastLine returns the last line of the config the node spans.
+func lastLine(node *yaml3.Node) int {
+	line := node.Line
+	if node.Kind == yaml3.ScalarNode {
+		if node.Style&(yaml3.LiteralStyle|yaml3.FoldedStyle) != 0 {
+			// Block scalars start on the line after the indicator.
+			line += strings.Count(strings.TrimRight(node.Value, "\n"), "\n") + 1
+		} else {
+			line += strings.Count(node.Value, "\n")
+		}
+	}
+	for _, child := range node.Content {
+		if childLine := lastLine(child); childLine > line {
+			line = childLine
+		}
+	}
+	return line
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// renderEnvVar renders a single env list item, indented by indent spaces.
+func renderEnvVar(envVar envVarRequestModel, indent int) (string, error) {
+	valueNode := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: envVar.Value}
+	if strings.Contains(envVar.Value, "\n") {
+		valueNode.Style = yaml3.LiteralStyle
+	}
+	item := &yaml3.Node{Kind: yaml3.MappingNode, Content: []*yaml3.Node{
+		{Kind: yaml3.ScalarNode, Value: envVar.Key},
+		valueNode,
+	}}
+
+	opts := map[string]interface{}{}
+	for name, value := range envVar.Opts {
+		opts[name] = value
+	}
+	delete(opts, "is_expand")
+	if envVar.IsExpand != nil && !*envVar.IsExpand {
+		opts["is_expand"] = false
+	}
+	if len(opts) > 0 {
+		optsNode := &yaml3.Node{}
+		if err := optsNode.Encode(opts); err != nil {
+			return "", err
+		}
+		item.Content = append(item.Content, &yaml3.Node{Kind: yaml3.ScalarNode, Value: envmanModels.OptionsKey}, optsNode)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml3.Node{Kind: yaml3.SequenceNode, Content: []*yaml3.Node{item}}); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	prefix := strings.Repeat(" ", indent)
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if strings.TrimSpace(line) != "" {
+			out.WriteString(prefix)
+		}
+		out.WriteString(line)
+	}
+	return out.String(), nil
+}
+
+// configLines splits the config into lines with their line breaks, addressed from 1.
+type configLines []string
+
+func newConfigLines(contents string) configLines {
+	if contents != "" && !strings.HasSuffix(contents, "\n") {
+		contents += "\n"
+	}
+	return configLines(strings.SplitAfter(contents, "\n"))
+}
+
+func (l configLines) line(n int) string {
+	if n < 1 || n > len(l) {
+		return ""
+	}
+	return l[n-1]
+}
+
+// splice replaces the lines from..to (inclusive) with text.
+func (l configLines) splice(from, to int, text string) string {
+	if from > len(l)+1 {
+		from = len(l) + 1
+	}
+	if to > len(l) {
+		to = len(l)
+	}
+	return strings.Join(l[:from-1], "") + text + strings.Join(l[to:], "")
+}
+
+// appendEnvVar adds the env to the end of the list, creating the list (and its owner) if needed.
+func appendEnvVar(list envVarList, envVar envVarRequestModel, scope envVarScope) (string, error) {
+	lines := newConfigLines(list.module.Contents)
+
+	if items := list.items(); len(items) > 0 {
+		if list.envs.Style&yaml3.FlowStyle != 0 {
+			return "", fmt.Errorf("%s envs are in flow style in %s, edit them in the YAML", scope, list.module.Path)
+		}
+		last := items[len(items)-1]
+		rendered, err := renderEnvVar(envVar, leadingSpaces(lines.line(last.Line)))
+		if err != nil {
+			return "", err
+		}
+		end := lastLine(last)
+		return lines.splice(end+1, end, rendered), nil
+	}
+
+	if list.envsKey != nil {
+		// `envs:` or `envs: []`
+		indent := list.envsKey.Column - 1
+		rendered, err := renderEnvVar(envVar, indent)
+		if err != nil {
+			return "", err
+		}
+		return lines.splice(list.envsKey.Line, list.envsKey.Line, strings.Repeat(" ", indent)+"envs:\n"+rendered), nil
+	}
+
+	if list.owner != nil && list.owner.Kind == yaml3.MappingNode && len(list.owner.Content) > 0 && list.owner.Style&yaml3.FlowStyle == 0 {
+		indent := list.owner.Content[0].Column - 1
+		rendered, err := renderEnvVar(envVar, indent)
+		if err != nil {
+			return "", err
+		}
+		end := lastLine(list.owner)
+		return lines.splice(end+1, end, strings.Repeat(" ", indent)+"envs:\n"+rendered), nil
+	}
+
+	if list.ownerKey != nil {
+		// An empty owner: `app:`, `app: {}` or `primary: {}`.
+		if list.owner != nil && list.owner.Kind == yaml3.MappingNode && len(list.owner.Content) > 0 {
+			return "", fmt.Errorf("%s is in flow style, edit it in the YAML", scope)
+		}
+		indent := list.ownerKey.Column - 1
+		rendered, err := renderEnvVar(envVar, indent+2)
+		if err != nil {
+			return "", err
+		}
+		text := strings.Repeat(" ", indent) + list.ownerKey.Value + ":\n" + strings.Repeat(" ", indent+2) + "envs:\n" + rendered
+		return lines.splice(list.ownerKey.Line, list.ownerKey.Line, text), nil
+	}
+
+	if scope.workflow != "" {
+		return "", fmt.Errorf("workflow %s is not defined in %s", scope.workflow, list.module.Path)
+	}
+	rendered, err := renderEnvVar(envVar, 2)
+	if err != nil {
+		return "", err
+	}
+	return lines.splice(len(lines)+1, len(lines), "app:\n  envs:\n"+rendered), nil
+}
+
+func replaceEnvVar(list envVarList, index int, envVar envVarRequestModel) (string, error) {
+	lines := newConfigLines(list.module.Contents)
+	item := list.items()[index]
+	rendered, err := renderEnvVar(envVar, leadingSpaces(lines.line(item.Line)))
+	if err != nil {
+		return "", err
+	}
+	return lines.splice(item.Line, lastLine(item), rendered), nil
+}
+
+func deleteEnvVar(list envVarList, index int) string {
+	lines := newConfigLines(list.module.Contents)
+	item := list.items()[index]
+	if len(list.items()) == 1 {
+		// Keep an empty list rather than a null one.
+		indent := strings.Repeat(" ", list.envsKey.Column-1)
+		return lines.splice(list.envsKey.Line, lastLine(item), indent+"envs: []\n")
+	}
+	return lines.splice(item.Line, lastLine(item), "")
+}
+
+// reorderEnvVars moves the items of the list into the order of keys. Lines between items (blank
+// lines, comments) stay where they are.
+func reorderEnvVars(list envVarList, keys []string) (string, error) {
+	items := list.items()
+	if len(keys) != len(items) {
+		return "", fmt.Errorf("expected the %d keys of the list in %s, got %d", len(items), list.module.Path, len(keys))
+	}
+
+	lines := newConfigLines(list.module.Contents)
+	texts := map[string]string{}
+	var gaps []string
+	for i, item := range items {
+		envVar, err := envVarFromNode(item, list.module)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := texts[envVar.Key]; ok {
+			return "", fmt.Errorf("duplicate key in %s: %s", list.module.Path, envVar.Key)
+		}
+		texts[envVar.Key] = strings.Join(lines[item.Line-1:lastLine(item)], "")
+		if i+1 < len(items) {
+			gaps = append(gaps, strings.Join(lines[lastLine(item):items[i+1].Line-1], ""))
+		}
+	}
+
+	var out strings.Builder
+	for i, key := range keys {
+		text, ok := texts[key]
+		if !ok {
+			return "", fmt.Errorf("%s is not in the list in %s", key, list.module.Path)
+		}
+		delete(texts, key)
+		out.WriteString(text)
+		if i < len(gaps) {
+			out.WriteString(gaps[i])
+		}
+	}
+	return lines.splice(items[0].Line, lastLine(items[len(items)-1]), out.String()), nil
+}
+
+// findEnvVar returns the list and index of the env's effective (last merged) definition, or of
+// its definition in file if it's set.
+func findEnvVar(lists []envVarList, key, file string) (envVarList, int, bool) {
+	for i := len(lists) - 1; i >= 0; i-- {
+		if file != "" && cleanModulePath(lists[i].module.Path) != cleanModulePath(file) {
+			continue
+		}
+		items := lists[i].items()
+		for j := len(items) - 1; j >= 0; j-- {
+			envVar, err := envVarFromNode(items[j], lists[i].module)
+			if err == nil && envVar.Key == key {
+				return lists[i], j, true
+			}
+		}
+	}
+	return envVarList{}, 0, false
+}
+
+// targetEnvVarList picks the list new envs are added to: the one in file, or the root config's
+// app envs, or the envs of the module defining the workflow (last in merge order).
+func targetEnvVarList(lists []envVarList, scope envVarScope, file string) (envVarList, error) {
+	if file != "" {
+		for _, list := range lists {
+			if cleanModulePath(list.module.Path) == cleanModulePath(file) {
+				return list, nil
+			}
+		}
+		return envVarList{}, fmt.Errorf("%s is not a module of the config", file)
+	}
+	if scope.workflow == "" {
+		return lists[len(lists)-1], nil
+	}
+	for i := len(lists) - 1; i >= 0; i-- {
+		if lists[i].ownerKey != nil {
+			return lists[i], nil
+		}
+	}
+	return envVarList{}, fmt.Errorf("unknown workflow: %s", scope.workflow)
+}
+
+type envVarsHandler struct {
+	repoRoot     string
+	rootPath     string
+	rootContents string
+	tree         wireTreeNode
+}
+
+// loadEnvVarsTree resolves the config tree of the request's project, responding with an error if
+// it fails.
+func loadEnvVarsTree(w http.ResponseWriter, r *http.Request) (envVarsHandler, bool) {
+	project := projectFor(r)
+	contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
+	if err != nil {
+		log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
+		return envVarsHandler{}, false
+	}
+	if r.Method != http.MethodGet && HasConfigVersionConflict(r, contStr) {
+		w.WriteHeader(http.StatusConflict)
+		return envVarsHandler{}, false
+	}
+
+	h := envVarsHandler{
+		repoRoot:     filepath.Dir(project.BitriseYMLPath),
+		rootPath:     filepath.Base(project.BitriseYMLPath),
+		rootContents: contStr,
+	}
+	h.tree = mirrorTreeResolver{repoRoot: h.repoRoot, mirrorDir: config.IncludeMirrorDir}.resolve(h.rootPath, contStr)
+	return h, true
+}
+
+// save scans the module's new contents for secrets and validates the merged config with them,
+// then writes the module. If some includes couldn't be resolved, the merge would be partial, so
+// nothing is written.
+func (h *envVarsHandler) save(w http.ResponseWriter, list envVarList, contents string) {
+	if !list.module.Editable {
+		RespondWithJSON(w, http.StatusForbidden, NewErrorResponse("%s is read-only", list.module.Path))
+		return
+	}
+	if unresolved := unresolvedIncludes(h.tree); len(unresolved) > 0 {
+		RespondWithJSONBadRequestErrorMessage(w, "The config can't be validated, some includes couldn't be resolved: %s", strings.Join(unresolved, ", "))
+		return
+	}
+
+	leaks, err := ScanSecretLeaks(list.module.Path, contents, config.StrictSecretScan)
+	if err != nil {
+		log.Errorf("Failed to scan for secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to scan for secrets, error: %s", err)
+		return
+	}
+	if config.StrictSecretScan && len(leaks) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, secretLeaksResponse{
+			Response:    NewErrorResponse("%s", secretLeaksError(leaks)),
+			SecretLeaks: leaks,
+		})
+		return
+	}
+	list.module.Contents = contents
+
+	mergedYML, err := mergeWireTree(h.tree)
+	if err != nil {
+		log.Errorf("Failed to merge config tree, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to merge config tree, error: %s", err)
+		return
+	}
+	warnings, err := utility.ValidateBitriseConfigAndSecret(mergedYML, config.MinimalValidSecrets)
+	if err != nil {
+		log.Errorf("Validation error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	if err := writeModuleFile(h.repoRoot, *list.module); err != nil {
+		log.Errorf("Failed to write module file (%s), error: %s", list.module.Path, err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to write module file (%s), error: %s", list.module.Path, err)
+		return
+	}
+
+	resp, err := listEnvVars(&h.tree)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to list env vars, error: %s", err)
+		return
+	}
+	resp.Warnings = withSecretLeakWarnings(warnings, leaks)
+	resp.SecretLeaks = leaks
+	AppendBitriseConfigVersionHeader(w, h.tree.Contents)
+	RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// unresolvedIncludes describes the includes of the tree that couldn't be resolved.
+func unresolvedIncludes(node wireTreeNode) []string {
+	var unresolved []string
+	for _, child := range node.Includes {
+		if child.Error != "" {
+			unresolved = append(unresolved, fmt.Sprintf("%s: include not resolved: %s", child.Path, child.Error))
+			continue
+		}
+		unresolved = append(unresolved, unresolvedIncludes(child)...)
+	}
+	return unresolved
+}
+
+func decodeEnvVarsRequest(w http.ResponseWriter, r *http.Request, reqObj interface{}) bool {
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return false
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	if err := json.NewDecoder(r.Body).Decode(reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return false
+	}
+	return true
+}
+
+func envVarScopeFor(r *http.Request) envVarScope {
+	return envVarScope{workflow: mux.Vars(r)["workflow"]}
+}
+
+// GetEnvVarsHandler lists the app envs and the envs of every workflow, with the module defining them.
+func GetEnvVarsHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := loadEnvVarsTree(w, r)
+	if !ok {
+		return
+	}
+	resp, err := listEnvVars(&h.tree)
+	if err != nil {
+		log.Errorf("Failed to list env vars, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to list env vars, error: %s", err)
+		return
+	}
+	AppendBitriseConfigVersionHeader(w, h.rootContents)
+	RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// PostEnvVarHandler adds an env to the end of the app envs or of a workflow's envs.
+func PostEnvVarHandler(w http.ResponseWriter, r *http.Request) {
+	scope := envVarScopeFor(r)
+	var reqObj envVarRequestModel
+	if !decodeEnvVarsRequest(w, r, &reqObj) {
+		return
+	}
+	if !envKeyPattern.MatchString(reqObj.Key) {
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid env key: %q", reqObj.Key)
+		return
+	}
+
+	h, ok := loadEnvVarsTree(w, r)
+	if !ok {
+		return
+	}
+	lists, err := envVarLists(&h.tree, scope)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	if _, _, found := findEnvVar(lists, reqObj.Key, ""); found {
+		RespondWithJSON(w, http.StatusConflict, NewErrorResponse("%s already has an env %s", scope, reqObj.Key))
+		return
+	}
+	list, err := targetEnvVarList(lists, scope, reqObj.File)
+	if err != nil {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("%s", err))
+		return
+	}
+
+	contents, err := appendEnvVar(list, reqObj, scope)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	h.save(w, list, contents)
+}
+
+// PutEnvVarHandler updates an env (and renames it if the key changes) in the module defining it.
+// Options not in the request are kept.
+func PutEnvVarHandler(w http.ResponseWriter, r *http.Request) {
+	scope := envVarScopeFor(r)
+	key := mux.Vars(r)["key"]
+	var reqObj envVarRequestModel
+	if !decodeEnvVarsRequest(w, r, &reqObj) {
+		return
+	}
+	if reqObj.Key == "" {
+		reqObj.Key = key
+	}
+	if !envKeyPattern.MatchString(reqObj.Key) {
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid env key: %q", reqObj.Key)
+		return
+	}
+
+	h, ok := loadEnvVarsTree(w, r)
+	if !ok {
+		return
+	}
+	lists, err := envVarLists(&h.tree, scope)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	list, index, found := findEnvVar(lists, key, reqObj.File)
+	if !found {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("%s has no env %s", scope, key))
+		return
+	}
+	if reqObj.Key != key {
+		if _, _, taken := findEnvVar(lists, reqObj.Key, ""); taken {
+			RespondWithJSON(w, http.StatusConflict, NewErrorResponse("%s already has an env %s", scope, reqObj.Key))
+			return
+		}
+	}
+
+	if err := list.checkBlockStyle(scope); err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	existing, err := envVarFromNode(list.items()[index], list.module)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	if reqObj.IsExpand == nil {
+		reqObj.IsExpand = &existing.IsExpand
+	}
+	if reqObj.Opts == nil {
+		reqObj.Opts = existing.Opts
+	}
+
+	contents, err := replaceEnvVar(list, index, reqObj)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	h.save(w, list, contents)
+}
+
+// DeleteEnvVarHandler removes an env from the module defining it, or from the module in the file
+// query parameter.
+func DeleteEnvVarHandler(w http.ResponseWriter, r *http.Request) {
+	scope := envVarScopeFor(r)
+	key := mux.Vars(r)["key"]
+
+	h, ok := loadEnvVarsTree(w, r)
+	if !ok {
+		return
+	}
+	lists, err := envVarLists(&h.tree, scope)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	list, index, found := findEnvVar(lists, key, r.URL.Query().Get("file"))
+	if !found {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("%s has no env %s", scope, key))
+		return
+	}
+	if err := list.checkBlockStyle(scope); err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	h.save(w, list, deleteEnvVar(list, index))
+}
+
+// PutEnvVarsOrderHandler reorders the envs of one module's list.
+func PutEnvVarsOrderHandler(w http.ResponseWriter, r *http.Request) {
+	scope := envVarScopeFor(r)
+	var reqObj reorderEnvVarsRequestModel
+	if !decodeEnvVarsRequest(w, r, &reqObj) {
+		return
+	}
+
+	h, ok := loadEnvVarsTree(w, r)
+	if !ok {
+		return
+	}
+	lists, err := envVarLists(&h.tree, scope)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	list, err := targetEnvVarList(lists, scope, reqObj.File)
+	if err != nil {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("%s", err))
+		return
+	}
+	if len(list.items()) == 0 {
+		RespondWithJSONBadRequestErrorMessage(w, "%s has no %s envs", list.module.Path, scope)
+		return
+	}
+	if err := list.checkBlockStyle(scope); err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+
+	contents, err := reorderEnvVars(list, reqObj.Keys)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "%s", err)
+		return
+	}
+	h.save(w, list, contents)
+}
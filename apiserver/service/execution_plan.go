@@ -0,0 +1,753 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/tools"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"github.com/bitrise-io/bitrise/v2/models"
+	envmanModels "github.com/bitrise-io/envman/v2/models"
+	"github.com/bitrise-io/go-utils/log"
+	stepmanModels "github.com/bitrise-io/stepman/models"
+	"gopkg.in/yaml.v2"
+)
+
+const redactedValue = "[REDACTED]"
+
+// PlanEnv is an env var as a step sees it.
+type PlanEnv struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PlanStep is a step that would run, with the inputs and env it would run with.
+type PlanStep struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	// Workflow is the workflow of the before_run/after_run chain the step is defined in.
+	Workflow    string            `json:"workflow"`
+	StepBundle  string            `json:"step_bundle,omitempty"`
+	Container   string            `json:"container,omitempty"`
+	RunIf       string            `json:"run_if,omitempty"`
+	IsAlwaysRun bool              `json:"is_always_run,omitempty"`
+	Skipped     bool              `json:"skipped"`
+	SkipReason  string            `json:"skip_reason,omitempty"`
+	Inputs      map[string]string `json:"inputs"`
+	Envs        []PlanEnv         `json:"envs"`
+}
+
+// PlanWorkflowRun is a workflow of a pipeline with its expanded steps.
+type PlanWorkflowRun struct {
+	ID         string     `json:"id"`
+	Workflow   string     `json:"workflow"`
+	DependsOn  []string   `json:"depends_on,omitempty"`
+	RunIf      string     `json:"run_if,omitempty"`
+	Skipped    bool       `json:"skipped"`
+	SkipReason string     `json:"skip_reason,omitempty"`
+	Steps      []PlanStep `json:"steps"`
+}
+
+// PlanStage is a group of workflow runs that run in parallel. For graph pipelines (workflows with
+// depends_on) the stages are the dependency levels and have no id.
+type PlanStage struct {
+	ID              string            `json:"id,omitempty"`
+	RunIf           string            `json:"run_if,omitempty"`
+	ShouldAlwaysRun bool              `json:"should_always_run,omitempty"`
+	AbortOnFail     bool              `json:"abort_on_fail,omitempty"`
+	Skipped         bool              `json:"skipped"`
+	SkipReason      string            `json:"skip_reason,omitempty"`
+	Workflows       []PlanWorkflowRun `json:"workflows"`
+}
+
+// ExecutionPlan is what running a workflow or a pipeline would execute.
+type ExecutionPlan struct {
+	Workflow string `json:"workflow,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	// Steps are the steps of a workflow run, in order.
+	Steps []PlanStep `json:"steps,omitempty"`
+	// Stages are the stages of a pipeline run, in order.
+	Stages   []PlanStage `json:"stages,omitempty"`
+	Warnings []string    `json:"warnings"`
+}
+
+type executionPlanRequestModel struct {
+	Workflow string `json:"workflow"`
+	Pipeline string `json:"pipeline"`
+	// BitriseYML is the editor's (possibly unsaved) config; the saved config is planned if empty.
+	BitriseYML string `json:"bitrise_yml"`
+	// Envs are the env vars the run starts with (e.g. secrets and trigger envs), in order.
+	Envs []envmanModels.EnvironmentItemModel `json:"envs"`
+	// WithStepDefaults fills in the default inputs of steplib steps, which needs the steplib locally.
+	WithStepDefaults bool `json:"with_step_defaults"`
+	runIfContext
+}
+
+// PostExecutionPlanHandler previews what running a workflow or a pipeline would execute, without
+// running anything.
+func PostExecutionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj executionPlanRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+	if (reqObj.Workflow == "") == (reqObj.Pipeline == "") {
+		RespondWithJSONBadRequestErrorMessage(w, "Exactly one of workflow and pipeline is required")
+		return
+	}
+
+	// The saved config is planned with its includes merged.
+	contStr := reqObj.BitriseYML
+	if contStr == "" {
+		var err error
+		if contStr, _, err = mergeConfigFromDisk(project.BitriseYMLPath); err != nil {
+			log.Errorf("Failed to merge bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to merge bitrise.yml, error: %s", err)
+			return
+		}
+	}
+
+	if _, err := utility.ValidateBitriseConfigAndSecret(contStr, config.MinimalValidSecrets); err != nil {
+		log.Errorf("Validation error: %s", err)
+		RespondWithJSON(w, http.StatusBadRequest, NewErrorResponseWithConfig(contStr, "%s", err.Error()))
+		return
+	}
+
+	plan, err := buildExecutionPlan(contStr, reqObj)
+	if err != nil {
+		log.Errorf("Failed to build execution plan, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to build execution plan, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, plan)
+}
+
+// planEnvs is an ordered set of env vars; setting an existing key moves it to the end, as
+// re-exporting it would.
+type planEnvs struct {
+	envs []PlanEnv
+}
+
+func (e *planEnvs) get(key string) (string, bool) {
+	for i := len(e.envs) - 1; i >= 0; i-- {
+		if e.envs[i].Key == key {
+			return e.envs[i].Value, true
+		}
+	}
+	return "", false
+}
+
+func (e *planEnvs) set(key, value string) {
+	for i, env := range e.envs {
+		if env.Key == key {
+			e.envs = append(e.envs[:i], e.envs[i+1:]...)
+			break
+		}
+	}
+	e.envs = append(e.envs, PlanEnv{Key: key, Value: value})
+}
+
+func (e *planEnvs) unset(key string) {
+	for i, env := range e.envs {
+		if env.Key == key {
+			e.envs = append(e.envs[:i], e.envs[i+1:]...)
+			return
+		}
+	}
+}
+
+// jsonList returns the envs in the shape the bitrise CLI evaluates templates with.
+func (e *planEnvs) jsonList() envmanModels.EnvsJSONListModel {
+	list := envmanModels.EnvsJSONListModel{}
+	for _, env := range e.envs {
+		list[env.Key] = env.Value
+	}
+	return list
+}
+
+func (e *planEnvs) clone() *planEnvs {
+	return &planEnvs{envs: append([]PlanEnv{}, e.envs...)}
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// expand resolves $VAR and ${VAR} references; unknown references are kept as is, so the plan
+// shows what's only known at run time (e.g. step outputs).
+func (e *planEnvs) expand(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		match := envReference.FindStringSubmatch(ref)
+		key := match[1]
+		if key == "" {
+			key = match[2]
+		}
+		if value, ok := e.get(key); ok {
+			return value
+		}
+		return ref
+	})
+}
+
+type planBuilder struct {
+	config   models.BitriseDataModel
+	ctx      runIfContext
+	defaults bool
+	warnings []string
+	// sensitive are the keys whose values are redacted in the plan.
+	sensitive map[string]bool
+}
+
+func buildExecutionPlan(contStr string, reqObj executionPlanRequestModel) (ExecutionPlan, error) {
+	b := planBuilder{ctx: reqObj.runIfContext, defaults: reqObj.WithStepDefaults, sensitive: map[string]bool{}}
+	if err := yaml.Unmarshal([]byte(contStr), &b.config); err != nil {
+		return ExecutionPlan{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	base := &planEnvs{}
+	if b.ctx.IsCI {
+		base.set("CI", "true")
+	}
+	if b.ctx.IsPR {
+		base.set("PR", "true")
+		if b.ctx.PullRequestID != "" {
+			base.set("BITRISE_PULL_REQUEST", b.ctx.PullRequestID)
+		}
+	}
+	if err := b.addEnvs(base, reqObj.Envs); err != nil {
+		return ExecutionPlan{}, fmt.Errorf("invalid envs: %w", err)
+	}
+	if err := b.addEnvs(base, b.config.App.Envs); err != nil {
+		return ExecutionPlan{}, fmt.Errorf("invalid app envs: %w", err)
+	}
+
+	plan := ExecutionPlan{Workflow: reqObj.Workflow, Pipeline: reqObj.Pipeline}
+	var err error
+	if reqObj.Workflow != "" {
+		plan.Steps, err = b.workflowRun(reqObj.Workflow, base)
+	} else {
+		plan.Stages, err = b.pipelineRun(reqObj.Pipeline, base)
+	}
+	if err != nil {
+		return ExecutionPlan{}, err
+	}
+
+	plan.Warnings = append([]string{}, b.warnings...)
+	return plan, nil
+}
+
+// addEnvs adds env items the way envman does: values are expanded unless is_expand is false,
+// empty values are skipped with skip_if_empty, and unset removes the key.
+func (b *planBuilder) addEnvs(envs *planEnvs, items []envmanModels.EnvironmentItemModel) error {
+	for _, item := range items {
+		key, value, err := item.GetKeyValuePair()
+		if err != nil {
+			return err
+		}
+		opts, err := item.GetOptions()
+		if err != nil {
+			return fmt.Errorf("invalid options of %s: %w", key, err)
+		}
+
+		if opts.Unset != nil && *opts.Unset {
+			envs.unset(key)
+			continue
+		}
+		if opts.IsExpand == nil || *opts.IsExpand {
+			value = envs.expand(value)
+		}
+		if value == "" && opts.SkipIfEmpty != nil && *opts.SkipIfEmpty {
+			continue
+		}
+		if opts.IsSensitive != nil && *opts.IsSensitive {
+			b.sensitive[key] = true
+		}
+		envs.set(key, value)
+	}
+	return nil
+}
+
+// workflowRun expands a workflow with its before_run and after_run chain into steps, starting
+// from the base env.
+func (b *planBuilder) workflowRun(workflowID string, base *planEnvs) ([]PlanStep, error) {
+	envs := base.clone()
+	envs.set("BITRISE_TRIGGERED_WORKFLOW_ID", workflowID)
+
+	chain, err := b.workflowChain(workflowID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []PlanStep{}
+	for _, id := range chain {
+		workflow := b.config.Workflows[id]
+		if err := b.addEnvs(envs, workflow.Envs); err != nil {
+			return nil, fmt.Errorf("invalid envs of workflow %s: %w", id, err)
+		}
+		if steps, err = b.steps(steps, workflow.Steps, id, "", "", envs); err != nil {
+			return nil, fmt.Errorf("workflow %s: %w", id, err)
+		}
+	}
+	return steps, nil
+}
+
+// workflowChain returns the workflows running for workflowID in order: its before_run chain,
+// itself, then its after_run chain.
+func (b *planBuilder) workflowChain(workflowID string, stack []string) ([]string, error) {
+	for _, id := range stack {
+		if id == workflowID {
+			return nil, fmt.Errorf("workflow %s references itself through before_run/after_run: %s", workflowID, strings.Join(append(stack, workflowID), " -> "))
+		}
+	}
+	workflow, ok := b.config.Workflows[workflowID]
+	if !ok {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("workflow %s not found", workflowID)
+		}
+		return nil, fmt.Errorf("workflow %s (referenced by %s) not found", workflowID, stack[len(stack)-1])
+	}
+
+	stack = append(stack, workflowID)
+	var chain []string
+	for _, id := range workflow.BeforeRun {
+		ids, err := b.workflowChain(id, stack)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, ids...)
+	}
+	chain = append(chain, workflowID)
+	for _, id := range workflow.AfterRun {
+		ids, err := b.workflowChain(id, stack)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, ids...)
+	}
+	return chain, nil
+}
+
+// reencode converts a generically decoded YAML value into out.
+func reencode(in interface{}, out interface{}) error {
+	content, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(content, out)
+}
+
+// steps appends the steps of a step list, expanding step bundles and `with` groups.
+func (b *planBuilder) steps(steps []PlanStep, items []models.StepListItemModel, workflowID, bundleID, container string, envs *planEnvs) ([]PlanStep, error) {
+	for _, item := range items {
+		key, itemType, err := item.GetKeyAndType()
+		if err != nil {
+			return nil, err
+		}
+
+		switch itemType {
+		case models.StepListItemTypeWith:
+			with, err := item.GetWith()
+			if err != nil {
+				return nil, fmt.Errorf("invalid with group: %w", err)
+			}
+			for _, withItem := range with.Steps {
+				ref, step, err := withItem.GetStepIDAndStep()
+				if err != nil {
+					return nil, fmt.Errorf("invalid with group: %w", err)
+				}
+				if steps, err = b.appendStep(steps, ref, step, workflowID, bundleID, with.ContainerID, envs); err != nil {
+					return nil, err
+				}
+			}
+		case models.StepListItemTypeBundle:
+			reference, err := item.GetBundle()
+			if err != nil {
+				return nil, fmt.Errorf("invalid step bundle reference %s: %w", key, err)
+			}
+			if steps, err = b.stepBundle(steps, strings.TrimPrefix(key, models.StepBundleIDPrefix), *reference, workflowID, container, envs); err != nil {
+				return nil, err
+			}
+		case models.StepListItemTypeStep:
+			step, err := item.GetStep()
+			if err != nil {
+				return nil, fmt.Errorf("step %s: invalid step: %w", key, err)
+			}
+			if steps, err = b.appendStep(steps, key, *step, workflowID, bundleID, container, envs); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown step list item: %s", key)
+		}
+	}
+	return steps, nil
+}
+
+func (b *planBuilder) appendStep(steps []PlanStep, ref string, step stepmanModels.StepModel, workflowID, bundleID, container string, envs *planEnvs) ([]PlanStep, error) {
+	planStep, err := b.step(ref, step, envs)
+	if err != nil {
+		return nil, fmt.Errorf("step %s: %w", ref, err)
+	}
+	planStep.Index = len(steps)
+	planStep.Workflow = workflowID
+	planStep.StepBundle = bundleID
+	planStep.Container = container
+	return append(steps, planStep), nil
+}
+
+func (b *planBuilder) stepBundle(steps []PlanStep, bundleID string, reference models.StepBundleListItemModel, workflowID, container string, envs *planEnvs) ([]PlanStep, error) {
+	bundle, ok := b.config.StepBundles[bundleID]
+	if !ok {
+		return nil, fmt.Errorf("step bundle %s not found", bundleID)
+	}
+
+	// A bundle's envs and inputs are only visible to its own steps.
+	bundleEnvs := envs.clone()
+	if err := b.addEnvs(bundleEnvs, bundle.Envs); err != nil {
+		return nil, fmt.Errorf("invalid envs of step bundle %s: %w", bundleID, err)
+	}
+	if err := b.addEnvs(bundleEnvs, bundle.Inputs); err != nil {
+		return nil, fmt.Errorf("invalid inputs of step bundle %s: %w", bundleID, err)
+	}
+	if err := b.addEnvs(bundleEnvs, reference.Inputs); err != nil {
+		return nil, fmt.Errorf("invalid inputs of step bundle reference %s: %w", bundleID, err)
+	}
+	return b.steps(steps, bundle.Steps, workflowID, bundleID, container, bundleEnvs)
+}
+
+func (b *planBuilder) step(ref string, step stepmanModels.StepModel, envs *planEnvs) (PlanStep, error) {
+	inputs := step.Inputs
+	if b.defaults {
+		defaults, err := b.stepDefaults(ref)
+		if err != nil {
+			b.warnings = append(b.warnings, fmt.Sprintf("Default inputs of %s are missing: %s", ref, err))
+		}
+		inputs = mergeStepInputs(defaults, step.Inputs)
+	}
+
+	planStep := PlanStep{ID: ref, Inputs: map[string]string{}}
+	if step.Title != nil {
+		planStep.Title = *step.Title
+	}
+	if step.IsAlwaysRun != nil {
+		planStep.IsAlwaysRun = *step.IsAlwaysRun
+	}
+
+	stepEnvs := envs.clone()
+	for _, input := range inputs {
+		key, value, err := input.GetKeyValuePair()
+		if err != nil {
+			return PlanStep{}, fmt.Errorf("invalid input: %w", err)
+		}
+		opts, err := input.GetOptions()
+		if err != nil {
+			return PlanStep{}, fmt.Errorf("invalid options of input %s: %w", key, err)
+		}
+
+		if opts.IsTemplate != nil && *opts.IsTemplate {
+			rendered, err := renderBitriseTemplate(value, envs, b.ctx)
+			if err != nil {
+				b.warnings = append(b.warnings, fmt.Sprintf("Template input %s of %s failed to render: %s", key, ref, err))
+			} else {
+				value = rendered
+			}
+		}
+		if opts.IsExpand == nil || *opts.IsExpand {
+			value = envs.expand(value)
+		}
+		stepEnvs.set(key, value)
+
+		if (opts.IsSensitive != nil && *opts.IsSensitive) || b.referencesSensitive(input) {
+			value = redactedValue
+		}
+		planStep.Inputs[key] = value
+	}
+
+	if step.RunIf != nil {
+		planStep.RunIf = *step.RunIf
+		run, err := evaluateRunIf(planStep.RunIf, stepEnvs, b.ctx)
+		if err != nil {
+			b.warnings = append(b.warnings, fmt.Sprintf("run_if of %s failed to evaluate: %s", ref, err))
+		} else if !run {
+			planStep.Skipped = true
+			planStep.SkipReason = "run_if evaluated to false"
+		}
+	}
+	if b.ctx.IsBuildFailed && !planStep.IsAlwaysRun && !planStep.Skipped {
+		planStep.Skipped = true
+		planStep.SkipReason = "the build has failed and the step is not is_always_run"
+	}
+
+	planStep.Envs = b.redact(envs.envs)
+	return planStep, nil
+}
+
+// referencesSensitive reports whether an input's value references a sensitive env var.
+func (b *planBuilder) referencesSensitive(input envmanModels.EnvironmentItemModel) bool {
+	_, value, err := input.GetKeyValuePair()
+	if err != nil {
+		return false
+	}
+	for _, match := range envReference.FindAllStringSubmatch(value, -1) {
+		if b.sensitive[match[1]] || b.sensitive[match[2]] {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *planBuilder) redact(envs []PlanEnv) []PlanEnv {
+	redacted := make([]PlanEnv, 0, len(envs))
+	for _, env := range envs {
+		if b.sensitive[env.Key] {
+			env.Value = redactedValue
+		}
+		redacted = append(redacted, env)
+	}
+	return redacted
+}
+
+// stepDefaults returns the inputs of a steplib step's definition.
+func (b *planBuilder) stepDefaults(ref string) ([]envmanModels.EnvironmentItemModel, error) {
+	library, id, version, ok := parseStepLibReference(ref, b.config.DefaultStepLibSource)
+	if !ok {
+		return nil, nil
+	}
+	stepInfo, err := tools.StepmanStepInfo(library, id, version)
+	if err != nil {
+		return nil, err
+	}
+	return stepInfo.Step.Inputs, nil
+}
+
+// parseStepLibReference parses steplib step references: `id`, `id@version` and
+// `library::id@version`. Other references (path::, git::) have no steplib definition.
+func parseStepLibReference(ref, defaultLibrary string) (library, id, version string, ok bool) {
+	library = defaultLibrary
+	if idx := strings.Index(ref, "::"); idx >= 0 {
+		library = ref[:idx]
+		ref = ref[idx+2:]
+		if library == "path" || library == "git" {
+			return "", "", "", false
+		}
+	}
+	if library == "" {
+		return "", "", "", false
+	}
+	id, version, _ = strings.Cut(ref, "@")
+	return library, id, version, true
+}
+
+// mergeStepInputs returns the default inputs with the values overridden in the config.
+func mergeStepInputs(defaults, overrides []envmanModels.EnvironmentItemModel) []envmanModels.EnvironmentItemModel {
+	overridden := map[string]envmanModels.EnvironmentItemModel{}
+	for _, input := range overrides {
+		if key, _, err := input.GetKeyValuePair(); err == nil {
+			overridden[key] = input
+		}
+	}
+
+	merged := []envmanModels.EnvironmentItemModel{}
+	used := map[string]bool{}
+	for _, input := range defaults {
+		key, _, err := input.GetKeyValuePair()
+		if err != nil {
+			continue
+		}
+		if override, ok := overridden[key]; ok {
+			item := envmanModels.EnvironmentItemModel{key: override[key]}
+			if opts, ok := input[envmanModels.OptionsKey]; ok {
+				item[envmanModels.OptionsKey] = opts
+			}
+			if opts, ok := override[envmanModels.OptionsKey]; ok {
+				item[envmanModels.OptionsKey] = opts
+			}
+			input = item
+			used[key] = true
+		}
+		merged = append(merged, input)
+	}
+	for _, input := range overrides {
+		if key, _, err := input.GetKeyValuePair(); err == nil && !used[key] {
+			merged = append(merged, input)
+		}
+	}
+	return merged
+}
+
+// pipelineRun expands a pipeline into stages: the stages of a staged pipeline, or the dependency
+// levels of a graph pipeline. Every workflow of a pipeline runs as its own build, starting from base.
+func (b *planBuilder) pipelineRun(pipelineID string, base *planEnvs) ([]PlanStage, error) {
+	pipeline, ok := b.config.Pipelines[pipelineID]
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s not found", pipelineID)
+	}
+
+	base = base.clone()
+	base.set("BITRISE_TRIGGERED_PIPELINE_ID", pipelineID)
+
+	if len(pipeline.Stages) > 0 {
+		return b.stagedPipelineRun(pipeline, base)
+	}
+	return b.graphPipelineRun(pipeline, base)
+}
+
+func (b *planBuilder) stagedPipelineRun(pipeline models.PipelineModel, base *planEnvs) ([]PlanStage, error) {
+	var stages []PlanStage
+	for _, item := range pipeline.Stages {
+		if len(item) != 1 {
+			return nil, fmt.Errorf("stage list item should have exactly one key, has: %v", sortedKeys(item))
+		}
+		stageID := sortedKeys(item)[0]
+		stage, ok := b.config.Stages[stageID]
+		if !ok {
+			return nil, fmt.Errorf("stage %s not found", stageID)
+		}
+
+		planStage := PlanStage{ID: stageID, RunIf: stage.RunIf, ShouldAlwaysRun: stage.ShouldAlwaysRun, AbortOnFail: stage.AbortOnFail, Workflows: []PlanWorkflowRun{}}
+		if run, err := evaluateRunIf(stage.RunIf, base, b.ctx); err != nil {
+			b.warnings = append(b.warnings, fmt.Sprintf("run_if of stage %s failed to evaluate: %s", stageID, err))
+		} else if !run {
+			planStage.Skipped = true
+			planStage.SkipReason = "run_if evaluated to false"
+		}
+
+		for _, workflowItem := range stage.Workflows {
+			for workflowID, workflowOpts := range workflowItem {
+				run, err := b.pipelineWorkflowRun(workflowID, workflowID, workflowOpts.RunIf, base)
+				if err != nil {
+					return nil, fmt.Errorf("stage %s: %w", stageID, err)
+				}
+				stageSkipped(&run, planStage)
+				planStage.Workflows = append(planStage.Workflows, run)
+			}
+		}
+		stages = append(stages, planStage)
+	}
+	return stages, nil
+}
+
+func stageSkipped(run *PlanWorkflowRun, stage PlanStage) {
+	if stage.Skipped && !run.Skipped {
+		run.Skipped = true
+		run.SkipReason = "its stage is skipped"
+	}
+}
+
+func (b *planBuilder) graphPipelineRun(pipeline models.PipelineModel, base *planEnvs) ([]PlanStage, error) {
+	levels, err := dependencyLevels(pipeline.Workflows)
+	if err != nil {
+		return nil, err
+	}
+
+	var stages []PlanStage
+	skipped := map[string]bool{}
+	for _, level := range levels {
+		planStage := PlanStage{Workflows: []PlanWorkflowRun{}}
+		for _, id := range level {
+			node := pipeline.Workflows[id]
+			workflowID := id
+			if node.Uses != "" {
+				workflowID = node.Uses
+			}
+
+			run, err := b.pipelineWorkflowRun(id, workflowID, node.RunIf.Expression, base)
+			if err != nil {
+				return nil, err
+			}
+			run.DependsOn = node.DependsOn
+			for _, dependency := range node.DependsOn {
+				if skipped[dependency] && !run.Skipped {
+					run.Skipped = true
+					run.SkipReason = fmt.Sprintf("it depends on %s, which is skipped", dependency)
+				}
+			}
+			skipped[id] = run.Skipped
+			planStage.Workflows = append(planStage.Workflows, run)
+		}
+		stages = append(stages, planStage)
+	}
+	return stages, nil
+}
+
+func (b *planBuilder) pipelineWorkflowRun(id, workflowID, runIf string, base *planEnvs) (PlanWorkflowRun, error) {
+	run := PlanWorkflowRun{ID: id, Workflow: workflowID, RunIf: runIf}
+	steps, err := b.workflowRun(workflowID, base)
+	if err != nil {
+		return PlanWorkflowRun{}, err
+	}
+	run.Steps = steps
+
+	if ok, err := evaluateRunIf(runIf, base, b.ctx); err != nil {
+		b.warnings = append(b.warnings, fmt.Sprintf("run_if of workflow %s failed to evaluate: %s", id, err))
+	} else if !ok {
+		run.Skipped = true
+		run.SkipReason = "run_if evaluated to false"
+	}
+	return run, nil
+}
+
+// dependencyLevels orders the workflows of a graph pipeline into levels: a workflow is in the level
+// after the last of its dependencies. Workflows are sorted by id within a level.
+func dependencyLevels(workflows models.GraphPipelineWorkflowListItemModel) ([][]string, error) {
+	level := map[string]int{}
+	var visit func(id string, stack []string) (int, error)
+	visit = func(id string, stack []string) (int, error) {
+		if l, ok := level[id]; ok {
+			return l, nil
+		}
+		for _, s := range stack {
+			if s == id {
+				return 0, fmt.Errorf("pipeline workflows depend on each other in a cycle: %s", strings.Join(append(stack, id), " -> "))
+			}
+		}
+		node, ok := workflows[id]
+		if !ok {
+			return 0, fmt.Errorf("workflow %s (a dependency of %s) is not in the pipeline", id, stack[len(stack)-1])
+		}
+
+		l := 0
+		for _, dependency := range node.DependsOn {
+			dl, err := visit(dependency, append(stack, id))
+			if err != nil {
+				return 0, err
+			}
+			if dl+1 > l {
+				l = dl + 1
+			}
+		}
+		level[id] = l
+		return l, nil
+	}
+
+	var levels [][]string
+	for _, id := range sortedKeys(workflows) {
+		l, err := visit(id, nil)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], id)
+	}
+	return levels, nil
+}
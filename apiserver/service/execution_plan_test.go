@@ -0,0 +1,216 @@
+package service
+
+import (
+	"testing"
+
+	envmanModels "github.com/bitrise-io/envman/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+const planTestConfig = `format_version: "13"
+default_step_lib_source: https://github.com/bitrise-io/bitrise-steplib.git
+app:
+  envs:
+  - PROJECT: app
+  - OUTPUT_DIR: $BITRISE_DEPLOY_DIR/$PROJECT
+step_bundles:
+  install:
+    inputs:
+    - CACHE_KEY: deps
+    steps:
+    - script@1:
+        title: Install
+        inputs:
+        - content: install $CACHE_KEY
+workflows:
+  _setup:
+    steps:
+    - git-clone@8: {}
+  _report:
+    steps:
+    - deploy-to-bitrise-io@2:
+        is_always_run: true
+  test:
+    before_run:
+    - _setup
+    after_run:
+    - _report
+    envs:
+    - TOKEN: s3cr3t
+      opts:
+        is_sensitive: true
+    steps:
+    - bundle::install:
+        inputs:
+        - CACHE_KEY: test-deps
+    - with:
+        container: node
+        steps:
+        - script@1:
+            run_if: '{{enveq "PROJECT" "app"}}'
+            inputs:
+            - content: npm test --token $TOKEN
+    - slack@4:
+        run_if: .IsPR
+        inputs:
+        - channel: '#ci'
+        - message: |-
+            {{ getenv "PROJECT" }} built
+          opts:
+            is_template: true
+  build:
+    steps:
+    - script@1: {}
+  lint:
+    steps:
+    - script@1: {}
+stages:
+  check:
+    workflows:
+    - test: {}
+    - lint:
+        run_if: '{{.IsCI}}'
+  ship:
+    run_if: '{{enveq "DEPLOY" "true"}}'
+    workflows:
+    - build: {}
+pipelines:
+  staged:
+    stages:
+    - check: {}
+    - ship: {}
+  graph:
+    workflows:
+      build:
+        depends_on:
+        - lint
+        - unit
+      lint:
+        run_if:
+          expression: '{{.IsPR}}'
+      unit:
+        uses: test
+`
+
+func TestBuildExecutionPlan(t *testing.T) {
+	t.Log("workflow with before_run, after_run, step bundle and with group")
+	{
+		plan, err := buildExecutionPlan(planTestConfig, executionPlanRequestModel{
+			Workflow: "test",
+			Envs:     []envmanModels.EnvironmentItemModel{{"BITRISE_DEPLOY_DIR": "/tmp/deploy"}},
+		})
+		require.NoError(t, err)
+		require.Empty(t, plan.Warnings)
+
+		var ids, workflows []string
+		for _, step := range plan.Steps {
+			ids = append(ids, step.ID)
+			workflows = append(workflows, step.Workflow)
+		}
+		require.Equal(t, []string{"git-clone@8", "script@1", "script@1", "slack@4", "deploy-to-bitrise-io@2"}, ids)
+		require.Equal(t, []string{"_setup", "test", "test", "test", "_report"}, workflows)
+
+		install := plan.Steps[1]
+		require.Equal(t, "install", install.StepBundle)
+		require.Equal(t, "install test-deps", install.Inputs["content"])
+
+		npm := plan.Steps[2]
+		require.Equal(t, "node", npm.Container)
+		require.False(t, npm.Skipped)
+		require.Equal(t, redactedValue, npm.Inputs["content"])
+		require.Contains(t, npm.Envs, PlanEnv{Key: "OUTPUT_DIR", Value: "/tmp/deploy/app"})
+		require.Contains(t, npm.Envs, PlanEnv{Key: "TOKEN", Value: redactedValue})
+		require.NotContains(t, npm.Envs, PlanEnv{Key: "CACHE_KEY", Value: "test-deps"})
+
+		slack := plan.Steps[3]
+		require.True(t, slack.Skipped)
+		require.Equal(t, "run_if evaluated to false", slack.SkipReason)
+		require.Equal(t, "app built", slack.Inputs["message"])
+
+		require.True(t, plan.Steps[4].IsAlwaysRun)
+	}
+
+	t.Log("failed build only runs is_always_run steps")
+	{
+		plan, err := buildExecutionPlan(planTestConfig, executionPlanRequestModel{Workflow: "_report", runIfContext: runIfContext{IsBuildFailed: true}})
+		require.NoError(t, err)
+		require.False(t, plan.Steps[0].Skipped)
+
+		plan, err = buildExecutionPlan(planTestConfig, executionPlanRequestModel{Workflow: "_setup", runIfContext: runIfContext{IsBuildFailed: true}})
+		require.NoError(t, err)
+		require.True(t, plan.Steps[0].Skipped)
+	}
+
+	t.Log("staged pipeline")
+	{
+		plan, err := buildExecutionPlan(planTestConfig, executionPlanRequestModel{Pipeline: "staged"})
+		require.NoError(t, err)
+		require.Len(t, plan.Stages, 2)
+
+		require.Equal(t, "check", plan.Stages[0].ID)
+		require.Equal(t, "test", plan.Stages[0].Workflows[0].ID)
+		require.Len(t, plan.Stages[0].Workflows[0].Steps, 5)
+		require.True(t, plan.Stages[0].Workflows[1].Skipped)
+
+		require.True(t, plan.Stages[1].Skipped)
+		require.Equal(t, "its stage is skipped", plan.Stages[1].Workflows[0].SkipReason)
+	}
+
+	t.Log("graph pipeline")
+	{
+		plan, err := buildExecutionPlan(planTestConfig, executionPlanRequestModel{Pipeline: "graph", runIfContext: runIfContext{IsCI: true}})
+		require.NoError(t, err)
+		require.Len(t, plan.Stages, 2)
+
+		require.Equal(t, "lint", plan.Stages[0].Workflows[0].ID)
+		require.True(t, plan.Stages[0].Workflows[0].Skipped)
+		require.Equal(t, "unit", plan.Stages[0].Workflows[1].ID)
+		require.Equal(t, "test", plan.Stages[0].Workflows[1].Workflow)
+		require.Contains(t, plan.Stages[0].Workflows[1].Steps[0].Envs, PlanEnv{Key: "CI", Value: "true"})
+
+		require.Equal(t, "build", plan.Stages[1].Workflows[0].ID)
+		require.Equal(t, []string{"lint", "unit"}, plan.Stages[1].Workflows[0].DependsOn)
+		require.Equal(t, "it depends on lint, which is skipped", plan.Stages[1].Workflows[0].SkipReason)
+	}
+
+	t.Log("errors")
+	{
+		_, err := buildExecutionPlan(planTestConfig, executionPlanRequestModel{Workflow: "deploy"})
+		require.EqualError(t, err, "workflow deploy not found")
+
+		_, err = buildExecutionPlan(`workflows:
+  a:
+    after_run: [b]
+  b:
+    before_run: [a]
+`, executionPlanRequestModel{Workflow: "a"})
+		require.EqualError(t, err, "workflow a references itself through before_run/after_run: a -> b -> a")
+
+		_, err = buildExecutionPlan(`pipelines:
+  p:
+    workflows:
+      a:
+        depends_on: [b]
+      b:
+        depends_on: [a]
+`, executionPlanRequestModel{Pipeline: "p"})
+		require.EqualError(t, err, "pipeline workflows depend on each other in a cycle: a -> b -> a")
+	}
+}
+
+func TestParseStepLibReference(t *testing.T) {
+	library, id, version, ok := parseStepLibReference("git-clone@8", "https://github.com/bitrise-io/bitrise-steplib.git")
+	require.True(t, ok)
+	require.Equal(t, "https://github.com/bitrise-io/bitrise-steplib.git", library)
+	require.Equal(t, "git-clone", id)
+	require.Equal(t, "8", version)
+
+	library, id, version, ok = parseStepLibReference("https://example.com/steplib.git::script", "")
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/steplib.git", library)
+	require.Equal(t, "script", id)
+	require.Equal(t, "", version)
+
+	_, _, _, ok = parseStepLibReference("path::./steps/my-step", "https://github.com/bitrise-io/bitrise-steplib.git")
+	require.False(t, ok)
+}
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/templates"
+	"github.com/bitrise-io/go-utils/log"
+	"gopkg.in/yaml.v2"
+)
+
+// maxDetectionDepth bounds how deep below the config's directory projects are looked for.
+const maxDetectionDepth = 4
+
+// ProjectRecommendation is a detected project with the starter template and step inputs matching it.
+// The editor applies it by rendering TemplateID with Parameters, or by setting StepInputs on an
+// existing config.
+type ProjectRecommendation struct {
+	ProjectType string `json:"project_type"`
+	// Path is the project's directory relative to the config's directory.
+	Path       string            `json:"path"`
+	TemplateID string            `json:"template_id"`
+	Parameters map[string]string `json:"parameters"`
+	Workflows  []string          `json:"workflows"`
+	// StepInputs are the detected inputs keyed by step id.
+	StepInputs map[string]map[string]string `json:"step_inputs,omitempty"`
+	// Alternatives are other detected values for a parameter, e.g. every shared scheme.
+	Alternatives map[string][]string `json:"alternatives,omitempty"`
+	// Evidence lists the files the detection is based on.
+	Evidence []string `json:"evidence"`
+}
+
+type projectRecommendationsResponseModel struct {
+	Recommendations []ProjectRecommendation `json:"recommendations"`
+}
+
+// GetProjectRecommendationsHandler inspects the repository around the project's config and
+// recommends a project type, template parameters and step inputs.
+func GetProjectRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	root, err := filepath.Abs(filepath.Dir(projectFor(r).BitriseYMLPath))
+	if err != nil {
+		log.Errorf("Failed to get project directory, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to get project directory, error: %s", err)
+		return
+	}
+
+	recommendations, err := DetectProjects(root)
+	if err != nil {
+		log.Errorf("Failed to detect project type, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to detect project type, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, projectRecommendationsResponseModel{Recommendations: recommendations})
+}
+
+// DetectProjects looks for Flutter, Node.js (React Native, Ionic, Cordova), Xcode and Gradle projects
+// under root. Flutter and hybrid projects own their ios/ and android/ subdirectories, so those are
+// not reported separately. Without any match a generic recommendation is returned.
+func DetectProjects(root string) ([]ProjectRecommendation, error) {
+	var recommendations []ProjectRecommendation
+	err := filepath.WalkDir(root, func(pth string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		rel, err := filepath.Rel(root, pth)
+		if err != nil {
+			return err
+		}
+		if pth != root {
+			if strings.HasPrefix(name, ".") || skippedDiscoveryDirs[name] {
+				return filepath.SkipDir
+			}
+			if ext := filepath.Ext(name); ext == ".xcodeproj" || ext == ".xcworkspace" {
+				return filepath.SkipDir
+			}
+			if strings.Count(filepath.ToSlash(rel), "/")+1 > maxDetectionDepth {
+				return filepath.SkipDir
+			}
+		}
+
+		detected, owned := detectProjectInDir(root, pth)
+		recommendations = append(recommendations, detected...)
+		if owned {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recommendations) == 0 {
+		return []ProjectRecommendation{newRecommendation("generic", ".", nil)}, nil
+	}
+
+	// Mobile projects are the better match; generic ones only come first if nothing else is found.
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].ProjectType != "other" && recommendations[j].ProjectType == "other"
+	})
+	return recommendations, nil
+}
+
+// detectProjectInDir detects the projects in one directory. owned reports that the directory's
+// subtree belongs to the detected project and shouldn't be searched further.
+func detectProjectInDir(root, dir string) (detected []ProjectRecommendation, owned bool) {
+	if recommendation, ok := detectFlutter(root, dir); ok {
+		return []ProjectRecommendation{recommendation}, true
+	}
+	if recommendation, ok := detectNode(root, dir); ok {
+		if recommendation.ProjectType != "other" {
+			return []ProjectRecommendation{recommendation}, true
+		}
+		// A plain Node.js package: nested native projects are its own, e.g. a web app with an
+		// embedded iOS demo, so keep searching below it.
+		detected = append(detected, recommendation)
+	}
+	if recommendation, ok := detectXcode(root, dir); ok {
+		detected = append(detected, recommendation)
+	}
+	if recommendation, ok := detectGradle(root, dir); ok {
+		return append(detected, recommendation), true
+	}
+	return detected, false
+}
+
+func newRecommendation(templateID, path string, parameters map[string]string) ProjectRecommendation {
+	template, _ := templates.Get(templateID)
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	return ProjectRecommendation{
+		ProjectType: template.ProjectType,
+		Path:        filepath.ToSlash(path),
+		TemplateID:  templateID,
+		Parameters:  parameters,
+		Workflows:   template.Workflows,
+		Evidence:    []string{},
+	}
+}
+
+// relToRoot returns pth relative to root in slash form, "." for root itself.
+func relToRoot(root, pth string) string {
+	rel, err := filepath.Rel(root, pth)
+	if err != nil {
+		return filepath.ToSlash(pth)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func isDir(pth string) bool {
+	info, err := os.Stat(pth)
+	return err == nil && info.IsDir()
+}
+
+func detectFlutter(root, dir string) (ProjectRecommendation, bool) {
+	pubspecPth := filepath.Join(dir, "pubspec.yaml")
+	content, err := os.ReadFile(pubspecPth)
+	if err != nil {
+		return ProjectRecommendation{}, false
+	}
+
+	var pubspec struct {
+		Dependencies map[string]interface{} `yaml:"dependencies"`
+	}
+	if err := yaml.Unmarshal(content, &pubspec); err != nil {
+		log.Warnf("Failed to parse %s, error: %s", pubspecPth, err)
+		return ProjectRecommendation{}, false
+	}
+	if _, ok := pubspec.Dependencies["flutter"]; !ok {
+		return ProjectRecommendation{}, false
+	}
+
+	location := relToRoot(root, dir)
+	platform := "both"
+	hasIOS, hasAndroid := isDir(filepath.Join(dir, "ios")), isDir(filepath.Join(dir, "android"))
+	if hasIOS && !hasAndroid {
+		platform = "ios"
+	} else if hasAndroid && !hasIOS {
+		platform = "android"
+	}
+
+	recommendation := newRecommendation("flutter", location, map[string]string{"project_location": location, "platform": platform})
+	recommendation.StepInputs = map[string]map[string]string{
+		"flutter-test":  {"project_location": location},
+		"flutter-build": {"project_location": location, "platform": platform},
+	}
+	recommendation.Evidence = append(recommendation.Evidence, relToRoot(root, pubspecPth))
+	return recommendation, true
+}
+
+func detectNode(root, dir string) (ProjectRecommendation, bool) {
+	packageJSONPth := filepath.Join(dir, "package.json")
+	content, err := os.ReadFile(packageJSONPth)
+	if err != nil {
+		return ProjectRecommendation{}, false
+	}
+
+	var packageJSON struct {
+		Scripts         map[string]string `json:"scripts"`
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(content, &packageJSON); err != nil {
+		log.Warnf("Failed to parse %s, error: %s", packageJSONPth, err)
+		return ProjectRecommendation{}, false
+	}
+	hasDependency := func(name string) bool {
+		_, inDeps := packageJSON.Dependencies[name]
+		_, inDevDeps := packageJSON.DevDependencies[name]
+		return inDeps || inDevDeps
+	}
+
+	packageManager := "npm"
+	if _, err := os.Stat(filepath.Join(dir, "yarn.lock")); err == nil {
+		packageManager = "yarn"
+	}
+	testCommand := packageManager + " install\n" + packageManager + " test"
+	if _, ok := packageJSON.Scripts["test"]; !ok {
+		testCommand = packageManager + " install"
+	}
+	location := relToRoot(root, dir)
+	if location != "." {
+		testCommand = "cd " + location + "\n" + testCommand
+	}
+
+	parameters := map[string]string{"test_command": testCommand}
+	recommendation := newRecommendation("generic", location, parameters)
+	switch {
+	case hasDependency("react-native"):
+		recommendation.ProjectType = "react-native"
+	case hasDependency("@ionic/core") || hasDependency("@ionic/angular") || hasDependency("@ionic/react") || hasDependency("@ionic/vue"):
+		recommendation.ProjectType = "ionic"
+	case hasDependency("cordova-ios") || hasDependency("cordova-android"):
+		recommendation.ProjectType = "cordova"
+	}
+	// The generic starter renders the detected type, so the config matches the recommendation.
+	if recommendation.ProjectType != "other" {
+		parameters["project_type"] = recommendation.ProjectType
+	}
+	recommendation.Evidence = append(recommendation.Evidence, relToRoot(root, packageJSONPth))
+	return recommendation, true
+}
+
+func detectXcode(root, dir string) (ProjectRecommendation, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ProjectRecommendation{}, false
+	}
+
+	var workspaces, projects []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".xcworkspace":
+			workspaces = append(workspaces, entry.Name())
+		case ".xcodeproj":
+			projects = append(projects, entry.Name())
+		}
+	}
+	if len(workspaces) == 0 && len(projects) == 0 {
+		return ProjectRecommendation{}, false
+	}
+
+	// A workspace (e.g. CocoaPods) wraps the projects next to it, so it's what should be built.
+	candidates := append(workspaces, projects...)
+	var schemes []string
+	for _, container := range candidates {
+		schemes = append(schemes, sharedSchemes(filepath.Join(dir, container))...)
+	}
+	schemes = uniqueSorted(schemes)
+
+	projectPath := relToRoot(root, filepath.Join(dir, candidates[0]))
+	parameters := map[string]string{"project_path": projectPath}
+	recommendation := newRecommendation("ios", relToRoot(root, dir), parameters)
+	inputs := map[string]string{"project_path": projectPath}
+	if len(schemes) > 0 {
+		parameters["scheme"] = schemes[0]
+		inputs["scheme"] = schemes[0]
+	}
+	recommendation.StepInputs = map[string]map[string]string{"xcode-test": inputs, "xcode-archive": inputs}
+
+	recommendation.Alternatives = map[string][]string{}
+	if len(candidates) > 1 {
+		for _, container := range candidates {
+			recommendation.Alternatives["project_path"] = append(recommendation.Alternatives["project_path"], relToRoot(root, filepath.Join(dir, container)))
+		}
+	}
+	if len(schemes) > 1 {
+		recommendation.Alternatives["scheme"] = schemes
+	}
+	for _, container := range candidates {
+		recommendation.Evidence = append(recommendation.Evidence, relToRoot(root, filepath.Join(dir, container)))
+	}
+	return recommendation, true
+}
+
+// sharedSchemes returns the shared scheme names of an Xcode project or workspace.
+func sharedSchemes(container string) []string {
+	matches, err := filepath.Glob(filepath.Join(container, "xcshareddata", "xcschemes", "*.xcscheme"))
+	if err != nil {
+		return nil
+	}
+	var schemes []string
+	for _, match := range matches {
+		schemes = append(schemes, strings.TrimSuffix(filepath.Base(match), ".xcscheme"))
+	}
+	return schemes
+}
+
+var androidApplicationPlugin = regexp.MustCompile(`com\.android\.application|android\.application`)
+
+func detectGradle(root, dir string) (ProjectRecommendation, bool) {
+	var settingsPth string
+	for _, name := range []string{"settings.gradle", "settings.gradle.kts"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			settingsPth = filepath.Join(dir, name)
+			break
+		}
+	}
+	if settingsPth == "" {
+		return ProjectRecommendation{}, false
+	}
+
+	var modules []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ProjectRecommendation{}, false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		for _, name := range []string{"build.gradle", "build.gradle.kts"} {
+			content, err := os.ReadFile(filepath.Join(dir, entry.Name(), name))
+			if err == nil && androidApplicationPlugin.Match(content) {
+				modules = append(modules, entry.Name())
+				break
+			}
+		}
+	}
+	if len(modules) == 0 {
+		// A Gradle build without an Android application module, e.g. a JVM library.
+		return ProjectRecommendation{}, false
+	}
+
+	location := relToRoot(root, dir)
+	module := modules[0]
+	for _, m := range modules {
+		if m == "app" {
+			module = m
+		}
+	}
+
+	recommendation := newRecommendation("android", location, map[string]string{"project_location": location, "module": module})
+	inputs := map[string]string{"project_location": location, "module": module, "variant": "debug"}
+	recommendation.StepInputs = map[string]map[string]string{"android-unit-test": inputs, "android-build": inputs}
+	if len(modules) > 1 {
+		recommendation.Alternatives = map[string][]string{"module": modules}
+	}
+	recommendation.Evidence = append(recommendation.Evidence, relToRoot(root, settingsPth))
+	return recommendation, true
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/templates"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDetectionFixture(t *testing.T, root string, files map[string]string) {
+	for pth, content := range files {
+		full := filepath.Join(root, pth)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0644))
+	}
+}
+
+func TestDetectProjects(t *testing.T) {
+	t.Log("flutter project owns its native subprojects")
+	{
+		root := t.TempDir()
+		writeDetectionFixture(t, root, map[string]string{
+			"pubspec.yaml": "name: app\ndependencies:\n  flutter:\n    sdk: flutter\n",
+			"ios/Runner.xcodeproj/xcshareddata/xcschemes/Runner.xcscheme": "",
+			"android/settings.gradle":                                     "",
+			"android/app/build.gradle":                                    "apply plugin: 'com.android.application'",
+		})
+
+		recommendations, err := DetectProjects(root)
+		require.NoError(t, err)
+		require.Len(t, recommendations, 1)
+		require.Equal(t, "flutter", recommendations[0].ProjectType)
+		require.Equal(t, map[string]string{"project_location": ".", "platform": "both"}, recommendations[0].Parameters)
+		require.Equal(t, []string{"primary", "build"}, recommendations[0].Workflows)
+	}
+
+	t.Log("native ios and android projects in a monorepo")
+	{
+		root := t.TempDir()
+		writeDetectionFixture(t, root, map[string]string{
+			"ios/App.xcworkspace/contents.xcworkspacedata":                   "",
+			"ios/App.xcodeproj/xcshareddata/xcschemes/App.xcscheme":          "",
+			"ios/App.xcodeproj/xcshareddata/xcschemes/App-Staging.xcscheme":  "",
+			"ios/App.xcodeproj/project.xcworkspace/contents.xcworkspacedata": "",
+			"android/settings.gradle.kts":                                    "",
+			"android/mobile/build.gradle.kts":                                `plugins { id("com.android.application") }`,
+			"android/lib/build.gradle.kts":                                   `plugins { id("com.android.library") }`,
+			"package.json":                                                   `{"devDependencies": {"prettier": "3.0.0"}}`,
+		})
+
+		recommendations, err := DetectProjects(root)
+		require.NoError(t, err)
+		require.Len(t, recommendations, 3)
+
+		require.Equal(t, "android", recommendations[0].ProjectType)
+		require.Equal(t, map[string]string{"project_location": "android", "module": "mobile"}, recommendations[0].Parameters)
+		require.Equal(t, []string{"android/settings.gradle.kts"}, recommendations[0].Evidence)
+
+		require.Equal(t, "ios", recommendations[1].ProjectType)
+		require.Equal(t, map[string]string{"project_path": "ios/App.xcworkspace", "scheme": "App"}, recommendations[1].Parameters)
+		require.Equal(t, []string{"App", "App-Staging"}, recommendations[1].Alternatives["scheme"])
+		require.Equal(t, "App", recommendations[1].StepInputs["xcode-archive"]["scheme"])
+
+		require.Equal(t, "other", recommendations[2].ProjectType)
+		require.Equal(t, "generic", recommendations[2].TemplateID)
+		require.Equal(t, map[string]string{"test_command": "npm install"}, recommendations[2].Parameters)
+	}
+
+	t.Log("react native project")
+	{
+		root := t.TempDir()
+		writeDetectionFixture(t, root, map[string]string{
+			"app/package.json":                      `{"scripts": {"test": "jest"}, "dependencies": {"react-native": "0.74.0"}}`,
+			"app/yarn.lock":                         "",
+			"app/ios/App.xcodeproj/project.pbxproj": "",
+			"app/android/settings.gradle":           "",
+			"app/android/app/build.gradle":          "apply plugin: 'com.android.application'",
+		})
+
+		recommendations, err := DetectProjects(root)
+		require.NoError(t, err)
+		require.Len(t, recommendations, 1)
+		require.Equal(t, "react-native", recommendations[0].ProjectType)
+		require.Equal(t, "cd app\nyarn install\nyarn test", recommendations[0].Parameters["test_command"])
+
+		content, err := templates.Render(recommendations[0].TemplateID, recommendations[0].Parameters)
+		require.NoError(t, err)
+		require.Contains(t, content, "\nproject_type: react-native\n")
+	}
+
+	t.Log("nothing detected")
+	{
+		recommendations, err := DetectProjects(t.TempDir())
+		require.NoError(t, err)
+		require.Len(t, recommendations, 1)
+		require.Equal(t, "other", recommendations[0].ProjectType)
+		require.Equal(t, "generic", recommendations[0].TemplateID)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/gorilla/mux"
+)
+
+// ProjectRegistry holds the projects (bitrise configs) one editor instance serves, in registration
+// order. The first one is the default project.
+type ProjectRegistry struct {
+	mu       sync.Mutex
+	projects []config.Project
+}
+
+// Projects is the project registry of the running server.
+var Projects = &ProjectRegistry{}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// projectID derives a readable id from the config's directory relative to the working directory,
+// e.g. `apps/ios/bitrise.yml` becomes `apps-ios`, and a config in the working directory `root`.
+func projectID(bitriseYMLPath string) string {
+	dir := filepath.Dir(bitriseYMLPath)
+	if cwd, err := os.Getwd(); err == nil {
+		if abs, err := filepath.Abs(dir); err == nil {
+			if rel, err := filepath.Rel(cwd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				dir = rel
+			}
+		}
+	}
+
+	id := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(filepath.ToSlash(dir)), "-"), "-")
+	if id == "" {
+		return "root"
+	}
+	return id
+}
+
+// Register adds a project for the given config and secrets paths and returns it. Registering the
+// same config twice returns the existing project.
+func (r *ProjectRegistry) Register(bitriseYMLPath, secretsYMLPath string) config.Project {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	abs, err := filepath.Abs(bitriseYMLPath)
+	if err != nil {
+		abs = bitriseYMLPath
+	}
+	for _, project := range r.projects {
+		if existing, err := filepath.Abs(project.BitriseYMLPath); err == nil && existing == abs {
+			return project
+		}
+	}
+
+	base := projectID(bitriseYMLPath)
+	id := base
+	for i := 2; r.indexLocked(id) >= 0; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	project := config.Project{ID: id, BitriseYMLPath: bitriseYMLPath, SecretsYMLPath: secretsYMLPath}
+	r.projects = append(r.projects, project)
+	return project
+}
+
+func (r *ProjectRegistry) indexLocked(id string) int {
+	for i, project := range r.projects {
+		if project.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the project with the given id.
+func (r *ProjectRegistry) Get(id string) (config.Project, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.indexLocked(id); i >= 0 {
+		return r.projects[i], true
+	}
+	return config.Project{}, false
+}
+
+// List returns the registered projects, default project first.
+func (r *ProjectRegistry) List() []config.Project {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]config.Project{}, r.projects...)
+}
+
+// skippedDiscoveryDirs are never searched for configs: dependencies and build output can contain
+// third-party bitrise.yml files.
+var skippedDiscoveryDirs = map[string]bool{"node_modules": true, "vendor": true, "Pods": true, "build": true}
+
+// DiscoverProjects finds the bitrise configs (bitrise.yml / bitrise.yaml) under root, skipping hidden
+// and dependency directories.
+func DiscoverProjects(root string) ([]string, error) {
+	var configs []string
+	err := filepath.WalkDir(root, func(pth string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			name := entry.Name()
+			if pth != root && (strings.HasPrefix(name, ".") || skippedDiscoveryDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name := entry.Name(); name == "bitrise.yml" || name == "bitrise.yaml" {
+			configs = append(configs, pth)
+		}
+		return nil
+	})
+	return configs, err
+}
+
+type projectContextKey struct{}
+
+// projectFor returns the project a request is scoped to; unscoped /api routes serve the default
+// project.
+func projectFor(r *http.Request) config.Project {
+	if project, ok := r.Context().Value(projectContextKey{}).(config.Project); ok {
+		return project
+	}
+	return config.Project{BitriseYMLPath: config.BitriseYMLPath, SecretsYMLPath: config.SecretsYMLPath}
+}
+
+// WithProject scopes the request to a project.
+func WithProject(r *http.Request, project config.Project) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), projectContextKey{}, project))
+}
+
+// ProjectScopeMiddleware resolves the `{project_id}` route variable into the request's project
+// context, responding 404 for unknown projects.
+func ProjectScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["project_id"]
+		project, ok := Projects.Get(id)
+		if !ok {
+			RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("Unknown project: %s", id))
+			return
+		}
+		next.ServeHTTP(w, WithProject(r, project))
+	})
+}
+
+// GetProjectsHandler lists the projects served by this editor instance.
+func GetProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	type ResponseModel struct {
+		Projects []config.Project `json:"projects"`
+	}
+	RespondWithJSON(w, http.StatusOK, ResponseModel{Projects: Projects.List()})
+}
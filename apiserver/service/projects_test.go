@@ -0,0 +1,91 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectRegistry(t *testing.T) {
+	dir := t.TempDir()
+	withWorkdir(t, dir)
+
+	registry := &ProjectRegistry{}
+	root := registry.Register("bitrise.yml", ".bitrise.secrets.yml")
+	ios := registry.Register(filepath.Join("apps", "iOS", "bitrise.yml"), filepath.Join("apps", "iOS", ".bitrise.secrets.yml"))
+	iosDuplicate := registry.Register(filepath.Join(dir, "apps", "iOS", "bitrise.yml"), "")
+	other := registry.Register(filepath.Join("apps", "ios", "bitrise.yaml"), "")
+
+	require.Equal(t, "root", root.ID)
+	require.Equal(t, "apps-ios", ios.ID)
+	require.Equal(t, ios, iosDuplicate)
+	require.Equal(t, "apps-ios-2", other.ID)
+	require.Len(t, registry.List(), 3)
+
+	project, ok := registry.Get("apps-ios")
+	require.True(t, ok)
+	require.Equal(t, ios, project)
+}
+
+func TestDiscoverProjects(t *testing.T) {
+	dir := t.TempDir()
+	for _, pth := range []string{
+		"bitrise.yml",
+		"apps/android/bitrise.yml",
+		"apps/web/bitrise.yaml",
+		"node_modules/some-package/bitrise.yml",
+		".git/bitrise.yml",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, pth)), 0o755))
+		require.NoError(t, fileutil.WriteStringToFile(filepath.Join(dir, pth), "format_version: \"13\"\n"))
+	}
+
+	configs, err := DiscoverProjects(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(dir, "bitrise.yml"),
+		filepath.Join(dir, "apps", "android", "bitrise.yml"),
+		filepath.Join(dir, "apps", "web", "bitrise.yaml"),
+	}, configs)
+}
+
+func TestProjectScopedRoutes(t *testing.T) {
+	dir := t.TempDir()
+	defaultConfig := filepath.Join(dir, "bitrise.yml")
+	appConfig := filepath.Join(dir, "app", "bitrise.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(appConfig), 0o755))
+	require.NoError(t, fileutil.WriteStringToFile(defaultConfig, "format_version: \"13\"\ntitle: default\n"))
+	require.NoError(t, fileutil.WriteStringToFile(appConfig, "format_version: \"13\"\ntitle: app\n"))
+
+	config.BitriseYMLPath = defaultConfig
+	Projects = &ProjectRegistry{}
+	Projects.Register(defaultConfig, "")
+	app := Projects.Register(appConfig, "")
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/bitrise-yml", GetBitriseYMLHandler)
+	r.Handle("/api/projects/{project_id}/bitrise-yml", ProjectScopeMiddleware(http.HandlerFunc(GetBitriseYMLHandler)))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get("/api/bitrise-yml")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "title: default")
+
+	rr = get("/api/projects/" + app.ID + "/bitrise-yml")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "title: app")
+
+	require.Equal(t, http.StatusNotFound, get("/api/projects/unknown/bitrise-yml").Code)
+}
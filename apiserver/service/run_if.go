@@ -0,0 +1,305 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise/v2/bitrise"
+	"github.com/bitrise-io/bitrise/v2/models"
+	envmanModels "github.com/bitrise-io/envman/v2/models"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/parseutil"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// runIfContext is the build state `run_if` expressions and template inputs are evaluated with.
+type runIfContext struct {
+	IsCI          bool   `json:"is_ci"`
+	IsPR          bool   `json:"is_pr"`
+	PullRequestID string `json:"pull_request_id,omitempty"`
+	IsBuildFailed bool   `json:"is_build_failed"`
+}
+
+// buildResults stands in for the results of the steps run so far: a failed build has a failed step.
+func (ctx runIfContext) buildResults() models.BuildRunResultsModel {
+	if ctx.IsBuildFailed {
+		return models.BuildRunResultsModel{FailedSteps: []models.StepRunResultsModel{{}}}
+	}
+	return models.BuildRunResultsModel{}
+}
+
+// renderBitriseTemplate renders a bitrise template (a `run_if` expression or an `is_template`
+// input) the way the bitrise CLI does, looking env vars up in envs.
+func renderBitriseTemplate(expression string, envs *planEnvs, ctx runIfContext) (string, error) {
+	return bitrise.EvaluateTemplateToString(expression, ctx.IsCI, ctx.IsPR, ctx.buildResults(), envs.jsonList())
+}
+
+// evaluateRunIf evaluates a `run_if` expression the way the bitrise CLI does; an empty expression
+// is true.
+func evaluateRunIf(expression string, envs *planEnvs, ctx runIfContext) (bool, error) {
+	if strings.TrimSpace(expression) == "" {
+		return true, nil
+	}
+	return bitrise.EvaluateTemplateToBool(expression, ctx.IsCI, ctx.IsPR, ctx.buildResults(), envs.jsonList())
+}
+
+// parseTemplateBool parses a rendered `run_if` expression with the bitrise CLI's bool parsing.
+func parseTemplateBool(s string) (bool, error) {
+	value, err := parseutil.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("expression evaluated to %q, not a bool", strings.TrimSpace(s))
+	}
+	return value, nil
+}
+
+// RunIfIssue is a problem of a `run_if` expression, located in the config.
+type RunIfIssue struct {
+	// File is the config module of the expression, relative to the repository root; empty for a
+	// posted config.
+	File string `json:"file,omitempty"`
+	// Path is the expression's location in the config, e.g. `workflows.test.steps[1].script@1.run_if`.
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Expression string `json:"expression"`
+	// Severity is error for expressions failing whatever the envs are, warning otherwise.
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// RunIfLintResult is the result of linting every `run_if` of a config.
+type RunIfLintResult struct {
+	Expressions int          `json:"expressions"`
+	Issues      []RunIfIssue `json:"issues"`
+	// Warnings list the includes that couldn't be resolved, so their expressions weren't linted.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// LintRunIfs parses every `run_if` of the config (of steps, stage and pipeline workflows) and
+// dry-runs them with the default build state and no envs.
+func LintRunIfs(contStr string) (RunIfLintResult, error) {
+	result := RunIfLintResult{Issues: []RunIfIssue{}}
+	return result, result.lint(wireTreeNode{Contents: contStr})
+}
+
+// lintConfigTreeRunIfs lints the expressions of every resolved module of a config tree, the issues
+// naming their module.
+func lintConfigTreeRunIfs(root wireTreeNode) (RunIfLintResult, error) {
+	result := RunIfLintResult{Issues: []RunIfIssue{}}
+	var walk func(node wireTreeNode) error
+	walk = func(node wireTreeNode) error {
+		if err := result.lint(node); err != nil {
+			return err
+		}
+		for _, child := range node.Includes {
+			if child.Error == "" {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return RunIfLintResult{}, err
+	}
+	result.Warnings = unresolvedIncludes(root)
+	return result, nil
+}
+
+func (result *RunIfLintResult) lint(module wireTreeNode) error {
+	var root yaml3.Node
+	if err := yaml3.Unmarshal([]byte(module.Contents), &root); err != nil {
+		if module.Path == "" {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		return fmt.Errorf("invalid config (%s): %w", module.Path, err)
+	}
+
+	walkRunIfs(&root, "", func(pth string, node *yaml3.Node) {
+		result.Expressions++
+		if issue := lintRunIf(node.Value); issue != nil {
+			issue.File, issue.Path, issue.Line, issue.Column, issue.Expression = module.Path, pth, node.Line, node.Column, node.Value
+			result.Issues = append(result.Issues, *issue)
+		}
+	})
+	return nil
+}
+
+// walkRunIfs calls fn with the value node of every `run_if` under node; graph pipelines nest the
+// expression as `run_if.expression`.
+func walkRunIfs(node *yaml3.Node, pth string, fn func(pth string, node *yaml3.Node)) {
+	switch node.Kind {
+	case yaml3.DocumentNode:
+		for _, child := range node.Content {
+			walkRunIfs(child, pth, fn)
+		}
+	case yaml3.SequenceNode:
+		for i, child := range node.Content {
+			walkRunIfs(child, fmt.Sprintf("%s[%d]", pth, i), fn)
+		}
+	case yaml3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			childPth := key.Value
+			if pth != "" {
+				childPth = pth + "." + key.Value
+			}
+
+			if key.Value != "run_if" {
+				walkRunIfs(value, childPth, fn)
+				continue
+			}
+			switch value.Kind {
+			case yaml3.ScalarNode:
+				fn(childPth, value)
+			case yaml3.MappingNode:
+				for j := 0; j+1 < len(value.Content); j += 2 {
+					if value.Content[j].Value == "expression" && value.Content[j+1].Kind == yaml3.ScalarNode {
+						fn(childPth+".expression", value.Content[j+1])
+					}
+				}
+			}
+		}
+	}
+}
+
+func lintRunIf(expression string) *RunIfIssue {
+	if strings.TrimSpace(expression) == "" {
+		return nil
+	}
+
+	// With no envs set only errors independent of the envs (unknown fields, wrong argument types)
+	// fail the execution.
+	out, err := renderBitriseTemplate(expression, &planEnvs{}, runIfContext{})
+	if err != nil {
+		return &RunIfIssue{Severity: "error", Message: templateErrorMessage(err)}
+	}
+	if _, err := parseTemplateBool(out); err != nil {
+		return &RunIfIssue{Severity: "warning", Message: fmt.Sprintf("with no envs set, %s", err)}
+	}
+	return nil
+}
+
+// templateErrorMessage drops the template name from a template error, keeping the position
+// within the expression.
+func templateErrorMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "template: EvaluateTemplateToBool:")
+}
+
+type lintRunIfsRequestModel struct {
+	// BitriseYML is the editor's (possibly unsaved) config, linted on its own as lines refer to it.
+	// If empty, the saved config is linted with every module it includes.
+	BitriseYML string `json:"bitrise_yml"`
+}
+
+// PostLintRunIfsHandler reports the syntax errors of the config's `run_if` expressions.
+func PostLintRunIfsHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj lintRunIfsRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+
+	var result RunIfLintResult
+	var err error
+	if reqObj.BitriseYML != "" {
+		result, err = LintRunIfs(reqObj.BitriseYML)
+	} else {
+		contStr, readErr := fileutil.ReadStringFromFile(project.BitriseYMLPath)
+		if readErr != nil {
+			log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, readErr)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", readErr)
+			return
+		}
+		resolver := mirrorTreeResolver{repoRoot: filepath.Dir(project.BitriseYMLPath), mirrorDir: config.IncludeMirrorDir}
+		result, err = lintConfigTreeRunIfs(resolver.resolve(filepath.Base(project.BitriseYMLPath), contStr))
+	}
+	if err != nil {
+		log.Errorf("Failed to lint run_if expressions, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to lint run_if expressions, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, result)
+}
+
+type evaluateRunIfRequestModel struct {
+	Expression string `json:"expression"`
+	// Envs are the env vars available to the expression, in order.
+	Envs []envmanModels.EnvironmentItemModel `json:"envs"`
+	runIfContext
+}
+
+type evaluateRunIfResponseModel struct {
+	Result bool `json:"result"`
+	// Output is what the expression rendered to, before parsing it as a bool.
+	Output string `json:"output"`
+}
+
+// PostEvaluateRunIfHandler evaluates a `run_if` expression with the given envs and build state.
+func PostEvaluateRunIfHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj evaluateRunIfRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+
+	envs := &planEnvs{}
+	b := planBuilder{sensitive: map[string]bool{}}
+	if err := b.addEnvs(envs, reqObj.Envs); err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Invalid envs, error: %s", err)
+		return
+	}
+
+	if strings.TrimSpace(reqObj.Expression) == "" {
+		RespondWithJSON(w, http.StatusOK, evaluateRunIfResponseModel{Result: true})
+		return
+	}
+
+	out, err := renderBitriseTemplate(reqObj.Expression, envs, reqObj.runIfContext)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to evaluate run_if: %s", templateErrorMessage(err))
+		return
+	}
+	result, err := parseTemplateBool(out)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to evaluate run_if: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, evaluateRunIfResponseModel{Result: result, Output: out})
+}
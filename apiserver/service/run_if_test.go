@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/stretchr/testify/require"
+)
+
+const runIfTestConfig = `format_version: "13"
+workflows:
+  test:
+    steps:
+    - script@1:
+        run_if: .IsCI
+    - script@1:
+        run_if: '{{enveq "BRANCH" "main" | and .IsPR}}'
+    - script@1:
+        run_if: '{{ getenvv "BRANCH" }}'
+    - script@1:
+        run_if: .IsNightly
+    - script@1:
+        run_if: '{{getenv "DEPLOY"}}'
+pipelines:
+  graph:
+    workflows:
+      test:
+        run_if:
+          expression: '{{ .IsBuildOK'
+`
+
+func TestLintRunIfs(t *testing.T) {
+	result, err := LintRunIfs(runIfTestConfig)
+	require.NoError(t, err)
+	require.Equal(t, 6, result.Expressions)
+	require.Equal(t, []RunIfIssue{
+		{
+			Path:       "workflows.test.steps[2].script@1.run_if",
+			Line:       10,
+			Column:     17,
+			Expression: `{{ getenvv "BRANCH" }}`,
+			Severity:   "error",
+			Message:    `1: function "getenvv" not defined`,
+		},
+		{
+			Path:       "workflows.test.steps[3].script@1.run_if",
+			Line:       12,
+			Column:     17,
+			Expression: ".IsNightly",
+			Severity:   "error",
+			Message:    `1:2: executing "EvaluateTemplateToBool" at <.IsNightly>: can't evaluate field IsNightly in type bitrise.TemplateDataModel`,
+		},
+		{
+			Path:       "workflows.test.steps[4].script@1.run_if",
+			Line:       14,
+			Column:     17,
+			Expression: `{{getenv "DEPLOY"}}`,
+			Severity:   "warning",
+			Message:    `with no envs set, expression evaluated to "", not a bool`,
+		},
+		{
+			Path:       "pipelines.graph.workflows.test.run_if.expression",
+			Line:       20,
+			Column:     23,
+			Expression: "{{ .IsBuildOK",
+			Severity:   "error",
+			Message:    "1: unclosed action",
+		},
+	}, result.Issues)
+}
+
+func TestPostLintRunIfsHandler_configTree(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bitrise.yml"), []byte(`format_version: "13"
+include:
+- path: modules/test.yml
+- path: shared/deploy.yml
+  repository: https://github.com/org/shared.git
+  branch: main
+workflows:
+  primary:
+    steps:
+    - script@1:
+        run_if: .IsCI
+`), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "modules"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "modules", "test.yml"), []byte(`workflows:
+  test:
+    steps:
+    - script@1:
+        run_if: .IsNightly
+`), 0644))
+	config.BitriseYMLPath = filepath.Join(dir, "bitrise.yml")
+	config.IncludeMirrorDir = ""
+	config.IncludeCacheDir = ""
+
+	req, err := http.NewRequest("POST", "/api/run-if/lint", bytes.NewBufferString(`{}`))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(PostLintRunIfsHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var result RunIfLintResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Equal(t, 2, result.Expressions)
+	require.Len(t, result.Issues, 1)
+	require.Equal(t, "modules/test.yml", result.Issues[0].File)
+	require.Equal(t, "workflows.test.steps[0].script@1.run_if", result.Issues[0].Path)
+	require.Equal(t, 5, result.Issues[0].Line)
+	require.Len(t, result.Warnings, 1)
+	require.Contains(t, result.Warnings[0], "shared/deploy.yml: include not resolved: ")
+}
+
+func TestPostEvaluateRunIfHandler(t *testing.T) {
+	evaluate := func(body string) (int, string) {
+		req, err := http.NewRequest("POST", "/api/run-if/evaluate", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostEvaluateRunIfHandler).ServeHTTP(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	t.Log("envs and build state")
+	{
+		code, body := evaluate(`{"expression": "{{enveq \"BRANCH\" \"main\" | and .IsPR}}", "envs": [{"BRANCH": "$BASE"}, {"BASE": "x"}, {"BASE": "main"}, {"BRANCH": "$BASE"}], "is_pr": true}`)
+		require.Equal(t, http.StatusOK, code)
+
+		var resp evaluateRunIfResponseModel
+		require.NoError(t, json.Unmarshal([]byte(body), &resp))
+		require.Equal(t, evaluateRunIfResponseModel{Result: true, Output: "true"}, resp)
+	}
+
+	t.Log("failed build")
+	{
+		code, body := evaluate(`{"expression": ".IsBuildOK", "is_build_failed": true}`)
+		require.Equal(t, http.StatusOK, code)
+		require.JSONEq(t, `{"result": false, "output": "false"}`, body)
+	}
+
+	t.Log("bools parsed like the CLI does")
+	{
+		code, body := evaluate(`{"expression": "{{getenv \"DEPLOY\"}}", "envs": [{"DEPLOY": "TRUE"}]}`)
+		require.Equal(t, http.StatusOK, code, body)
+		require.JSONEq(t, `{"result": true, "output": "TRUE"}`, body)
+
+		code, body = evaluate(`{"expression": "{{getenv \"DEPLOY\"}}", "envs": [{"DEPLOY": "f"}]}`)
+		require.Equal(t, http.StatusOK, code, body)
+		require.JSONEq(t, `{"result": false, "output": "f"}`, body)
+	}
+
+	t.Log("invalid expression")
+	{
+		code, body := evaluate(`{"expression": "{{ .IsCI"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Contains(t, body, "Failed to evaluate run_if: 1: unclosed action")
+	}
+}
@@ -0,0 +1,425 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunStatus is the state of a local workflow run.
+type RunStatus string
+
+// Run statuses.
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusFailed    RunStatus = "failed"
+	RunStatusCanceled  RunStatus = "canceled"
+)
+
+// Run event types.
+const (
+	RunEventLog          = "log"
+	RunEventStepStarted  = "step_started"
+	RunEventStepFinished = "step_finished"
+	RunEventFinished     = "finished"
+)
+
+const (
+	// maxRunHistory is how many finished runs are kept; the oldest are dropped first.
+	maxRunHistory = 50
+	// maxRunEvents bounds the events kept per run for replay; the oldest are dropped first.
+	maxRunEvents = 50000
+	// runCancelGracePeriod is how long a canceled run may take to stop before it's killed.
+	runCancelGracePeriod = 10 * time.Second
+	// runOutputWaitDelay is how long the output is read after the run exited.
+	runOutputWaitDelay = 5 * time.Second
+)
+
+var (
+	// ErrRunNotFound is returned for unknown (or dropped) run ids.
+	ErrRunNotFound = errors.New("run not found")
+	// ErrRunFinished is returned when canceling a run that already finished.
+	ErrRunFinished = errors.New("run already finished")
+)
+
+// RunStep is a step of a run, parsed from the bitrise CLI's step header and result lines.
+type RunStep struct {
+	Index      int        `json:"index"`
+	Title      string     `json:"title"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// RunInfo describes a local workflow run.
+type RunInfo struct {
+	ID         string     `json:"id"`
+	ProjectID  string     `json:"project_id,omitempty"`
+	Workflow   string     `json:"workflow"`
+	Status     RunStatus  `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Unsaved reports that the run used the editor's unsaved config or secrets.
+	Unsaved bool      `json:"unsaved"`
+	Steps   []RunStep `json:"steps"`
+}
+
+// RunEvent is a log line or a state change of a run. Seq increases by one per event of a run, so
+// a client can resume a stream after the last event it has seen.
+type RunEvent struct {
+	Seq    int       `json:"seq"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream,omitempty"`
+	Line   string    `json:"line,omitempty"`
+	Step   *RunStep  `json:"step,omitempty"`
+	Run    *RunInfo  `json:"run,omitempty"`
+}
+
+// RunSpec is what a run executes.
+type RunSpec struct {
+	ProjectID string
+	Workflow  string
+	// Dir is the working directory, the project's repository root.
+	Dir           string
+	ConfigPath    string
+	InventoryPath string
+	Unsaved       bool
+	// Cleanup is called once the run has finished, e.g. to remove temporary config files.
+	Cleanup func()
+}
+
+type run struct {
+	info        RunInfo
+	events      []RunEvent
+	nextSeq     int
+	subscribers map[chan struct{}]bool
+	cmd         *exec.Cmd
+	canceled    bool
+	done        chan struct{}
+}
+
+// RunManager starts bitrise CLI runs and keeps their logs and history in memory.
+type RunManager struct {
+	mu    sync.Mutex
+	runs  map[string]*run
+	order []string
+	now   func() time.Time
+}
+
+// Runs is the run manager of the running server.
+var Runs = NewRunManager()
+
+// NewRunManager returns an empty run manager.
+func NewRunManager() *RunManager {
+	return &RunManager{runs: map[string]*run{}, now: time.Now}
+}
+
+// Start starts the command of a run and returns the run's info. The command's output is turned
+// into events as it's produced.
+func (m *RunManager) Start(spec RunSpec, cmd *exec.Cmd) (RunInfo, error) {
+	// The output is copied through io.Pipes, so Wait returns (after runOutputWaitDelay at most) even
+	// if a process started by a step keeps the output open after bitrise exited.
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	cmd.WaitDelay = runOutputWaitDelay
+	// The run gets its own process group, so canceling it reaches the processes steps start too.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := &run{
+		info: RunInfo{
+			ID:        newSessionID(),
+			ProjectID: spec.ProjectID,
+			Workflow:  spec.Workflow,
+			Status:    RunStatusRunning,
+			StartedAt: m.now(),
+			Unsaved:   spec.Unsaved,
+			Steps:     []RunStep{},
+		},
+		subscribers: map[chan struct{}]bool{},
+		cmd:         cmd,
+		done:        make(chan struct{}),
+	}
+	if err := cmd.Start(); err != nil {
+		_ = stdoutWriter.Close()
+		_ = stderrWriter.Close()
+		return RunInfo{}, fmt.Errorf("failed to start bitrise: %w", err)
+	}
+
+	m.runs[r.info.ID] = r
+	m.order = append(m.order, r.info.ID)
+	m.pruneLocked()
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go m.scan(r, "stdout", stdout, &output)
+	go m.scan(r, "stderr", stderr, &output)
+	go func() {
+		err := cmd.Wait()
+		_ = stdoutWriter.Close()
+		_ = stderrWriter.Close()
+		output.Wait()
+		if spec.Cleanup != nil {
+			spec.Cleanup()
+		}
+		m.finish(r, err)
+	}()
+
+	return r.snapshot(), nil
+}
+
+// snapshot returns a copy of the run's info that's safe to use without holding the lock.
+func (r *run) snapshot() RunInfo {
+	info := r.info
+	info.Steps = append([]RunStep{}, r.info.Steps...)
+	return info
+}
+
+func (m *RunManager) scan(r *run, stream string, reader io.Reader, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m.appendLog(r, stream, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		m.appendLog(r, "stderr", fmt.Sprintf("Failed to read run output: %s", err))
+		// Drain the pipe so the run doesn't block writing its output.
+		_, _ = io.Copy(io.Discard, reader)
+	}
+}
+
+var (
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	// stepHeader matches the box the bitrise CLI opens a step with, e.g. `| (1) git-clone@8 |`.
+	stepHeader = regexp.MustCompile(`^\|\s*\((\d+)\)\s+(.+?)\s*\|$`)
+	// stepResult matches the result row of a step, e.g. `| ✓ | git-clone@8 | 2.71 sec |`.
+	stepResult = regexp.MustCompile(`^\|\s*(✓|x|✗|!|-|➜)\s*\|`)
+)
+
+func stepStatus(marker string) string {
+	switch marker {
+	case "✓":
+		return "succeeded"
+	case "x", "✗":
+		return "failed"
+	case "!":
+		return "failed_skippable"
+	default:
+		return "skipped"
+	}
+}
+
+func (m *RunManager) appendLog(r *run, stream, line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.appendEventLocked(r, RunEvent{Type: RunEventLog, Time: now, Stream: stream, Line: line})
+
+	plain := strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+	if match := stepHeader.FindStringSubmatch(plain); match != nil {
+		var index int
+		_, _ = fmt.Sscanf(match[1], "%d", &index)
+		step := RunStep{Index: index, Title: match[2], Status: "running", StartedAt: now}
+		r.info.Steps = append(r.info.Steps, step)
+		m.appendEventLocked(r, RunEvent{Type: RunEventStepStarted, Time: now, Step: &step})
+	} else if match := stepResult.FindStringSubmatch(plain); match != nil {
+		// The build summary at the end repeats every result row; only a running step is finished.
+		if last := len(r.info.Steps) - 1; last >= 0 && r.info.Steps[last].Status == "running" {
+			r.info.Steps[last].Status = stepStatus(match[1])
+			r.info.Steps[last].FinishedAt = &now
+			step := r.info.Steps[last]
+			m.appendEventLocked(r, RunEvent{Type: RunEventStepFinished, Time: now, Step: &step})
+		}
+	}
+}
+
+func (m *RunManager) appendEventLocked(r *run, event RunEvent) {
+	event.Seq = r.nextSeq
+	r.nextSeq++
+	r.events = append(r.events, event)
+	if len(r.events) > maxRunEvents {
+		r.events = append([]RunEvent{}, r.events[len(r.events)-maxRunEvents:]...)
+	}
+
+	for notify := range r.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *RunManager) finish(r *run, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	r.info.FinishedAt = &now
+	exitCode := 0
+	if r.cmd.ProcessState != nil {
+		exitCode = r.cmd.ProcessState.ExitCode()
+	}
+	r.info.ExitCode = &exitCode
+
+	var exitErr *exec.ExitError
+	switch {
+	case r.canceled:
+		r.info.Status = RunStatusCanceled
+	case err == nil:
+		r.info.Status = RunStatusSucceeded
+	default:
+		r.info.Status = RunStatusFailed
+		if !errors.As(err, &exitErr) {
+			r.info.Error = err.Error()
+		}
+	}
+
+	for i := range r.info.Steps {
+		if r.info.Steps[i].Status == "running" {
+			r.info.Steps[i].Status = string(r.info.Status)
+			r.info.Steps[i].FinishedAt = &now
+		}
+	}
+
+	info := r.snapshot()
+	m.appendEventLocked(r, RunEvent{Type: RunEventFinished, Time: now, Run: &info})
+	close(r.done)
+}
+
+// pruneLocked drops the oldest finished runs beyond maxRunHistory.
+func (m *RunManager) pruneLocked() {
+	for len(m.order) > maxRunHistory {
+		dropped := false
+		for i, id := range m.order {
+			if m.runs[id].info.Status != RunStatusRunning {
+				delete(m.runs, id)
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return
+		}
+	}
+}
+
+// Get returns the run with the given id.
+func (m *RunManager) Get(id string) (RunInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.runs[id]
+	if !ok {
+		return RunInfo{}, false
+	}
+	return r.snapshot(), true
+}
+
+// List returns the runs in the history, newest first.
+func (m *RunManager) List() []RunInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runs := make([]RunInfo, 0, len(m.order))
+	for _, id := range m.order {
+		runs = append(runs, m.runs[id].snapshot())
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	return runs
+}
+
+// Events returns the kept events of a run with a seq greater than after, and whether the run has
+// finished (so no more events will follow).
+func (m *RunManager) Events(id string, after int) ([]RunEvent, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.runs[id]
+	if !ok {
+		return nil, false, ErrRunNotFound
+	}
+
+	idx := sort.Search(len(r.events), func(i int) bool { return r.events[i].Seq > after })
+	events := append([]RunEvent{}, r.events[idx:]...)
+	return events, r.info.Status != RunStatusRunning, nil
+}
+
+// Subscribe returns a channel signaled when a run has new events, and a function to unsubscribe.
+func (m *RunManager) Subscribe(id string) (<-chan struct{}, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.runs[id]
+	if !ok {
+		return nil, nil, ErrRunNotFound
+	}
+
+	notify := make(chan struct{}, 1)
+	r.subscribers[notify] = true
+	unsubscribe := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(r.subscribers, notify)
+	}
+	return notify, unsubscribe, nil
+}
+
+// Cancel interrupts a run's process group, and kills what's left of it once bitrise exited or
+// after runCancelGracePeriod, so processes started by steps don't outlive the run.
+func (m *RunManager) Cancel(id string) error {
+	m.mu.Lock()
+	r, ok := m.runs[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrRunNotFound
+	}
+	if r.info.Status != RunStatusRunning {
+		m.mu.Unlock()
+		return ErrRunFinished
+	}
+	r.canceled = true
+	m.mu.Unlock()
+
+	pgid := r.cmd.Process.Pid
+	if err := syscall.Kill(-pgid, syscall.SIGINT); err != nil {
+		return syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+
+	go func() {
+		select {
+		case <-r.done:
+		case <-time.After(runCancelGracePeriod):
+		}
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	}()
+	return nil
+}
+
+// Shutdown cancels the running runs, ending their event streams.
+func (m *RunManager) Shutdown() {
+	for _, info := range m.List() {
+		if info.Status == RunStatusRunning {
+			_ = m.Cancel(info.ID)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForRun(t *testing.T, m *RunManager, id string) RunInfo {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		info, ok := m.Get(id)
+		require.True(t, ok)
+		if info.Status != RunStatusRunning {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("run %s didn't finish", id)
+	return RunInfo{}
+}
+
+func TestRunManager(t *testing.T) {
+	t.Log("streams output with step boundaries")
+	{
+		m := NewRunManager()
+		cleanedUp := false
+		script := `
+echo "+------------------------------------------------------------------------------+"
+echo "| (0) git-clone@8                                                              |"
+echo "+------------------------------------------------------------------------------+"
+echo "cloning" >&2
+echo "|  ✓  | git-clone@8                                                 | 2.71 sec |"
+echo "| (1) script@1                                                                 |"
+echo "|  x  | script@1                                                    | 0.10 sec |"
+echo "|  ✓  | git-clone@8                                                 | 2.71 sec |"
+exit 1
+`
+		info, err := m.Start(RunSpec{Workflow: "primary", Cleanup: func() { cleanedUp = true }}, exec.Command("sh", "-c", script))
+		require.NoError(t, err)
+		require.Equal(t, RunStatusRunning, info.Status)
+
+		info = waitForRun(t, m, info.ID)
+		require.Equal(t, RunStatusFailed, info.Status)
+		require.Equal(t, 1, *info.ExitCode)
+		require.True(t, cleanedUp)
+		require.Len(t, info.Steps, 2)
+		require.Equal(t, "git-clone@8", info.Steps[0].Title)
+		require.Equal(t, "succeeded", info.Steps[0].Status)
+		require.Equal(t, 1, info.Steps[1].Index)
+		require.Equal(t, "failed", info.Steps[1].Status)
+
+		events, finished, err := m.Events(info.ID, -1)
+		require.NoError(t, err)
+		require.True(t, finished)
+
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type)
+			if event.Stream == "stderr" {
+				require.Equal(t, "cloning", event.Line)
+			}
+		}
+		require.Equal(t, []string{
+			RunEventLog, RunEventLog, RunEventStepStarted, RunEventLog, RunEventLog, RunEventStepFinished,
+			RunEventLog, RunEventStepStarted, RunEventLog, RunEventStepFinished, RunEventLog, RunEventFinished,
+		}, filterStderr(events, types))
+
+		resumed, _, err := m.Events(info.ID, events[len(events)-2].Seq)
+		require.NoError(t, err)
+		require.Len(t, resumed, 1)
+		require.Equal(t, RunEventFinished, resumed[0].Type)
+	}
+
+	t.Log("cancel")
+	{
+		m := NewRunManager()
+		info, err := m.Start(RunSpec{Workflow: "primary"}, exec.Command("sh", "-c", `trap 'echo interrupted; exit 130' INT; while true; do sleep 0.05; done`))
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, m.Cancel(info.ID))
+
+		info = waitForRun(t, m, info.ID)
+		require.Equal(t, RunStatusCanceled, info.Status)
+		require.Equal(t, ErrRunFinished, m.Cancel(info.ID))
+		require.Equal(t, ErrRunNotFound, m.Cancel("unknown"))
+	}
+
+	t.Log("cancel stops the processes started by the run")
+	{
+		m := NewRunManager()
+		pidFile := filepath.Join(t.TempDir(), "child.pid")
+		// The child ignores the interrupt, like a step tool that doesn't handle it.
+		script := `sh -c 'trap "" INT; while true; do sleep 0.05; done' & echo $! > ` + pidFile + `; wait`
+		info, err := m.Start(RunSpec{Workflow: "primary"}, exec.Command("sh", "-c", script))
+		require.NoError(t, err)
+
+		var pid int
+		require.Eventually(t, func() bool {
+			cont, err := os.ReadFile(pidFile)
+			if err != nil || !strings.HasSuffix(string(cont), "\n") {
+				return false
+			}
+			pid, err = strconv.Atoi(strings.TrimSpace(string(cont)))
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, m.Cancel(info.ID))
+		info = waitForRun(t, m, info.ID)
+		require.Equal(t, RunStatusCanceled, info.Status)
+		require.Eventually(t, func() bool {
+			return syscall.Kill(pid, 0) == syscall.ESRCH
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+// filterStderr drops the event type of the stderr line, whose position relative to stdout isn't
+// deterministic.
+func filterStderr(events []RunEvent, types []string) []string {
+	var filtered []string
+	for i, event := range events {
+		if event.Stream != "stderr" {
+			filtered = append(filtered, types[i])
+		}
+	}
+	return filtered
+}
+
+func TestGetRunEventsHandler(t *testing.T) {
+	previous := Runs
+	Runs = NewRunManager()
+	t.Cleanup(func() { Runs = previous })
+	info, err := Runs.Start(RunSpec{Workflow: "primary"}, exec.Command("sh", "-c", `echo first; sleep 0.1; echo second`))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/api/runs/"+info.ID+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+	req = mux.SetURLVars(req, map[string]string{"run_id": info.ID})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(GetRunEventsHandler).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	body := rr.Body.String()
+	require.NotContains(t, body, `"line":"first"`)
+	require.Contains(t, body, "id: 1\nevent: log\ndata: ")
+	require.Contains(t, body, `"line":"second"`)
+	require.True(t, strings.HasSuffix(body, "\n\n"))
+	require.Contains(t, body, "event: finished\n")
+}
+
+func TestPrepareRun(t *testing.T) {
+	dir := t.TempDir()
+	project := config.Project{ID: "root", BitriseYMLPath: filepath.Join(dir, "bitrise.yml"), SecretsYMLPath: filepath.Join(dir, ".bitrise.secrets.yml")}
+
+	t.Log("saved config without secrets")
+	{
+		spec, err := prepareRun(project, postRunRequestModel{Workflow: "primary"})
+		require.NoError(t, err)
+		defer spec.Cleanup()
+
+		require.Equal(t, dir, spec.Dir)
+		require.Equal(t, project.BitriseYMLPath, spec.ConfigPath)
+		require.Equal(t, "", spec.InventoryPath)
+		require.False(t, spec.Unsaved)
+	}
+
+	t.Log("unsaved config and secrets")
+	{
+		secrets := envmanModels.EnvsSerializeModel{Envs: []envmanModels.EnvironmentItemModel{{"API_KEY": "secret"}}}
+		spec, err := prepareRun(project, postRunRequestModel{Workflow: "primary", BitriseYML: config.MinimalValidBitriseYML, Secrets: &secrets})
+		require.NoError(t, err)
+		require.True(t, spec.Unsaved)
+
+		content, err := os.ReadFile(spec.ConfigPath)
+		require.NoError(t, err)
+		require.Equal(t, config.MinimalValidBitriseYML, string(content))
+
+		info, err := os.Stat(spec.InventoryPath)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		spec.Cleanup()
+		_, err = os.Stat(filepath.Dir(spec.ConfigPath))
+		require.True(t, os.IsNotExist(err))
+	}
+}
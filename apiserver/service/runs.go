@@ -0,0 +1,267 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/tools"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
+)
+
+type postRunRequestModel struct {
+	Workflow string `json:"workflow"`
+	// BitriseYML is the editor's (possibly unsaved) config; the saved config is run if empty.
+	BitriseYML string `json:"bitrise_yml"`
+	// Secrets are the editor's (possibly unsaved) secrets, masked values standing for the saved
+	// ones; the saved secrets are used if nil.
+	Secrets *envmanModels.EnvsSerializeModel `json:"secrets"`
+}
+
+type runResponseModel struct {
+	Run RunInfo `json:"run"`
+}
+
+type runsResponseModel struct {
+	Runs []RunInfo `json:"runs"`
+}
+
+// PostRunHandler runs a workflow of the project with the bitrise CLI. The run's output is
+// streamed by GetRunEventsHandler.
+func PostRunHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj postRunRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+	if reqObj.Workflow == "" {
+		RespondWithJSONBadRequestErrorMessage(w, "workflow is required")
+		return
+	}
+
+	spec, err := prepareRun(project, reqObj)
+	if err != nil {
+		log.Errorf("Failed to prepare run, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to prepare run, error: %s", err)
+		return
+	}
+
+	info, err := Runs.Start(spec, tools.BitriseRunCommand(spec.Dir, spec.Workflow, spec.ConfigPath, spec.InventoryPath))
+	if err != nil {
+		spec.Cleanup()
+		log.Errorf("Failed to start run, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to start run, error: %s", err)
+		return
+	}
+	log.Printf("Started run %s of workflow %s", info.ID, info.Workflow)
+
+	RespondWithJSON(w, http.StatusOK, runResponseModel{Run: info})
+}
+
+// prepareRun writes the unsaved config and secrets of a run request into a temporary directory,
+// removed by the spec's Cleanup once the run is over. The run's working directory is the project's
+// directory, so relative paths (and includes) resolve as they would for the saved config.
+func prepareRun(project config.Project, reqObj postRunRequestModel) (RunSpec, error) {
+	dir, err := filepath.Abs(filepath.Dir(project.BitriseYMLPath))
+	if err != nil {
+		return RunSpec{}, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "workflow-editor-run-")
+	if err != nil {
+		return RunSpec{}, err
+	}
+
+	spec := RunSpec{
+		ProjectID: project.ID,
+		Workflow:  reqObj.Workflow,
+		Dir:       dir,
+		Cleanup: func() {
+			if err := os.RemoveAll(tmpDir); err != nil {
+				log.Warnf("Failed to remove run directory (%s), error: %s", tmpDir, err)
+			}
+		},
+	}
+
+	if reqObj.BitriseYML != "" {
+		if _, err := utility.ValidateBitriseConfigAndSecret(reqObj.BitriseYML, config.MinimalValidSecrets); err != nil {
+			spec.Cleanup()
+			return RunSpec{}, err
+		}
+		spec.ConfigPath = filepath.Join(tmpDir, "bitrise.yml")
+		if err := os.WriteFile(spec.ConfigPath, []byte(reqObj.BitriseYML), 0600); err != nil {
+			spec.Cleanup()
+			return RunSpec{}, err
+		}
+		spec.Unsaved = true
+	} else if spec.ConfigPath, err = filepath.Abs(project.BitriseYMLPath); err != nil {
+		spec.Cleanup()
+		return RunSpec{}, err
+	}
+
+	// The CLI only reads the inventory from a plaintext file, so the secrets (decrypted, with the
+	// provided ones) are written into the run directory for the duration of the run: the directory
+	// is private (0700, from os.MkdirTemp), the file 0600, and both are removed by Cleanup once the
+	// run finishes or is canceled, including on server shutdown. Passing them on the command line
+	// instead would expose them to every user through the process list.
+	envs, err := readSecrets(project.SecretsYMLPath)
+	if err != nil {
+		spec.Cleanup()
+		return RunSpec{}, err
+	}
+	if reqObj.Secrets != nil {
+		if envs.Envs, err = unmaskSecrets(project.SecretsYMLPath, reqObj.Secrets.Envs, envs); err != nil {
+			spec.Cleanup()
+			return RunSpec{}, err
+		}
+		spec.Unsaved = true
+	}
+
+	inventory, err := secretsInventory(project.SecretsYMLPath, envs)
+	if err != nil {
+		spec.Cleanup()
+		return RunSpec{}, err
+	}
+	if len(inventory.Envs) > 0 {
+		contAsYAML, err := yaml.Marshal(inventory)
+		if err != nil {
+			spec.Cleanup()
+			return RunSpec{}, err
+		}
+		spec.InventoryPath = filepath.Join(tmpDir, ".bitrise.secrets.yml")
+		if err := secrets.WriteFile(spec.InventoryPath, contAsYAML, ""); err != nil {
+			spec.Cleanup()
+			return RunSpec{}, err
+		}
+	}
+
+	return spec, nil
+}
+
+// GetRunsHandler lists the run history, newest first.
+func GetRunsHandler(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, runsResponseModel{Runs: Runs.List()})
+}
+
+// GetRunHandler returns a run with its steps.
+func GetRunHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["run_id"]
+	info, ok := Runs.Get(id)
+	if !ok {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("Unknown run: %s", id))
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, runResponseModel{Run: info})
+}
+
+// PostCancelRunHandler cancels a running run.
+func PostCancelRunHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["run_id"]
+	if err := Runs.Cancel(id); errors.Is(err, ErrRunNotFound) {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("Unknown run: %s", id))
+		return
+	} else if errors.Is(err, ErrRunFinished) {
+		RespondWithJSON(w, http.StatusConflict, NewErrorResponse("Run %s already finished", id))
+		return
+	} else if err != nil {
+		log.Errorf("Failed to cancel run, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to cancel run, error: %s", err)
+		return
+	}
+
+	info, _ := Runs.Get(id)
+	RespondWithJSON(w, http.StatusOK, runResponseModel{Run: info})
+}
+
+// GetRunEventsHandler streams a run's events as server-sent events: the kept events first, then
+// new ones as they come, until the run finishes. A reconnecting client resumes after the
+// `Last-Event-ID` header (or the `after` query param).
+func GetRunEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["run_id"]
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RespondWithJSONBadRequestErrorMessage(w, "Streaming is not supported")
+		return
+	}
+
+	after := -1
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("after")
+	}
+	if lastEventID != "" {
+		seq, err := strconv.Atoi(lastEventID)
+		if err != nil {
+			RespondWithJSONBadRequestErrorMessage(w, "Invalid event id: %s", lastEventID)
+			return
+		}
+		after = seq
+	}
+
+	notify, unsubscribe, err := Runs.Subscribe(id)
+	if err != nil {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("Unknown run: %s", id))
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, finished, err := Runs.Events(id, after)
+		if err != nil {
+			return
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Errorf("Failed to serialize run event, error: %s", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data); err != nil {
+				return
+			}
+			after = event.Seq
+		}
+		flusher.Flush()
+
+		if finished {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
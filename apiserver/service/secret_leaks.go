@@ -0,0 +1,536 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Secret leak severities: leaks are errors, rejecting the save, under the strict setting.
+const (
+	SecretLeakWarning = "warning"
+	SecretLeakError   = "error"
+)
+
+// SecretLeak is a value of a config file that looks like a credential.
+type SecretLeak struct {
+	// File is the config file, relative to the repository root for module files.
+	File   string `json:"file"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Rule   string `json:"rule"`
+	// Preview is the redacted value.
+	Preview string `json:"preview"`
+	// SuggestedKey is the secret key to move the value to.
+	SuggestedKey string `json:"suggested_key"`
+	Severity     string `json:"severity"`
+	Message      string `json:"message"`
+
+	description string
+	value       string
+	// baseKey is the key suggested for the value alone, before making it unique.
+	baseKey string
+}
+
+type secretLeakRule struct {
+	id          string
+	description string
+	pattern     *regexp.Regexp
+	key         string
+}
+
+// secretLeakRules are the known token formats; a capture group, if any, is the secret part.
+var secretLeakRules = []secretLeakRule{
+	{"private-key", "private key", regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`), "PRIVATE_KEY"},
+	{"github-token", "GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), "GITHUB_TOKEN"},
+	{"gitlab-token", "GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`), "GITLAB_TOKEN"},
+	{"aws-access-key-id", "AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), "AWS_ACCESS_KEY_ID"},
+	{"aws-secret-access-key", "AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key\W{1,4}([A-Za-z0-9/+]{40})\b`), "AWS_SECRET_ACCESS_KEY"},
+	{"slack-token", "Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`), "SLACK_TOKEN"},
+	{"slack-webhook", "Slack webhook URL", regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/_]+`), "SLACK_WEBHOOK_URL"},
+	{"google-api-key", "Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`), "GOOGLE_API_KEY"},
+	{"stripe-key", "Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{20,}`), "STRIPE_SECRET_KEY"},
+	{"npm-token", "npm token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`), "NPM_TOKEN"},
+}
+
+const (
+	highEntropyRule       = "high-entropy"
+	highEntropyMinLength  = 24
+	highEntropyMinBits    = 4.0
+	defaultLeakSecretKey  = "SECRET"
+	leakPreviewVisibleLen = 4
+)
+
+// highEntropyCandidate matches base64/base62-like words; `$` and `{` before it mean an env reference.
+var highEntropyCandidate = regexp.MustCompile(`(^|[^A-Za-z0-9+/=_$\{-])([A-Za-z0-9+/=_-]{24,})`)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretLeakIgnoredKeys hold prose or references that are never credentials.
+var secretLeakIgnoredKeys = map[string]bool{
+	"title": true, "summary": true, "description": true, "commit": true, "source_code_url": true, "support_url": true,
+}
+
+// ScanSecretLeaks finds credentials pasted into the values of a config file.
+func ScanSecretLeaks(file, contStr string, strict bool) ([]SecretLeak, error) {
+	var root yaml3.Node
+	if err := yaml3.Unmarshal([]byte(contStr), &root); err != nil {
+		return nil, fmt.Errorf("invalid config (%s): %w", file, err)
+	}
+	var scalars []configScalar
+	collectConfigScalars(&root, nil, &scalars)
+
+	severity := SecretLeakWarning
+	if strict {
+		severity = SecretLeakError
+	}
+
+	leaks := []SecretLeak{}
+	for _, scalar := range scalars {
+		if secretLeakIgnoredKeys[scalar.at(1)] || scalar.node.Tag == "!!bool" || scalar.node.Tag == "!!int" {
+			continue
+		}
+		for _, leak := range scanScalarForSecretLeaks(scalar) {
+			leak.File = file
+			leak.Severity = severity
+			leaks = append(leaks, leak)
+		}
+	}
+	describeSecretLeaks(leaks, nil)
+	return leaks, nil
+}
+
+// describeSecretLeaks gives every distinct leaked value its own suggested key, skipping the keys
+// taken by other values, so the leaks can be moved one after the other: the second value suggested
+// as SECRET becomes SECRET_2. saved holds the keys and values of the secrets file, if known.
+func describeSecretLeaks(leaks []SecretLeak, saved map[string]string) {
+	taken := map[string]string{}
+	for key, value := range saved {
+		taken[key] = value
+	}
+	keys := map[[2]string]string{}
+	for i := range leaks {
+		leak := &leaks[i]
+		id := [2]string{leak.baseKey, leak.value}
+		key, ok := keys[id]
+		if !ok {
+			key = leak.baseKey
+			for n := 2; ; n++ {
+				if value, ok := taken[key]; !ok || value == leak.value {
+					break
+				}
+				key = fmt.Sprintf("%s_%d", leak.baseKey, n)
+			}
+			taken[key] = leak.value
+			keys[id] = key
+		}
+		leak.SuggestedKey = key
+		leak.Message = fmt.Sprintf("%s: possible %s at %s (line %d), move it to a secret: $%s", leak.File, leak.description, leak.Path, leak.Line, key)
+	}
+}
+
+// SuggestFreeSecretKeys updates the suggested keys of the leaks to skip the keys already saved in
+// the secrets file with a different value.
+func SuggestFreeSecretKeys(leaks []SecretLeak, secretsYMLPth string) error {
+	saved, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for _, env := range saved.Envs {
+		key, value, err := env.GetKeyValuePair()
+		if err != nil {
+			return err
+		}
+		values[key] = value
+	}
+	describeSecretLeaks(leaks, values)
+	return nil
+}
+
+func scanScalarForSecretLeaks(scalar configScalar) []SecretLeak {
+	value := scalar.node.Value
+	var leaks []SecretLeak
+	var covered [][2]int
+	add := func(rule, description, key string, start, end int) {
+		ref := scalar.referenceAt(start)
+		leaks = append(leaks, SecretLeak{
+			Path:        ref.Path,
+			Line:        ref.Line,
+			Column:      ref.Column,
+			Rule:        rule,
+			description: description,
+			Preview:     redactSecret(value[start:end]),
+			value:       value[start:end],
+			baseKey:     suggestedSecretKey(scalar, key),
+		})
+		covered = append(covered, [2]int{start, end})
+	}
+
+	for _, rule := range secretLeakRules {
+		for _, idx := range rule.pattern.FindAllStringSubmatchIndex(value, -1) {
+			start, end := idx[0], idx[1]
+			if len(idx) > 2 {
+				start, end = idx[2], idx[3]
+			}
+			add(rule.id, rule.description, rule.key, start, end)
+		}
+	}
+
+	for _, idx := range highEntropyCandidate.FindAllStringSubmatchIndex(value, -1) {
+		start, end := idx[4], idx[5]
+		if overlaps(covered, start, end) || !looksLikeSecret(value[start:end]) {
+			continue
+		}
+		add(highEntropyRule, "high-entropy string", defaultLeakSecretKey, start, end)
+	}
+
+	sort.SliceStable(leaks, func(i, j int) bool { return leaks[i].Line < leaks[j].Line })
+	return leaks
+}
+
+func overlaps(ranges [][2]int, start, end int) bool {
+	for _, r := range ranges {
+		if start < r[1] && r[0] < end {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeSecret reports whether a word mixes upper and lower case letters with digits and its
+// characters are random enough. Hex digests (commit hashes) and UUIDs don't pass the first check.
+func looksLikeSecret(word string) bool {
+	if len(word) < highEntropyMinLength {
+		return false
+	}
+	var upper, lower, digit bool
+	for _, c := range word {
+		upper = upper || unicode.IsUpper(c)
+		lower = lower || unicode.IsLower(c)
+		digit = digit || unicode.IsDigit(c)
+	}
+	return upper && lower && digit && shannonEntropy(word) > highEntropyMinBits
+}
+
+// shannonEntropy returns the bits per character of s.
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func redactSecret(value string) string {
+	if len(value) <= leakPreviewVisibleLen*2 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:leakPreviewVisibleLen] + strings.Repeat("*", 8)
+}
+
+// suggestedSecretKey names the secret after the env or step input holding the value, if any.
+func suggestedSecretKey(scalar configScalar, fallback string) string {
+	if key := scalar.envKey(); key != "" {
+		return key
+	}
+	if scalar.isStepInput() {
+		if input := scalar.at(1); input != "content" && input != "script" {
+			return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(input))
+		}
+	}
+	return fallback
+}
+
+// ScanConfigTreeForSecretLeaks scans the config and its local module files.
+func ScanConfigTreeForSecretLeaks(bitriseYMLPth string, strict bool) ([]SecretLeak, error) {
+	rootContents, err := fileutil.ReadStringFromFile(bitriseYMLPth)
+	if err != nil {
+		return nil, err
+	}
+	repoRoot := filepath.Dir(bitriseYMLPth)
+	rootPath := filepath.Base(bitriseYMLPth)
+
+	leaks, err := ScanSecretLeaks(rootPath, rootContents, strict)
+	if err != nil {
+		return nil, err
+	}
+	_, existing := localModulePaths(mirrorTreeResolver{repoRoot: repoRoot}.resolve(rootPath, rootContents))
+	for _, pth := range sortedKeys(existing) {
+		contStr, err := fileutil.ReadStringFromFile(filepath.Join(repoRoot, filepath.FromSlash(pth)))
+		if err != nil {
+			return nil, err
+		}
+		moduleLeaks, err := ScanSecretLeaks(pth, contStr, strict)
+		if err != nil {
+			return nil, err
+		}
+		leaks = append(leaks, moduleLeaks...)
+	}
+	describeSecretLeaks(leaks, nil)
+	return leaks, nil
+}
+
+// scanWireTreeForSecretLeaks scans the editable files of a posted config tree that would be saved.
+func scanWireTreeForSecretLeaks(root wireTreeNode, strict bool) ([]SecretLeak, error) {
+	leaks := []SecretLeak{}
+	var walk func(node wireTreeNode) error
+	walk = func(node wireTreeNode) error {
+		if node.Editable && node.Error == "" {
+			nodeLeaks, err := ScanSecretLeaks(node.Path, node.Contents, strict)
+			if err != nil {
+				return err
+			}
+			leaks = append(leaks, nodeLeaks...)
+		}
+		for _, child := range node.Includes {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+	describeSecretLeaks(leaks, nil)
+	return leaks, nil
+}
+
+// withSecretLeakWarnings adds the leaks to the config warnings shown by the editor.
+func withSecretLeakWarnings(warnings *utility.WarningItems, leaks []SecretLeak) *utility.WarningItems {
+	if len(leaks) == 0 {
+		return warnings
+	}
+	if warnings == nil {
+		warnings = &utility.WarningItems{}
+	}
+	for _, leak := range leaks {
+		warnings.Config = append(warnings.Config, leak.Message)
+	}
+	return warnings
+}
+
+func secretLeaksError(leaks []SecretLeak) string {
+	messages := make([]string, 0, len(leaks))
+	for _, leak := range leaks {
+		messages = append(messages, leak.Message)
+	}
+	return "Possible secrets in the config, move them to .bitrise.secrets.yml:\n" + strings.Join(messages, "\n")
+}
+
+// secretLeaksResponse is the error response of a save rejected for leaked secrets.
+type secretLeaksResponse struct {
+	Response
+	SecretLeaks []SecretLeak `json:"secret_leaks"`
+}
+
+// MoveSecretLeak saves the leaked value as a new secret and returns the config with the value
+// replaced by a reference to it. The config is rewritten first, so a failed rewrite doesn't leave
+// a new secret behind.
+func MoveSecretLeak(contStr string, leak SecretLeak, key, secretsYMLPth string) (string, error) {
+	if !envKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("invalid secret key: %s", key)
+	}
+	fixed, err := replaceSecretLeak(contStr, leak, "$"+key)
+	if err != nil {
+		return "", err
+	}
+	if err := addSecret(secretsYMLPth, key, leak.value); err != nil {
+		return "", err
+	}
+	return fixed, nil
+}
+
+// replaceSecretLeak replaces the leaked value on the lines it was found on, leaving the rest of the
+// file as it is. A value spanning lines (a private key in a block scalar) is replaced along with
+// the indentation of its lines. Fails, rather than re-encoding the file, when the value isn't on
+// those lines verbatim or the replacement would change anything but the leaked value.
+func replaceSecretLeak(contStr string, leak SecretLeak, reference string) (string, error) {
+	lines := strings.SplitAfter(contStr, "\n")
+	valueLines := strings.Split(leak.value, "\n")
+	first, last := leak.Line-1, leak.Line-1+len(valueLines)-1
+	if first < 0 || last >= len(lines) {
+		return "", fmt.Errorf("no possible secret at %s (line %d)", leak.Path, leak.Line)
+	}
+
+	var replaced string
+	if len(valueLines) == 1 {
+		if !strings.Contains(lines[first], leak.value) {
+			return "", fmt.Errorf("the value at %s isn't on line %d verbatim, move it manually", leak.Path, leak.Line)
+		}
+		replaced = strings.ReplaceAll(lines[first], leak.value, reference)
+	} else {
+		head, ok := strings.CutSuffix(strings.TrimRight(lines[first], "\r\n"), valueLines[0])
+		for i := 1; ok && i < len(valueLines)-1; i++ {
+			ok = strings.TrimLeft(strings.TrimRight(lines[first+i], "\r\n"), " \t") == valueLines[i]
+		}
+		var tail string
+		if ok {
+			tail, ok = strings.CutPrefix(strings.TrimLeft(lines[last], " \t"), valueLines[len(valueLines)-1])
+		}
+		if !ok {
+			return "", fmt.Errorf("the value at %s isn't on lines %d-%d verbatim, move it manually", leak.Path, leak.Line, leak.Line+len(valueLines)-1)
+		}
+		replaced = head + reference + tail
+	}
+	fixed := strings.Join(lines[:first], "") + replaced + strings.Join(lines[last+1:], "")
+
+	if err := checkSecretLeakReplaced(contStr, fixed, leak, reference); err != nil {
+		return "", err
+	}
+	return fixed, nil
+}
+
+// checkSecretLeakReplaced makes sure the only value changed is the leak's, where the value is
+// replaced by the reference.
+func checkSecretLeakReplaced(contStr, fixed string, leak SecretLeak, reference string) error {
+	var before, after yaml3.Node
+	if err := yaml3.Unmarshal([]byte(contStr), &before); err != nil {
+		return err
+	}
+	if err := yaml3.Unmarshal([]byte(fixed), &after); err != nil {
+		return fmt.Errorf("replacing the value at %s would break the config, move it manually: %w", leak.Path, err)
+	}
+	var beforeScalars, afterScalars []configScalar
+	collectConfigScalars(&before, nil, &beforeScalars)
+	collectConfigScalars(&after, nil, &afterScalars)
+
+	unexpected := len(beforeScalars) != len(afterScalars)
+	for i := 0; !unexpected && i < len(beforeScalars); i++ {
+		want := beforeScalars[i].node.Value
+		if beforeScalars[i].pathString() == leak.Path {
+			want = strings.ReplaceAll(want, leak.value, reference)
+		}
+		unexpected = afterScalars[i].pathString() != beforeScalars[i].pathString() || afterScalars[i].node.Value != want
+	}
+	if unexpected {
+		return fmt.Errorf("replacing the value at %s would change other values of the config, move it manually", leak.Path)
+	}
+	return nil
+}
+
+// addSecret appends a new secret to the secrets file, unless it's already saved with the same value.
+func addSecret(secretsYMLPth, key, value string) error {
+	saved, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		return err
+	}
+	for _, env := range saved.Envs {
+		savedKey, savedValue, err := env.GetKeyValuePair()
+		if err != nil {
+			return err
+		}
+		if savedKey == key && savedValue == value {
+			return nil
+		} else if savedKey == key {
+			return fmt.Errorf("secret %s already exists with a different value", key)
+		}
+	}
+
+	isExpand := false
+	saved.Envs = append(saved.Envs, envmanModels.EnvironmentItemModel{
+		key:                     value,
+		envmanModels.OptionsKey: envmanModels.EnvironmentItemOptionsModel{IsExpand: &isExpand},
+	})
+	cont, err := yaml.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return secrets.WriteFile(secretsYMLPth, cont, config.SecretsPassphrase)
+}
+
+type moveSecretLeakRequestModel struct {
+	// File is the config file the contents belong to, as reported by the scan.
+	File     string `json:"file"`
+	Contents string `json:"contents"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Key      string `json:"key"`
+}
+
+// PostMoveSecretLeakHandler moves a leaked value of the posted config file into a new secret and
+// returns the updated contents for the editor to save.
+func PostMoveSecretLeakHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj moveSecretLeakRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+	// A path can hold several leaks (and several values on different lines), the line picks one.
+	if reqObj.Path == "" || reqObj.Line <= 0 {
+		RespondWithJSONBadRequestErrorMessage(w, "path and line are required")
+		return
+	}
+
+	leaks, err := ScanSecretLeaks(reqObj.File, reqObj.Contents, false)
+	if err != nil {
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to scan config, error: %s", err)
+		return
+	}
+	if err := SuggestFreeSecretKeys(leaks, project.SecretsYMLPath); err != nil {
+		log.Errorf("Failed to read secrets, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read secrets, error: %s", err)
+		return
+	}
+	var leak *SecretLeak
+	for i := range leaks {
+		if leaks[i].Path == reqObj.Path && leaks[i].Line == reqObj.Line {
+			leak = &leaks[i]
+			break
+		}
+	}
+	if leak == nil {
+		RespondWithJSON(w, http.StatusNotFound, NewErrorResponse("No possible secret at %s (line %d)", reqObj.Path, reqObj.Line))
+		return
+	}
+
+	key := reqObj.Key
+	if key == "" {
+		key = leak.SuggestedKey
+	}
+	contStr, err := MoveSecretLeak(reqObj.Contents, *leak, key, project.SecretsYMLPath)
+	if err != nil {
+		log.Errorf("Failed to move secret, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to move secret, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"contents": contStr, "key": key})
+}
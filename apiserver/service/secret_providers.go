@@ -0,0 +1,507 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"gopkg.in/yaml.v2"
+)
+
+// secretSourceMetaKey is the env meta key recording where a listed secret comes from.
+const secretSourceMetaKey = "workflow_editor"
+
+// secretsFileSource is the source of the secrets saved in the secrets file.
+const secretsFileSource = "secrets-file"
+
+const (
+	secretCommandTimeout = 30 * time.Second
+	vaultRequestTimeout  = 10 * time.Second
+	// secretProviderCacheTTL is how long the values of the slow providers (command, vault) are reused.
+	secretProviderCacheTTL = 5 * time.Minute
+)
+
+// SecretProvider is an external source of secrets. Provided secrets are read-only: editing one
+// in the editor saves an override into the secrets file.
+type SecretProvider interface {
+	// Source identifies the provider in the secrets list, e.g. `dotenv:.env`.
+	Source() string
+	// Keys returns the provided keys, without reading the values if the provider knows them upfront.
+	Keys() ([]string, error)
+	// Secrets returns the provided values by key.
+	Secrets() (map[string]string, error)
+}
+
+// secretProviderConfig is an item of the providers config; its fields depend on the type.
+type secretProviderConfig struct {
+	Type string `yaml:"type"`
+	// env: the keys, or the key prefix, to take from the process environment.
+	Keys   []string `yaml:"keys"`
+	Prefix string   `yaml:"prefix"`
+	// dotenv: the file, relative to the project.
+	Path string `yaml:"path"`
+	// command: the command printing each secret's value, e.g. `[op, read, "op://dev/npm/token"]`.
+	Commands map[string][]string `yaml:"commands"`
+	// vault: a KV v2 secret, read with the token in TokenEnv (VAULT_TOKEN by default). The address
+	// must be a loopback one or the VAULT_ADDR of the environment.
+	Address  string `yaml:"address"`
+	TokenEnv string `yaml:"token_env"`
+	Mount    string `yaml:"mount"`
+	Secret   string `yaml:"secret"`
+}
+
+type secretProvidersConfigModel struct {
+	Providers []secretProviderConfig `yaml:"providers"`
+}
+
+// loadSecretProviders reads the providers config set with --secret-providers. It's never read from
+// the project, as a cloned repository could then run commands or send tokens anywhere. No config
+// means no providers. Relative paths and commands are resolved in the project of the secrets file.
+func loadSecretProviders(secretsYMLPth string) ([]SecretProvider, error) {
+	pth := config.SecretProvidersPath
+	if pth == "" {
+		return nil, nil
+	}
+
+	cont, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret providers config: %w", err)
+	}
+	var cfg secretProvidersConfigModel
+	if err := yaml.Unmarshal(cont, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", pth, err)
+	}
+
+	dir := filepath.Dir(secretsYMLPth)
+	var providers []SecretProvider
+	for i, item := range cfg.Providers {
+		provider, err := newSecretProvider(item, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: providers[%d]: %w", pth, i, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+func newSecretProvider(item secretProviderConfig, dir string) (SecretProvider, error) {
+	switch item.Type {
+	case "env":
+		if len(item.Keys) == 0 && item.Prefix == "" {
+			return nil, fmt.Errorf("env provider needs keys or a prefix")
+		}
+		return envSecretProvider{keys: item.Keys, prefix: item.Prefix}, nil
+	case "dotenv":
+		if item.Path == "" {
+			item.Path = ".env"
+		}
+		pth := item.Path
+		if !filepath.IsAbs(pth) {
+			pth = filepath.Join(dir, pth)
+		}
+		return dotenvSecretProvider{name: item.Path, path: pth}, nil
+	case "command":
+		if len(item.Commands) == 0 {
+			return nil, fmt.Errorf("command provider needs commands")
+		}
+		for key, args := range item.Commands {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("empty command for %s", key)
+			}
+		}
+		provider := commandSecretProvider{commands: item.Commands, dir: dir}
+		return cachedSecretProvider{SecretProvider: provider, key: fmt.Sprintf("%s\x00%q", dir, item.Commands)}, nil
+	case "vault":
+		if item.Address == "" || item.Secret == "" {
+			return nil, fmt.Errorf("vault provider needs an address and a secret")
+		}
+		address := strings.TrimSuffix(item.Address, "/")
+		if !isAllowedVaultAddress(address) {
+			return nil, fmt.Errorf("vault address %s is not allowed, use a loopback address or the VAULT_ADDR of the environment", item.Address)
+		}
+		if item.TokenEnv == "" {
+			item.TokenEnv = "VAULT_TOKEN"
+		}
+		if item.Mount == "" {
+			item.Mount = "secret"
+		}
+		provider := vaultSecretProvider{address: address, tokenEnv: item.TokenEnv, mount: item.Mount, secret: item.Secret}
+		return cachedSecretProvider{SecretProvider: provider, key: fmt.Sprintf("%s\x00%s\x00%s\x00%s", address, item.TokenEnv, item.Mount, item.Secret)}, nil
+	}
+	return nil, fmt.Errorf("unknown provider type %q, expected one of: env, dotenv, command, vault", item.Type)
+}
+
+// isAllowedVaultAddress reports whether the vault token may be sent to the address: a loopback
+// one (a dev server or an agent), or the one the vault CLI of the environment uses.
+func isAllowedVaultAddress(address string) bool {
+	if vaultAddr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"); vaultAddr != "" && address == vaultAddr {
+		return true
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return isLoopbackHost(u.Hostname())
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// cachedSecretProvider reuses the values of a slow provider for secretProviderCacheTTL. key
+// identifies the provider's config.
+type cachedSecretProvider struct {
+	SecretProvider
+	key string
+}
+
+type cachedSecrets struct {
+	values    map[string]string
+	fetchedAt time.Time
+}
+
+var (
+	secretProviderCacheMu sync.Mutex
+	secretProviderCache   = map[string]cachedSecrets{}
+)
+
+func (p cachedSecretProvider) Keys() ([]string, error) {
+	if _, known := p.SecretProvider.(commandSecretProvider); known {
+		return p.SecretProvider.Keys()
+	}
+	values, err := p.Secrets()
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(values), nil
+}
+
+func (p cachedSecretProvider) Secrets() (map[string]string, error) {
+	secretProviderCacheMu.Lock()
+	cached, ok := secretProviderCache[p.key]
+	secretProviderCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < secretProviderCacheTTL {
+		return cached.values, nil
+	}
+
+	values, err := p.SecretProvider.Secrets()
+	if err != nil {
+		return nil, err
+	}
+	secretProviderCacheMu.Lock()
+	secretProviderCache[p.key] = cachedSecrets{values: values, fetchedAt: time.Now()}
+	secretProviderCacheMu.Unlock()
+	return values, nil
+}
+
+// envSecretProvider provides secrets from the process environment.
+type envSecretProvider struct {
+	keys   []string
+	prefix string
+}
+
+func (p envSecretProvider) Source() string {
+	return "env"
+}
+
+func (p envSecretProvider) Secrets() (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, key := range p.keys {
+		if value, ok := os.LookupEnv(key); ok {
+			secrets[key] = value
+		}
+	}
+	if p.prefix != "" {
+		for _, env := range os.Environ() {
+			if key, value, ok := strings.Cut(env, "="); ok && strings.HasPrefix(key, p.prefix) {
+				secrets[key] = value
+			}
+		}
+	}
+	return secrets, nil
+}
+
+func (p envSecretProvider) Keys() ([]string, error) {
+	secrets, err := p.Secrets()
+	return sortedKeys(secrets), err
+}
+
+// dotenvSecretProvider provides the secrets of a .env file.
+type dotenvSecretProvider struct {
+	name string
+	path string
+}
+
+func (p dotenvSecretProvider) Source() string {
+	return "dotenv:" + p.name
+}
+
+func (p dotenvSecretProvider) Secrets() (map[string]string, error) {
+	cont, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDotenv(cont)
+}
+
+func (p dotenvSecretProvider) Keys() ([]string, error) {
+	secrets, err := p.Secrets()
+	return sortedKeys(secrets), err
+}
+
+// parseDotenv parses KEY=value lines; `export` prefixes, comments and quoted values are supported.
+func parseDotenv(cont []byte) (map[string]string, error) {
+	secrets := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(cont))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNum)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value: %w", lineNum, err)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", lineNum)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+		secrets[key] = value
+	}
+	return secrets, scanner.Err()
+}
+
+// commandSecretProvider runs a CLI (e.g. `op read`) for each secret, its trimmed output being the value.
+type commandSecretProvider struct {
+	commands map[string][]string
+	dir      string
+}
+
+func (p commandSecretProvider) Source() string {
+	return "command"
+}
+
+// Keys doesn't run the commands: the keys are the configured ones.
+func (p commandSecretProvider) Keys() ([]string, error) {
+	return sortedKeys(p.commands), nil
+}
+
+func (p commandSecretProvider) Secrets() (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, key := range sortedKeys(p.commands) {
+		args := p.commands[key]
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = p.dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s with %s: %w: %s", key, args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		secrets[key] = strings.TrimRight(string(out), "\r\n")
+	}
+	return secrets, nil
+}
+
+// vaultSecretProvider provides the fields of a Vault KV v2 secret.
+type vaultSecretProvider struct {
+	address  string
+	tokenEnv string
+	mount    string
+	secret   string
+}
+
+func (p vaultSecretProvider) Source() string {
+	return fmt.Sprintf("vault:%s/%s", p.mount, p.secret)
+}
+
+func (p vaultSecretProvider) Keys() ([]string, error) {
+	secrets, err := p.Secrets()
+	return sortedKeys(secrets), err
+}
+
+func (p vaultSecretProvider) Secrets() (map[string]string, error) {
+	token := os.Getenv(p.tokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("%s is not set", p.tokenEnv)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s/data/%s", p.address, p.mount, strings.TrimPrefix(p.secret, "/")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := http.Client{Timeout: vaultRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded with %s", resp.Status)
+	}
+
+	var respObj struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respObj); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	secrets := map[string]string{}
+	for key, value := range respObj.Data.Data {
+		if str, ok := value.(string); ok {
+			secrets[key] = str
+		} else {
+			secrets[key] = fmt.Sprint(value)
+		}
+	}
+	return secrets, nil
+}
+
+// providedSecret is a secret of the unified list, with the source it comes from.
+type providedSecret struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// providedSecrets collects the secrets of the providers that aren't saved in the secrets file
+// (saved values override provided ones), in provider order. The first provider of a key wins.
+func providedSecrets(secretsYMLPth string, saved envmanModels.EnvsSerializeModel) ([]providedSecret, error) {
+	return collectProvidedSecrets(secretsYMLPth, saved, SecretProvider.Secrets)
+}
+
+// providedSecretKeys is providedSecrets without the values, for listing the secrets: providers
+// knowing their keys upfront aren't run.
+func providedSecretKeys(secretsYMLPth string, saved envmanModels.EnvsSerializeModel) ([]providedSecret, error) {
+	return collectProvidedSecrets(secretsYMLPth, saved, func(provider SecretProvider) (map[string]string, error) {
+		keys, err := provider.Keys()
+		if err != nil {
+			return nil, err
+		}
+		secrets := map[string]string{}
+		for _, key := range keys {
+			secrets[key] = ""
+		}
+		return secrets, nil
+	})
+}
+
+func collectProvidedSecrets(secretsYMLPth string, saved envmanModels.EnvsSerializeModel, read func(SecretProvider) (map[string]string, error)) ([]providedSecret, error) {
+	providers, err := loadSecretProviders(secretsYMLPth)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, env := range saved.Envs {
+		key, _, err := env.GetKeyValuePair()
+		if err != nil {
+			return nil, err
+		}
+		seen[key] = true
+	}
+
+	var secrets []providedSecret
+	for _, provider := range providers {
+		values, err := read(provider)
+		if err != nil {
+			return nil, fmt.Errorf("secret provider %s: %w", provider.Source(), err)
+		}
+		for _, key := range sortedKeys(values) {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			secrets = append(secrets, providedSecret{Key: key, Value: values[key], Source: provider.Source()})
+		}
+	}
+	return secrets, nil
+}
+
+// withSecretSource records the source of a listed secret in its options' meta.
+func withSecretSource(opts envmanModels.EnvironmentItemOptionsModel, source string) envmanModels.EnvironmentItemOptionsModel {
+	meta := map[string]interface{}{}
+	for key, value := range opts.Meta {
+		meta[key] = value
+	}
+	meta[secretSourceMetaKey] = map[string]interface{}{"source": source}
+	opts.Meta = meta
+	return opts
+}
+
+// withoutSecretSource drops the source meta the editor may send back with a secret.
+func withoutSecretSource(env envmanModels.EnvironmentItemModel) error {
+	opts, err := env.GetOptions()
+	if err != nil || opts.Meta == nil {
+		return err
+	}
+	if _, ok := opts.Meta[secretSourceMetaKey]; !ok {
+		return nil
+	}
+	delete(opts.Meta, secretSourceMetaKey)
+	if len(opts.Meta) == 0 {
+		opts.Meta = nil
+	}
+	env[envmanModels.OptionsKey] = opts
+	return nil
+}
+
+// secretsInventory returns the secrets a run uses: the saved secrets with the provided ones.
+func secretsInventory(secretsYMLPth string, saved envmanModels.EnvsSerializeModel) (envmanModels.EnvsSerializeModel, error) {
+	provided, err := providedSecrets(secretsYMLPth, saved)
+	if err != nil {
+		return envmanModels.EnvsSerializeModel{}, err
+	}
+
+	inventory := envmanModels.EnvsSerializeModel{Envs: append([]envmanModels.EnvironmentItemModel{}, saved.Envs...)}
+	for _, secret := range provided {
+		inventory.Envs = append(inventory.Envs, envmanModels.EnvironmentItemModel{secret.Key: secret.Value})
+	}
+	return inventory, nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDotenv(t *testing.T) {
+	secrets, err := parseDotenv([]byte(`# comment
+API_TOKEN=s3cr3t
+export REGION = eu-west-1 # inline comment
+QUOTED="line1\nline2"
+SINGLE='$NOT_EXPANDED'
+EMPTY=
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"API_TOKEN": "s3cr3t",
+		"REGION":    "eu-west-1",
+		"QUOTED":    "line1\nline2",
+		"SINGLE":    "$NOT_EXPANDED",
+		"EMPTY":     "",
+	}, secrets)
+
+	_, err = parseDotenv([]byte("API_TOKEN\n"))
+	require.EqualError(t, err, "line 1: expected KEY=value")
+}
+
+// newVaultDevServer stands in for a Vault dev server serving one KV v2 secret.
+func newVaultDevServer(t *testing.T, token string, data map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/bitrise" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}}))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// useSecretProviders sets a providers config, outside of any project, for the test.
+func useSecretProviders(t *testing.T, content string) string {
+	pth := filepath.Join(t.TempDir(), "secret-providers.yml")
+	require.NoError(t, os.WriteFile(pth, []byte(content), 0600))
+	config.SecretProvidersPath = pth
+	t.Cleanup(func() { config.SecretProvidersPath = "" })
+	return pth
+}
+
+func TestProvidedSecrets(t *testing.T) {
+	dir := t.TempDir()
+	vault := newVaultDevServer(t, "root", map[string]string{"VAULT_SECRET": "from-vault", "DOTENV_SECRET": "shadowed"})
+	t.Setenv("PROVIDER_TEST_TOKEN", "root")
+	t.Setenv("PROVIDER_TEST_ENV_SECRET", "from-env")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("DOTENV_SECRET=from-dotenv\nSAVED=shadowed\n"), 0600))
+	useSecretProviders(t, fmt.Sprintf(`providers:
+- type: env
+  keys: [PROVIDER_TEST_ENV_SECRET, PROVIDER_TEST_MISSING]
+- type: dotenv
+- type: command
+  commands:
+    COMMAND_SECRET: [echo, from-command]
+- type: vault
+  address: %s
+  token_env: PROVIDER_TEST_TOKEN
+  secret: bitrise
+`, vault.URL))
+
+	secretsYMLPth := filepath.Join(dir, ".bitrise.secrets.yml")
+	saved := envmanModels.EnvsSerializeModel{Envs: []envmanModels.EnvironmentItemModel{{"SAVED": "from-file"}}}
+
+	provided, err := providedSecrets(secretsYMLPth, saved)
+	require.NoError(t, err)
+	require.Equal(t, []providedSecret{
+		{Key: "PROVIDER_TEST_ENV_SECRET", Value: "from-env", Source: "env"},
+		{Key: "DOTENV_SECRET", Value: "from-dotenv", Source: "dotenv:.env"},
+		{Key: "COMMAND_SECRET", Value: "from-command", Source: "command"},
+		{Key: "VAULT_SECRET", Value: "from-vault", Source: "vault:secret/bitrise"},
+	}, provided)
+
+	t.Log("failing provider")
+	{
+		t.Setenv("PROVIDER_TEST_WRONG_TOKEN", "wrong")
+		useSecretProviders(t, fmt.Sprintf("providers:\n- type: vault\n  address: %s\n  token_env: PROVIDER_TEST_WRONG_TOKEN\n  secret: bitrise\n", vault.URL))
+		_, err := providedSecrets(secretsYMLPth, saved)
+		require.EqualError(t, err, "secret provider vault:secret/bitrise: vault responded with 403 Forbidden")
+	}
+
+	t.Log("vault outside of loopback")
+	{
+		pth := useSecretProviders(t, "providers:\n- type: vault\n  address: https://vault.example.com\n  secret: bitrise\n")
+		_, err := providedSecrets(secretsYMLPth, saved)
+		require.EqualError(t, err, pth+": providers[0]: vault address https://vault.example.com is not allowed, use a loopback address or the VAULT_ADDR of the environment")
+
+		t.Setenv("VAULT_ADDR", "https://vault.example.com/")
+		_, err = loadSecretProviders(secretsYMLPth)
+		require.NoError(t, err)
+	}
+
+	t.Log("invalid config")
+	{
+		pth := useSecretProviders(t, "providers:\n- type: keychain\n")
+		_, err := providedSecrets(secretsYMLPth, saved)
+		require.EqualError(t, err, pth+`: providers[0]: unknown provider type "keychain", expected one of: env, dotenv, command, vault`)
+	}
+
+	t.Log("no config")
+	{
+		config.SecretProvidersPath = ""
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".bitrise.secret-providers.yml"), []byte("providers:\n- type: dotenv\n"), 0600))
+		provided, err := providedSecrets(secretsYMLPth, saved)
+		require.NoError(t, err)
+		require.Empty(t, provided)
+	}
+}
+
+func TestProvidedSecretKeys_commands(t *testing.T) {
+	dir := t.TempDir()
+	secretsYMLPth := filepath.Join(dir, ".bitrise.secrets.yml")
+	counterPth := filepath.Join(dir, "runs")
+	useSecretProviders(t, fmt.Sprintf(`providers:
+- type: command
+  commands:
+    COUNTED_SECRET: [sh, -c, "echo run >> %s && echo from-command"]
+    FAILING_SECRET: ["false"]
+`, counterPth))
+
+	t.Log("listing doesn't run the commands")
+	{
+		provided, err := providedSecretKeys(secretsYMLPth, envmanModels.EnvsSerializeModel{})
+		require.NoError(t, err)
+		require.Equal(t, []providedSecret{
+			{Key: "COUNTED_SECRET", Source: "command"},
+			{Key: "FAILING_SECRET", Source: "command"},
+		}, provided)
+		require.NoFileExists(t, counterPth)
+	}
+
+	t.Log("values are cached")
+	{
+		useSecretProviders(t, fmt.Sprintf(`providers:
+- type: command
+  commands:
+    COUNTED_SECRET: [sh, -c, "echo run >> %s && echo from-command"]
+`, counterPth))
+		for i := 0; i < 2; i++ {
+			provided, err := providedSecrets(secretsYMLPth, envmanModels.EnvsSerializeModel{})
+			require.NoError(t, err)
+			require.Equal(t, []providedSecret{{Key: "COUNTED_SECRET", Value: "from-command", Source: "command"}}, provided)
+		}
+		cont, err := os.ReadFile(counterPth)
+		require.NoError(t, err)
+		require.Equal(t, "run\n", string(cont))
+	}
+}
+
+func TestSecretsHandlers_providers(t *testing.T) {
+	dir := t.TempDir()
+	secretsYMLPth := filepath.Join(dir, ".bitrise.secrets.yml")
+	require.NoError(t, os.WriteFile(secretsYMLPth, []byte("envs:\n- SAVED: from-file\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("DOTENV_SECRET=from-dotenv\n"), 0600))
+	useSecretProviders(t, "providers:\n- type: dotenv\n")
+	config.SecretsYMLPath = secretsYMLPth
+
+	t.Log("one list with sources")
+	{
+		req, err := http.NewRequest("GET", "/api/secrets", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetSecretsAsJSONHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.JSONEq(t, `{"envs":[
+			{"SAVED":"__BITRISE_SECRET_UNCHANGED__","opts":{"meta":{"workflow_editor":{"source":"secrets-file"}}}},
+			{"DOTENV_SECRET":"__BITRISE_SECRET_UNCHANGED__","opts":{"meta":{"workflow_editor":{"source":"dotenv:.env"}}}}
+		]}`, rr.Body.String())
+	}
+
+	save := func(body string) {
+		req, err := http.NewRequest("POST", "/api/secrets", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostSecretsYMLFromJSONHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	t.Log("masked provided secrets stay with the provider")
+	{
+		save(`{"envs":[
+			{"SAVED":"__BITRISE_SECRET_UNCHANGED__","opts":{"meta":{"workflow_editor":{"source":"secrets-file"}}}},
+			{"DOTENV_SECRET":"__BITRISE_SECRET_UNCHANGED__","opts":{"meta":{"workflow_editor":{"source":"dotenv:.env"}}}}
+		]}`)
+		cont, err := os.ReadFile(secretsYMLPth)
+		require.NoError(t, err)
+		require.Equal(t, "envs:\n- SAVED: from-file\n  opts: {}\n", string(cont))
+	}
+
+	t.Log("changed provided secrets are saved as overrides")
+	{
+		save(`{"envs":[{"SAVED":"__BITRISE_SECRET_UNCHANGED__","opts":{}},{"DOTENV_SECRET":"override","opts":{}}]}`)
+
+		saved, err := readSecrets(secretsYMLPth)
+		require.NoError(t, err)
+		inventory, err := secretsInventory(secretsYMLPth, saved)
+		require.NoError(t, err)
+		require.Len(t, inventory.Envs, 2)
+		key, value, err := inventory.Envs[1].GetKeyValuePair()
+		require.NoError(t, err)
+		require.Equal(t, "DOTENV_SECRET", key)
+		require.Equal(t, "override", value)
+	}
+}
@@ -0,0 +1,482 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	envmanModels "github.com/bitrise-io/envman/models"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Secret exposure kinds.
+const (
+	// SecretExposurePrinted is a secret printed by echo/printf in a script.
+	SecretExposurePrinted = "printed_in_script"
+	// SecretExposureTraced is a secret used in a script with shell tracing (set -x) on.
+	SecretExposureTraced = "traced_in_script"
+	// SecretExposureNotExpanded is a secret with is_expand: false used in a step input: the step
+	// gets the literal reference, not the value.
+	SecretExposureNotExpanded = "not_expanded"
+	// SecretExposureCopiedToEnv is a secret copied into a config env that isn't sensitive.
+	SecretExposureCopiedToEnv = "copied_to_env"
+)
+
+var (
+	runIfEnvReference   = regexp.MustCompile(`\b(?:getenv|enveq|envcontain)\s+"([A-Za-z_][A-Za-z0-9_]*)"`)
+	scriptEnvAssignment = regexp.MustCompile(`(?m)(?:^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)=|envman\s+add\s+--key[\s=]+["']?([A-Za-z_][A-Za-z0-9_]*))`)
+	scriptPrintLine     = regexp.MustCompile(`^\s*(?:echo|printf|print)\b`)
+	scriptTracing       = regexp.MustCompile(`(?m)^\s*set\s+-[a-z]*x`)
+	upperCaseEnvKey     = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+// wellKnownEnvKeys and wellKnownEnvPrefixes are set by the CLI, the build machine or the shell,
+// so referencing them isn't reported as undefined.
+var (
+	wellKnownEnvKeys     = map[string]bool{"CI": true, "PR": true, "PULL_REQUEST_ID": true, "HOME": true, "PATH": true, "PWD": true, "USER": true, "SHELL": true, "TMPDIR": true, "LANG": true}
+	wellKnownEnvPrefixes = []string{"BITRISE_", "BITRISEIO_", "GIT_", "ANDROID_", "JAVA_"}
+)
+
+// EnvReference locates a `$KEY` reference (or a run_if getenv) in the config.
+type EnvReference struct {
+	// File is the config module, relative to the repository root; empty for a single config file.
+	File   string `json:"file,omitempty"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// SecretUsage lists where a secret is referenced.
+type SecretUsage struct {
+	Key        string         `json:"key"`
+	Source     string         `json:"source"`
+	References []EnvReference `json:"references"`
+}
+
+// UndefinedReference is a referenced key that's neither a secret nor defined by the config.
+type UndefinedReference struct {
+	Key        string         `json:"key"`
+	References []EnvReference `json:"references"`
+}
+
+// SecretExposure is a use of a secret that can leak (or lose) its value.
+type SecretExposure struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+	EnvReference
+	Message string `json:"message"`
+}
+
+// SecretUsageReport cross-references the secrets with the config.
+type SecretUsageReport struct {
+	Secrets []SecretUsage `json:"secrets"`
+	// Unused are the secrets the config doesn't reference.
+	Unused []string `json:"unused"`
+	// Undefined may also be step outputs or env vars of the build machine.
+	Undefined []UndefinedReference `json:"undefined"`
+	Exposures []SecretExposure     `json:"exposures"`
+	// Warnings list the includes that couldn't be resolved, so their references are missing.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// secretInfo is a secret to analyze the config with.
+type secretInfo struct {
+	Key      string
+	Source   string
+	IsExpand bool
+}
+
+// configScalar is a scalar value of the config with its location.
+type configScalar struct {
+	// file is the config module of the scalar, if known.
+	file string
+	path []string
+	node *yaml3.Node
+}
+
+func (s configScalar) pathString() string {
+	var pth strings.Builder
+	for _, segment := range s.path {
+		if _, err := strconv.Atoi(segment); err == nil {
+			pth.WriteString("[" + segment + "]")
+			continue
+		}
+		if pth.Len() > 0 {
+			pth.WriteString(".")
+		}
+		pth.WriteString(segment)
+	}
+	return pth.String()
+}
+
+// at returns the segment i from the end of the path, or "".
+func (s configScalar) at(i int) string {
+	if len(s.path) < i {
+		return ""
+	}
+	return s.path[len(s.path)-i]
+}
+
+func (s configScalar) isRunIf() bool {
+	return s.at(1) == "run_if" || (s.at(1) == "expression" && s.at(2) == "run_if")
+}
+
+// envKey returns the key an env item of the config (`envs: [{KEY: value}]`) defines.
+func (s configScalar) envKey() string {
+	if s.at(3) != "envs" || s.at(1) == "opts" {
+		return ""
+	}
+	if _, err := strconv.Atoi(s.at(2)); err != nil {
+		return ""
+	}
+	return s.at(1)
+}
+
+func (s configScalar) isStepInput() bool {
+	for i := len(s.path) - 3; i >= 0; i-- {
+		if s.path[i] == "inputs" {
+			_, err := strconv.Atoi(s.path[i+1])
+			return err == nil && s.at(1) != "opts"
+		}
+	}
+	return false
+}
+
+// referenceAt returns the location of the byte offset within the scalar.
+func (s configScalar) referenceAt(offset int) EnvReference {
+	line := strings.Count(s.node.Value[:offset], "\n")
+	if s.node.Style&(yaml3.LiteralStyle|yaml3.FoldedStyle) != 0 {
+		// Block scalars start on the line after the indicator.
+		return EnvReference{File: s.file, Path: s.pathString(), Line: s.node.Line + 1 + line, Column: s.node.Column}
+	}
+	return EnvReference{File: s.file, Path: s.pathString(), Line: s.node.Line + line, Column: s.node.Column}
+}
+
+func collectConfigScalars(node *yaml3.Node, pth []string, out *[]configScalar) {
+	switch node.Kind {
+	case yaml3.DocumentNode:
+		for _, child := range node.Content {
+			collectConfigScalars(child, pth, out)
+		}
+	case yaml3.SequenceNode:
+		for i, child := range node.Content {
+			collectConfigScalars(child, append(append([]string{}, pth...), strconv.Itoa(i)), out)
+		}
+	case yaml3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			collectConfigScalars(node.Content[i+1], append(append([]string{}, pth...), node.Content[i].Value), out)
+		}
+	case yaml3.ScalarNode:
+		*out = append(*out, configScalar{path: pth, node: node})
+	}
+}
+
+type envReferenceMatch struct {
+	key    string
+	offset int
+}
+
+func findEnvReferences(scalar configScalar) []envReferenceMatch {
+	var matches []envReferenceMatch
+	for _, idx := range envReference.FindAllStringSubmatchIndex(scalar.node.Value, -1) {
+		start, end := idx[2], idx[3]
+		if start < 0 {
+			start, end = idx[4], idx[5]
+		}
+		matches = append(matches, envReferenceMatch{key: scalar.node.Value[start:end], offset: idx[0]})
+	}
+	if scalar.isRunIf() {
+		for _, idx := range runIfEnvReference.FindAllStringSubmatchIndex(scalar.node.Value, -1) {
+			matches = append(matches, envReferenceMatch{key: scalar.node.Value[idx[2]:idx[3]], offset: idx[0]})
+		}
+	}
+	return matches
+}
+
+// AnalyzeSecretUsage cross-references the secrets with the `$KEY` references of a config file.
+func AnalyzeSecretUsage(contStr string, secrets []secretInfo) (SecretUsageReport, error) {
+	return analyzeSecretUsage([]wireTreeNode{{Contents: contStr}}, secrets)
+}
+
+// analyzeConfigTreeSecretUsage analyzes every resolved module of a config tree together: a key
+// defined in one module counts as defined for the others, and the references name their module.
+func analyzeConfigTreeSecretUsage(root wireTreeNode, secrets []secretInfo) (SecretUsageReport, error) {
+	var modules []wireTreeNode
+	var walk func(node wireTreeNode)
+	walk = func(node wireTreeNode) {
+		modules = append(modules, node)
+		for _, child := range node.Includes {
+			if child.Error == "" {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	report, err := analyzeSecretUsage(modules, secrets)
+	if err != nil {
+		return report, err
+	}
+	report.Warnings = unresolvedIncludes(root)
+	return report, nil
+}
+
+func analyzeSecretUsage(modules []wireTreeNode, secrets []secretInfo) (SecretUsageReport, error) {
+	var scalars []configScalar
+	for _, module := range modules {
+		var root yaml3.Node
+		if err := yaml3.Unmarshal([]byte(module.Contents), &root); err != nil {
+			if module.Path == "" {
+				return SecretUsageReport{}, fmt.Errorf("invalid config: %w", err)
+			}
+			return SecretUsageReport{}, fmt.Errorf("invalid config (%s): %w", module.Path, err)
+		}
+		var moduleScalars []configScalar
+		collectConfigScalars(&root, nil, &moduleScalars)
+		for _, scalar := range moduleScalars {
+			scalar.file = module.Path
+			scalars = append(scalars, scalar)
+		}
+	}
+
+	secretsByKey := map[string]secretInfo{}
+	for _, secret := range secrets {
+		secretsByKey[secret.Key] = secret
+	}
+
+	// Keys defined by the config: env items, and envs exported by scripts.
+	defined := map[string]bool{}
+	sensitiveEnvs := map[string]bool{}
+	for _, scalar := range scalars {
+		if key := scalar.envKey(); key != "" {
+			defined[key] = true
+		}
+		if scalar.at(1) == "is_sensitive" && scalar.at(2) == "opts" && scalar.node.Value == "true" {
+			sensitiveEnvs[envItemID(scalar.file, scalar.path[:len(scalar.path)-2])] = true
+		}
+		for _, match := range scriptEnvAssignment.FindAllStringSubmatch(scalar.node.Value, -1) {
+			defined[match[1]+match[2]] = true
+		}
+	}
+
+	report := SecretUsageReport{Secrets: []SecretUsage{}, Unused: []string{}, Undefined: []UndefinedReference{}, Exposures: []SecretExposure{}}
+	references := map[string][]EnvReference{}
+	for _, scalar := range scalars {
+		matches := findEnvReferences(scalar)
+		if len(matches) == 0 {
+			continue
+		}
+		value := scalar.node.Value
+		tracing := scriptTracing.MatchString(value)
+
+		reportedLines := map[string]bool{}
+		for _, match := range matches {
+			ref := scalar.referenceAt(match.offset)
+			references[match.key] = append(references[match.key], ref)
+
+			secret, isSecret := secretsByKey[match.key]
+			if !isSecret {
+				continue
+			}
+			exposure := SecretExposure{Key: match.key, EnvReference: ref}
+
+			lineStart := strings.LastIndex(value[:match.offset], "\n") + 1
+			lineEnd := strings.Index(value[match.offset:], "\n")
+			if lineEnd < 0 {
+				lineEnd = len(value) - match.offset
+			}
+			line := value[lineStart : match.offset+lineEnd]
+			lineID := fmt.Sprintf("%s:%d", match.key, ref.Line)
+
+			switch {
+			case scriptPrintLine.MatchString(line):
+				if reportedLines[lineID] {
+					continue
+				}
+				reportedLines[lineID] = true
+				exposure.Kind = SecretExposurePrinted
+				exposure.Message = fmt.Sprintf("%s is printed: %s", match.key, strings.TrimSpace(line))
+			case tracing && !scalar.isRunIf():
+				exposure.Kind = SecretExposureTraced
+				exposure.Message = fmt.Sprintf("%s is used in a script with shell tracing (set -x), which prints the expanded commands", match.key)
+			case !secret.IsExpand && scalar.isStepInput():
+				exposure.Kind = SecretExposureNotExpanded
+				exposure.Message = fmt.Sprintf("%s has is_expand: false, so the step gets the literal $%s instead of its value", match.key, match.key)
+			case scalar.envKey() != "" && !sensitiveEnvs[envItemID(scalar.file, scalar.path[:len(scalar.path)-1])]:
+				exposure.Kind = SecretExposureCopiedToEnv
+				exposure.Message = fmt.Sprintf("%s is copied into %s, which isn't marked is_sensitive", match.key, scalar.envKey())
+			default:
+				continue
+			}
+			report.Exposures = append(report.Exposures, exposure)
+		}
+	}
+
+	for _, secret := range secrets {
+		refs := references[secret.Key]
+		if len(refs) == 0 {
+			report.Unused = append(report.Unused, secret.Key)
+			refs = []EnvReference{}
+		}
+		report.Secrets = append(report.Secrets, SecretUsage{Key: secret.Key, Source: secret.Source, References: refs})
+	}
+
+	keys := make([]string, 0, len(references))
+	for key := range references {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, isSecret := secretsByKey[key]; isSecret || defined[key] || !upperCaseEnvKey.MatchString(key) || isWellKnownEnvKey(key) {
+			continue
+		}
+		report.Undefined = append(report.Undefined, UndefinedReference{Key: key, References: references[key]})
+	}
+
+	return report, nil
+}
+
+// envItemID identifies an env item of the config tree by its module and path.
+func envItemID(file string, pth []string) string {
+	return file + ":" + strings.Join(pth, ".")
+}
+
+func isWellKnownEnvKey(key string) bool {
+	if wellKnownEnvKeys[key] {
+		return true
+	}
+	for _, prefix := range wellKnownEnvPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectSecretInfos lists the saved and the provided secrets of a project.
+func projectSecretInfos(secretsYMLPth string) ([]secretInfo, error) {
+	saved, err := readSecrets(secretsYMLPth)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []secretInfo
+	for _, env := range saved.Envs {
+		key, _, err := env.GetKeyValuePair()
+		if err != nil {
+			return nil, err
+		}
+		opts, err := env.GetOptions()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, secretInfo{Key: key, Source: secretsFileSource, IsExpand: opts.IsExpand == nil || *opts.IsExpand})
+	}
+
+	provided, err := providedSecretKeys(secretsYMLPth, saved)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range provided {
+		infos = append(infos, secretInfo{Key: secret.Key, Source: secret.Source, IsExpand: true})
+	}
+	return infos, nil
+}
+
+type secretUsageRequestModel struct {
+	// Root is the editor's (possibly unsaved) config tree.
+	Root *wireTreeNode `json:"root"`
+	// BitriseYML is the editor's (possibly unsaved) single-file config. The saved config tree is
+	// analyzed if neither is set.
+	BitriseYML string `json:"bitrise_yml"`
+	// Secrets are the editor's (possibly unsaved) secrets; the saved and provided ones are used if nil.
+	Secrets *envmanModels.EnvsSerializeModel `json:"secrets"`
+}
+
+// PostSecretUsageHandler reports unused, undefined and exposed secrets of the config.
+func PostSecretUsageHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj secretUsageRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+
+	var tree wireTreeNode
+	switch {
+	case reqObj.Root != nil:
+		tree = *reqObj.Root
+	case reqObj.BitriseYML != "":
+		tree = wireTreeNode{Path: filepath.Base(project.BitriseYMLPath), Contents: reqObj.BitriseYML}
+	default:
+		contStr, err := fileutil.ReadStringFromFile(project.BitriseYMLPath)
+		if err != nil {
+			log.Errorf("Failed to read bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to read bitrise.yml, error: %s", err)
+			return
+		}
+		resolver := mirrorTreeResolver{repoRoot: filepath.Dir(project.BitriseYMLPath), mirrorDir: config.IncludeMirrorDir}
+		tree = resolver.resolve(filepath.Base(project.BitriseYMLPath), contStr)
+	}
+
+	var infos []secretInfo
+	if reqObj.Secrets != nil {
+		for _, env := range reqObj.Secrets.Envs {
+			key, _, err := env.GetKeyValuePairWithType()
+			if err != nil {
+				RespondWithJSONBadRequestErrorMessage(w, "Invalid secrets, error: %s", err)
+				return
+			}
+			opts, err := env.GetOptions()
+			if err != nil {
+				RespondWithJSONBadRequestErrorMessage(w, "Invalid secrets, error: %s", err)
+				return
+			}
+			source := secretsFileSource
+			if meta, ok := opts.Meta[secretSourceMetaKey].(map[string]interface{}); ok {
+				if s, ok := meta["source"].(string); ok {
+					source = s
+				}
+			}
+			infos = append(infos, secretInfo{Key: key, Source: source, IsExpand: opts.IsExpand == nil || *opts.IsExpand})
+		}
+	} else {
+		var err error
+		if infos, err = projectSecretInfos(project.SecretsYMLPath); err != nil {
+			log.Errorf("Failed to read secrets, error: %s", err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to read secrets, error: %s", err)
+			return
+		}
+	}
+
+	report, err := analyzeConfigTreeSecretUsage(tree, infos)
+	if err != nil {
+		log.Errorf("Failed to analyze secret usage, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to analyze secret usage, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/stretchr/testify/require"
+)
+
+const secretUsageTestConfig = `format_version: "13"
+app:
+  envs:
+  - DEPLOY_TOKEN: $API_TOKEN
+  - SIGNING: $SIGNING_KEY
+    opts:
+      is_sensitive: true
+workflows:
+  deploy:
+    steps:
+    - script@1:
+        run_if: '{{getenv "SLACK_WEBHOOK" | ne ""}}'
+        inputs:
+        - content: |-
+            set -ex
+            export BUILD_DIR=out
+            echo "token: ${API_TOKEN}"
+            curl -H "Authorization: $API_TOKEN" $UPLOAD_URL
+            ls $BUILD_DIR $BITRISE_DEPLOY_DIR $HOME
+    - deploy@1:
+        inputs:
+        - key: $SIGNING_KEY
+`
+
+func TestAnalyzeSecretUsage(t *testing.T) {
+	report, err := AnalyzeSecretUsage(secretUsageTestConfig, []secretInfo{
+		{Key: "API_TOKEN", Source: "secrets-file", IsExpand: true},
+		{Key: "SIGNING_KEY", Source: "secrets-file", IsExpand: false},
+		{Key: "SLACK_WEBHOOK", Source: "dotenv:.env", IsExpand: true},
+		{Key: "UNUSED_SECRET", Source: "env", IsExpand: true},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"UNUSED_SECRET"}, report.Unused)
+	require.Equal(t, []SecretUsage{
+		{Key: "API_TOKEN", Source: "secrets-file", References: []EnvReference{
+			{Path: "app.envs[0].DEPLOY_TOKEN", Line: 4, Column: 19},
+			{Path: "workflows.deploy.steps[0].script@1.inputs[0].content", Line: 17, Column: 20},
+			{Path: "workflows.deploy.steps[0].script@1.inputs[0].content", Line: 18, Column: 20},
+		}},
+		{Key: "SIGNING_KEY", Source: "secrets-file", References: []EnvReference{
+			{Path: "app.envs[1].SIGNING", Line: 5, Column: 14},
+			{Path: "workflows.deploy.steps[1].deploy@1.inputs[0].key", Line: 22, Column: 16},
+		}},
+		{Key: "SLACK_WEBHOOK", Source: "dotenv:.env", References: []EnvReference{
+			{Path: "workflows.deploy.steps[0].script@1.run_if", Line: 12, Column: 17},
+		}},
+		{Key: "UNUSED_SECRET", Source: "env", References: []EnvReference{}},
+	}, report.Secrets)
+
+	require.Equal(t, []UndefinedReference{
+		{Key: "UPLOAD_URL", References: []EnvReference{{Path: "workflows.deploy.steps[0].script@1.inputs[0].content", Line: 18, Column: 20}}},
+	}, report.Undefined)
+
+	var kinds []string
+	for _, exposure := range report.Exposures {
+		kinds = append(kinds, exposure.Key+":"+exposure.Kind)
+	}
+	require.Equal(t, []string{
+		"API_TOKEN:" + SecretExposureCopiedToEnv,
+		"API_TOKEN:" + SecretExposurePrinted,
+		"API_TOKEN:" + SecretExposureTraced,
+		"SIGNING_KEY:" + SecretExposureNotExpanded,
+	}, kinds)
+	require.Equal(t, `API_TOKEN is printed: echo "token: ${API_TOKEN}"`, report.Exposures[1].Message)
+}
+
+func TestAnalyzeConfigTreeSecretUsage(t *testing.T) {
+	root := wireTreeNode{
+		Path: "bitrise.yml",
+		Contents: `include:
+- path: modules/deploy.yml
+- path: shared.yml
+  repository: https://github.com/org/shared.git
+app:
+  envs:
+  - UPLOAD_URL: https://example.com
+  - SIGNING: $SIGNING_KEY
+`,
+		Includes: []wireTreeNode{
+			{Path: "modules/deploy.yml", Contents: `workflows:
+  deploy:
+    envs:
+    - SIGNING: $API_TOKEN
+      opts:
+        is_sensitive: true
+    steps:
+    - script@1:
+        inputs:
+        - content: 'curl -H "Authorization: $API_TOKEN" $UPLOAD_URL'
+`},
+			{Path: "shared.yml", Error: "not in the include mirror"},
+		},
+	}
+	report, err := analyzeConfigTreeSecretUsage(root, []secretInfo{
+		{Key: "API_TOKEN", Source: "secrets-file", IsExpand: true},
+		{Key: "SIGNING_KEY", Source: "secrets-file", IsExpand: true},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []SecretUsage{
+		{Key: "API_TOKEN", Source: "secrets-file", References: []EnvReference{
+			{File: "modules/deploy.yml", Path: "workflows.deploy.envs[0].SIGNING", Line: 4, Column: 16},
+			{File: "modules/deploy.yml", Path: "workflows.deploy.steps[0].script@1.inputs[0].content", Line: 10, Column: 20},
+		}},
+		{Key: "SIGNING_KEY", Source: "secrets-file", References: []EnvReference{
+			{File: "bitrise.yml", Path: "app.envs[1].SIGNING", Line: 8, Column: 14},
+		}},
+	}, report.Secrets)
+	// UPLOAD_URL is defined by the root config.
+	require.Empty(t, report.Undefined)
+	// The env in deploy.yml is sensitive, the one with the same path in bitrise.yml isn't.
+	require.Len(t, report.Exposures, 1)
+	require.Equal(t, "bitrise.yml", report.Exposures[0].File)
+	require.Equal(t, SecretExposureCopiedToEnv, report.Exposures[0].Kind)
+	require.Equal(t, []string{"shared.yml: include not resolved: not in the include mirror"}, report.Warnings)
+}
+
+func TestPostSecretUsageHandler_modules(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bitrise.yml"), []byte("include:\n- path: modules/deploy.yml\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "modules"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "modules", "deploy.yml"), []byte("app:\n  envs:\n  - TOKEN: $API_TOKEN\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".bitrise.secrets.yml"), []byte("envs:\n- API_TOKEN: secret\n"), 0600))
+	config.BitriseYMLPath = filepath.Join(dir, "bitrise.yml")
+	config.SecretsYMLPath = filepath.Join(dir, ".bitrise.secrets.yml")
+
+	req, err := http.NewRequest("POST", "/api/secrets/usage", bytes.NewBufferString("{}"))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(PostSecretUsageHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var report SecretUsageReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.Empty(t, report.Unused)
+	require.Equal(t, []EnvReference{{File: "modules/deploy.yml", Path: "app.envs[0].TOKEN", Line: 3, Column: 12}}, report.Secrets[0].References)
+}
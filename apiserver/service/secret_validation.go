@@ -0,0 +1,272 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	envmanModels "github.com/bitrise-io/envman/models"
+)
+
+// SecretItemError is a validation error of one item of a posted secrets list.
+type SecretItemError struct {
+	Index int    `json:"index"`
+	Key   string `json:"key,omitempty"`
+	// Field is the invalid part of the item: key, value, opts or opts.<option path>.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e SecretItemError) String() string {
+	if e.Key == "" {
+		return fmt.Sprintf("secret #%d: %s: %s", e.Index+1, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Key, e.Field, e.Message)
+}
+
+// secretsValidationResponse is the error response of a rejected secrets save.
+type secretsValidationResponse struct {
+	Response
+	Errors []SecretItemError `json:"errors"`
+}
+
+const bitriseIOMetaKey = "bitrise.io"
+
+var (
+	boolSecretOptions   = []string{"is_expand", "skip_if_empty", "is_sensitive", "is_required", "is_dont_change_value", "is_template", "unset"}
+	stringSecretOptions = []string{"title", "description", "summary", "category"}
+	// bitriseIOSecretMeta are the known bool flags of the bitrise.io meta; expose is accepted as
+	// an alias of is_expose.
+	bitriseIOSecretMeta = []string{"is_expose", "expose", "is_protected"}
+)
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSecretItems checks the posted secrets item by item: one valid, unique key each, a string
+// value, and well typed envman options. Protected saved secrets can't be unprotected.
+func validateSecretItems(envs []envmanModels.EnvironmentItemModel, saved envmanModels.EnvsSerializeModel) []SecretItemError {
+	protected := map[string]bool{}
+	for _, env := range saved.Envs {
+		key, _, err := env.GetKeyValuePair()
+		if err != nil {
+			continue
+		}
+		if opts, err := env.GetOptions(); err == nil && isProtectedSecret(opts) {
+			protected[key] = true
+		}
+	}
+
+	errs := []SecretItemError{}
+	firstIndex := map[string]int{}
+	for i, env := range envs {
+		fail := func(key, field, format string, v ...interface{}) {
+			errs = append(errs, SecretItemError{Index: i, Key: key, Field: field, Message: fmt.Sprintf(format, v...)})
+		}
+
+		var keys []string
+		for key := range env {
+			if key != envmanModels.OptionsKey {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) != 1 {
+			fail("", "key", "expected exactly one key besides opts, found: %v", keys)
+			continue
+		}
+		key := keys[0]
+
+		if !envKeyPattern.MatchString(key) {
+			fail(key, "key", "must start with a letter or underscore and contain only letters, digits and underscores")
+		}
+		if first, ok := firstIndex[key]; ok {
+			fail(key, "key", "duplicate key, already defined by secret #%d", first+1)
+		} else {
+			firstIndex[key] = i
+		}
+
+		switch env[key].(type) {
+		case string, nil:
+		default:
+			fail(key, "value", "must be a string, got %s", jsonTypeName(env[key]))
+		}
+
+		rawOpts, ok := env[envmanModels.OptionsKey]
+		if !ok || rawOpts == nil {
+			continue
+		}
+		opts, ok := rawOpts.(map[string]interface{})
+		if !ok {
+			fail(key, "opts", "must be an object, got %s", jsonTypeName(rawOpts))
+			continue
+		}
+		for _, optErr := range validateSecretOptions(opts) {
+			fail(key, optErr.field, "%s", optErr.message)
+		}
+
+		// Omitted options keep their saved value, see keepSavedSecretOptions.
+		meta, _ := opts["meta"].(map[string]interface{})
+		if bitriseMeta, ok := meta[bitriseIOMetaKey].(map[string]interface{}); ok && protected[key] {
+			if isProtected, _ := bitriseMeta["is_protected"].(bool); !isProtected {
+				fail(key, "opts.meta.bitrise.io.is_protected", "a protected secret can't be unprotected")
+			}
+		}
+	}
+	return errs
+}
+
+type secretOptionError struct {
+	field   string
+	message string
+}
+
+func validateSecretOptions(opts map[string]interface{}) []secretOptionError {
+	var errs []secretOptionError
+	for _, name := range sortedKeys(opts) {
+		value := opts[name]
+		field := "opts." + name
+		switch {
+		case contains(boolSecretOptions, name):
+			if _, ok := value.(bool); !ok {
+				errs = append(errs, secretOptionError{field, "must be a boolean, got " + jsonTypeName(value)})
+			}
+		case contains(stringSecretOptions, name):
+			if _, ok := value.(string); !ok {
+				errs = append(errs, secretOptionError{field, "must be a string, got " + jsonTypeName(value)})
+			}
+		case name == "value_options":
+			items, ok := value.([]interface{})
+			if !ok {
+				errs = append(errs, secretOptionError{field, "must be a list of strings, got " + jsonTypeName(value)})
+				break
+			}
+			for _, item := range items {
+				if _, ok := item.(string); !ok {
+					errs = append(errs, secretOptionError{field, "must be a list of strings, has " + jsonTypeName(item)})
+					break
+				}
+			}
+		case name == "meta":
+			errs = append(errs, validateSecretMeta(value)...)
+		default:
+			errs = append(errs, secretOptionError{field, "unknown option"})
+		}
+	}
+	return errs
+}
+
+// validateSecretMeta checks the bitrise.io meta; other namespaces belong to other tools.
+func validateSecretMeta(value interface{}) []secretOptionError {
+	meta, ok := value.(map[string]interface{})
+	if !ok {
+		return []secretOptionError{{"opts.meta", "must be an object, got " + jsonTypeName(value)}}
+	}
+	rawBitriseMeta, ok := meta[bitriseIOMetaKey]
+	if !ok || rawBitriseMeta == nil {
+		return nil
+	}
+	bitriseMeta, ok := rawBitriseMeta.(map[string]interface{})
+	if !ok {
+		return []secretOptionError{{"opts.meta.bitrise.io", "must be an object, got " + jsonTypeName(rawBitriseMeta)}}
+	}
+
+	var errs []secretOptionError
+	for _, name := range sortedKeys(bitriseMeta) {
+		field := "opts.meta.bitrise.io." + name
+		if !contains(bitriseIOSecretMeta, name) {
+			errs = append(errs, secretOptionError{field, "unknown option, expected one of: " + strings.Join(bitriseIOSecretMeta, ", ")})
+		} else if _, ok := bitriseMeta[name].(bool); !ok {
+			errs = append(errs, secretOptionError{field, "must be a boolean, got " + jsonTypeName(bitriseMeta[name])})
+		}
+	}
+	return errs
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number, int:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// keepSavedSecretOptions copies the options of the saved secrets the posted items don't mention,
+// so a client that only knows about some options (like the editor) doesn't drop the others.
+// Options are cleared by posting them explicitly.
+func keepSavedSecretOptions(envs []envmanModels.EnvironmentItemModel, saved envmanModels.EnvsSerializeModel) error {
+	savedOpts := map[string]map[string]interface{}{}
+	for _, env := range saved.Envs {
+		key, _, err := env.GetKeyValuePair()
+		if err != nil {
+			return err
+		}
+		opts, err := env.GetOptions()
+		if err != nil {
+			return err
+		}
+		var optsMap map[string]interface{}
+		if err := reencodeJSON(opts, &optsMap); err != nil {
+			return err
+		}
+		savedOpts[key] = optsMap
+	}
+
+	for _, env := range envs {
+		key, _, err := env.GetKeyValuePairWithType()
+		if err != nil {
+			return err
+		}
+		previous, ok := savedOpts[key]
+		if !ok {
+			continue
+		}
+		// The options are either the posted JSON or, once unmasked, the parsed model.
+		opts := map[string]interface{}{}
+		if rawOpts, ok := env[envmanModels.OptionsKey]; ok && rawOpts != nil {
+			if err := reencodeJSON(rawOpts, &opts); err != nil {
+				return err
+			}
+		}
+		for name, value := range previous {
+			if _, ok := opts[name]; !ok {
+				opts[name] = value
+			}
+		}
+		if meta, ok := opts["meta"].(map[string]interface{}); ok {
+			previousMeta, _ := previous["meta"].(map[string]interface{})
+			for namespace, value := range previousMeta {
+				if _, ok := meta[namespace]; !ok {
+					meta[namespace] = value
+				}
+			}
+		}
+		env[envmanModels.OptionsKey] = opts
+	}
+	return nil
+}
+
+func reencodeJSON(in interface{}, out interface{}) error {
+	content, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, out)
+}
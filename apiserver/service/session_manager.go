@@ -0,0 +1,324 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdleTimeout is how long the server waits after the last editor tab is gone before it
+	// shuts down.
+	DefaultIdleTimeout = 5 * time.Second
+	// DefaultHeartbeatTimeout expires a session whose tab stopped sending heartbeats (e.g. crashed).
+	// Tabs send one every 20s, which browsers throttle to about once a minute in hidden tabs; a tab
+	// sends one as soon as it's visible again and disconnects on pagehide, so a tab that was taken
+	// for a crashed one reconnects when it's used again.
+	DefaultHeartbeatTimeout = 2 * time.Minute
+	// DefaultLockLease is how long an edit lock is held without being renewed. The holder renews it
+	// every 20s (about once a minute while hidden), so a crashed tab's lock frees up well before its
+	// session expires.
+	DefaultLockLease = 90 * time.Second
+)
+
+// SessionInfo describes a connected editor tab.
+type SessionInfo struct {
+	ID          string    `json:"id"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// EditLock is an advisory lock a session holds on a resource (a config file path or a tree
+// node id), so other tabs can open it read-only instead of racing on saves. It's a lease: it
+// expires unless the holder renews it before ExpiresAt.
+type EditLock struct {
+	Resource   string    `json:"resource"`
+	SessionID  string    `json:"session_id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SessionManager reference-counts the editor tabs connected to the server and the edit locks they
+// hold. Once every tab has disconnected (or stopped sending heartbeats) and no request is in flight
+// for the idle timeout, onIdle is called, which shuts the server down gracefully. A session's locks
+// are released when it goes away.
+type SessionManager struct {
+	mu               sync.Mutex
+	sessions         map[string]*SessionInfo
+	locks            map[string]EditLock
+	anonymous        int
+	anonymousSeen    time.Time
+	inFlight         int
+	hadSession       bool
+	lastActivity     time.Time
+	idleTimeout      time.Duration
+	heartbeatTimeout time.Duration
+	lockLease        time.Duration
+	onIdle           func()
+	timer            *time.Timer
+	now              func() time.Time
+}
+
+// Sessions is the session manager of the running server.
+var Sessions = NewSessionManager(DefaultIdleTimeout, DefaultHeartbeatTimeout, nil)
+
+// NewSessionManager returns a manager calling onIdle once the server has been without sessions for
+// idleTimeout. An idleTimeout of 0 disables idle shutdown.
+func NewSessionManager(idleTimeout, heartbeatTimeout time.Duration, onIdle func()) *SessionManager {
+	return &SessionManager{
+		sessions:         map[string]*SessionInfo{},
+		locks:            map[string]EditLock{},
+		idleTimeout:      idleTimeout,
+		heartbeatTimeout: heartbeatTimeout,
+		lockLease:        DefaultLockLease,
+		onIdle:           onIdle,
+		now:              time.Now,
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on supported platforms; fall back to a time based id anyway.
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Connect registers a session, or refreshes it when it's already known (heartbeat). An empty id
+// creates a new session. Returns the session id.
+func (m *SessionManager) Connect(id, userAgent string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == "" {
+		id = newSessionID()
+	}
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeen = m.now()
+	} else {
+		m.sessions[id] = &SessionInfo{ID: id, UserAgent: userAgent, ConnectedAt: m.now(), LastSeen: m.now()}
+	}
+	m.hadSession = true
+	m.lastActivity = m.now()
+	m.scheduleLocked()
+	return id
+}
+
+// ConnectAnonymous registers a connection from a client that doesn't identify its session. As its
+// heartbeats can't be told from new connections, each counts as one until the client disconnects;
+// they all expire once none was made for the heartbeat timeout.
+func (m *SessionManager) ConnectAnonymous() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.anonymous++
+	m.anonymousSeen = m.now()
+	m.hadSession = true
+	m.lastActivity = m.now()
+	m.scheduleLocked()
+}
+
+// Disconnect removes a session. An empty id drops an anonymous connection.
+func (m *SessionManager) Disconnect(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == "" {
+		if m.anonymous > 0 {
+			m.anonymous--
+		}
+	} else {
+		m.removeSessionLocked(id)
+	}
+	m.lastActivity = m.now()
+	m.scheduleLocked()
+}
+
+// Count returns the number of live sessions.
+func (m *SessionManager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked()
+	return len(m.sessions) + m.anonymous
+}
+
+// TrackRequests keeps the server alive while requests are in flight, so a final save sent while
+// the last tab closes is never cut off, and counts each request as activity.
+func (m *SessionManager) TrackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		defer func() {
+			m.mu.Lock()
+			m.inFlight--
+			m.lastActivity = m.now()
+			m.scheduleLocked()
+			m.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List returns the live sessions, oldest first.
+func (m *SessionManager) List() []SessionInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked()
+	sessions := make([]SessionInfo, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt) })
+	return sessions
+}
+
+// ErrUnknownSession is returned for lock operations of a session that isn't connected.
+var ErrUnknownSession = errors.New("unknown session")
+
+// AcquireLock grants the session the edit lock on resource unless another session holds it, or
+// renews the lease when the session already holds it. Returns the lock as it stands afterwards and
+// whether the session holds it.
+func (m *SessionManager) AcquireLock(sessionID, resource string) (EditLock, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked()
+	if _, ok := m.sessions[sessionID]; !ok {
+		return EditLock{}, false, ErrUnknownSession
+	}
+
+	lock, ok := m.locks[resource]
+	if ok && lock.SessionID != sessionID {
+		return lock, false, nil
+	}
+	if !ok {
+		lock = EditLock{Resource: resource, SessionID: sessionID, AcquiredAt: m.now()}
+	}
+	lock.ExpiresAt = m.now().Add(m.lockLease)
+	m.locks[resource] = lock
+	return lock, true, nil
+}
+
+// ReleaseLock releases the session's lock on resource; a lock held by another session is kept.
+func (m *SessionManager) ReleaseLock(sessionID, resource string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if lock, ok := m.locks[resource]; ok && lock.SessionID == sessionID {
+		delete(m.locks, resource)
+	}
+}
+
+// Locks returns the held edit locks, ordered by resource.
+func (m *SessionManager) Locks() []EditLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked()
+	locks := make([]EditLock, 0, len(m.locks))
+	for _, lock := range m.locks {
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Resource < locks[j].Resource })
+	return locks
+}
+
+func (m *SessionManager) removeSessionLocked(id string) {
+	delete(m.sessions, id)
+	for resource, lock := range m.locks {
+		if lock.SessionID == id {
+			delete(m.locks, resource)
+		}
+	}
+}
+
+func (m *SessionManager) expireLocked() {
+	if m.anonymous > 0 && m.now().Sub(m.anonymousSeen) > m.heartbeatTimeout {
+		m.anonymous = 0
+	}
+	for id, session := range m.sessions {
+		if m.now().Sub(session.LastSeen) > m.heartbeatTimeout {
+			m.removeSessionLocked(id)
+		}
+	}
+	for resource, lock := range m.locks {
+		if m.now().After(lock.ExpiresAt) {
+			delete(m.locks, resource)
+		}
+	}
+}
+
+// scheduleLocked (re)arms the single timer: while sessions (or anonymous connections) are alive it
+// fires when the oldest heartbeat would expire, otherwise when the idle timeout elapses.
+func (m *SessionManager) scheduleLocked() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if m.idleTimeout <= 0 || m.onIdle == nil || !m.hadSession {
+		return
+	}
+
+	m.expireLocked()
+
+	var wait time.Duration
+	switch {
+	case m.inFlight > 0:
+		// Re-evaluated when the request finishes.
+		return
+	case m.anonymous > 0 || len(m.sessions) > 0:
+		wait = m.heartbeatTimeout
+		if m.anonymous > 0 {
+			wait -= m.now().Sub(m.anonymousSeen)
+		}
+		for _, session := range m.sessions {
+			if expiresIn := m.heartbeatTimeout - m.now().Sub(session.LastSeen); expiresIn < wait {
+				wait = expiresIn
+			}
+		}
+	default:
+		wait = m.idleTimeout - m.now().Sub(m.lastActivity)
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	m.timer = time.AfterFunc(wait, m.check)
+}
+
+func (m *SessionManager) check() {
+	m.mu.Lock()
+	m.expireLocked()
+	idle := m.inFlight == 0 && m.anonymous == 0 && len(m.sessions) == 0 &&
+		m.now().Sub(m.lastActivity) >= m.idleTimeout
+	if !idle {
+		m.scheduleLocked()
+		m.mu.Unlock()
+		return
+	}
+	m.timer = nil
+	onIdle := m.onIdle
+	m.mu.Unlock()
+
+	onIdle()
+}
+
+// connectionRequestModel is the optional body of the /api/connection calls.
+type connectionRequestModel struct {
+	SessionID string `json:"session_id"`
+}
+
+type connectionResponseModel struct {
+	SessionID string `json:"session_id,omitempty"`
+	Sessions  int    `json:"sessions"`
+}
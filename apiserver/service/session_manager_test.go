@@ -0,0 +1,181 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManager(t *testing.T) {
+	var idleCalls int32
+	m := NewSessionManager(30*time.Millisecond, time.Hour, func() { atomic.AddInt32(&idleCalls, 1) })
+
+	t.Log("no shutdown before the first tab connects")
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&idleCalls))
+
+	t.Log("one tab closing doesn't shut down while another is connected")
+	a := m.Connect("", "")
+	b := m.Connect("tab-b", "")
+	require.NotEmpty(t, a)
+	require.Equal(t, "tab-b", b)
+	require.Equal(t, 2, m.Count())
+
+	m.Disconnect(a)
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&idleCalls))
+	require.Equal(t, 1, m.Count())
+
+	t.Log("in-flight requests hold off the shutdown")
+	release := make(chan struct{})
+	handler := m.TrackRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/bitrise-yml", nil))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	m.Disconnect(b)
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&idleCalls))
+
+	close(release)
+	<-done
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&idleCalls) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestSessionManager_heartbeatExpiry(t *testing.T) {
+	idle := make(chan struct{})
+	m := NewSessionManager(10*time.Millisecond, 30*time.Millisecond, func() { close(idle) })
+
+	m.Connect("crashed-tab", "")
+	require.Equal(t, 1, m.Count())
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("expected shutdown after the session stopped sending heartbeats")
+	}
+	require.Equal(t, 0, m.Count())
+}
+
+func TestSessionManager_anonymousExpiry(t *testing.T) {
+	idle := make(chan struct{})
+	m := NewSessionManager(10*time.Millisecond, 30*time.Millisecond, func() { close(idle) })
+
+	t.Log("anonymous connections expire without heartbeats too")
+	m.ConnectAnonymous()
+	m.ConnectAnonymous()
+	require.Equal(t, 2, m.Count())
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("expected shutdown after the anonymous connections stopped sending heartbeats")
+	}
+	require.Equal(t, 0, m.Count())
+}
+
+func TestPostConnectionHandler(t *testing.T) {
+	Sessions = NewSessionManager(0, time.Hour, nil)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/connection", strings.NewReader(`{"session_id":"tab-1"}`))
+	http.HandlerFunc(PostConnectionHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "{\"session_id\":\"tab-1\",\"sessions\":1}\n", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/api/connection", nil)
+	req.Header.Set(SessionIDHeader, "tab-1")
+	http.HandlerFunc(DeleteConnectionHandler).ServeHTTP(rr, req)
+	require.Equal(t, "{\"sessions\":0}\n", rr.Body.String())
+}
+
+func TestSessionManager_editLocks(t *testing.T) {
+	m := NewSessionManager(0, time.Hour, nil)
+	m.Connect("tab-a", "")
+	m.Connect("tab-b", "")
+
+	_, _, err := m.AcquireLock("unknown", "bitrise.yml")
+	require.ErrorIs(t, err, ErrUnknownSession)
+
+	lock, granted, err := m.AcquireLock("tab-a", "bitrise.yml")
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Equal(t, "tab-a", lock.SessionID)
+
+	t.Log("a second tab gets the current holder instead of the lock")
+	lock, granted, err = m.AcquireLock("tab-b", "bitrise.yml")
+	require.NoError(t, err)
+	require.False(t, granted)
+	require.Equal(t, "tab-a", lock.SessionID)
+
+	t.Log("only the holder can release")
+	m.ReleaseLock("tab-b", "bitrise.yml")
+	require.Len(t, m.Locks(), 1)
+
+	t.Log("disconnecting releases the session's locks")
+	m.Disconnect("tab-a")
+	require.Empty(t, m.Locks())
+	_, granted, err = m.AcquireLock("tab-b", "bitrise.yml")
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Len(t, m.List(), 1)
+}
+
+func TestSessionManager_editLockLease(t *testing.T) {
+	now := time.Now()
+	m := NewSessionManager(0, time.Hour, nil)
+	m.now = func() time.Time { return now }
+	m.Connect("tab-a", "")
+	m.Connect("tab-b", "")
+
+	lock, granted, err := m.AcquireLock("tab-a", "bitrise.yml")
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Equal(t, now.Add(DefaultLockLease), lock.ExpiresAt)
+
+	t.Log("the holder renews the lease")
+	now = now.Add(DefaultLockLease - time.Second)
+	lock, granted, err = m.AcquireLock("tab-a", "bitrise.yml")
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Equal(t, now.Add(DefaultLockLease), lock.ExpiresAt)
+
+	t.Log("a lease that isn't renewed expires while the session is still alive")
+	now = now.Add(DefaultLockLease + time.Second)
+	require.Empty(t, m.Locks())
+	lock, granted, err = m.AcquireLock("tab-b", "bitrise.yml")
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Equal(t, "tab-b", lock.SessionID)
+	require.Len(t, m.List(), 2)
+}
+
+func TestPostEditLockHandler(t *testing.T) {
+	Sessions = NewSessionManager(0, time.Hour, nil)
+	Sessions.Connect("tab-a", "")
+	Sessions.Connect("tab-b", "")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/locks", strings.NewReader(body))
+		http.HandlerFunc(PostEditLockHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, post(`{"session_id":"tab-a","resource":"n_root"}`).Code)
+	rr := post(`{"session_id":"tab-b","resource":"n_root"}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+	require.Contains(t, rr.Body.String(), `"session_id":"tab-a"`)
+	require.Equal(t, http.StatusBadRequest, post(`{"session_id":"tab-a"}`).Code)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/stacks"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// GetStacksAndMachinesHandler serves the stacks and machine types catalog, in the shape of the
+// hosted stacks_and_machines endpoint. The catalog is read on every request, so edits to a
+// --stacks-catalog file show up without a restart.
+func GetStacksAndMachinesHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := stacks.Load(config.StacksCatalogPath)
+	if err != nil {
+		log.Errorf("Failed to load stacks catalog, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to load stacks catalog, error: %s", err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, catalog)
+}
+
+// withStackWarnings adds the stack and machine type problems of the config to its warnings. The
+// catalog is advisory: if it can't be loaded or the meta can't be read, nothing is added.
+func withStackWarnings(warnings *utility.WarningItems, bitriseYML string) *utility.WarningItems {
+	catalog, err := stacks.Load(config.StacksCatalogPath)
+	if err != nil {
+		log.Warnf("Failed to load stacks catalog, error: %s", err)
+		return warnings
+	}
+	stackWarnings, err := catalog.Warnings(bitriseYML)
+	if err != nil {
+		log.Warnf("Failed to check stacks, error: %s", err)
+		return warnings
+	}
+	if len(stackWarnings) == 0 {
+		return warnings
+	}
+	if warnings == nil {
+		warnings = &utility.WarningItems{}
+	}
+	warnings.Config = append(warnings.Config, stackWarnings...)
+	return warnings
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/stacks"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStacksAndMachinesHandler(t *testing.T) {
+	t.Log("serves the bundled catalog")
+	{
+		req, err := http.NewRequest("GET", "/api/stacks-and-machines", nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetStacksAndMachinesHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var catalog stacks.Catalog
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &catalog))
+		require.NotEmpty(t, catalog.DefaultStackID)
+		require.NotEmpty(t, catalog.GroupedStacks)
+		require.NotEmpty(t, catalog.GroupedMachines)
+	}
+
+	t.Log("serves the configured catalog file")
+	{
+		pth := filepath.Join(t.TempDir(), "stacks.json")
+		require.NoError(t, os.WriteFile(pth, []byte(`{"default_stack_id":"custom","default_machines":[],"grouped_stacks":[]}`), 0644))
+		config.StacksCatalogPath = pth
+		defer func() { config.StacksCatalogPath = "" }()
+
+		req, err := http.NewRequest("GET", "/api/stacks-and-machines", nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetStacksAndMachinesHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var catalog stacks.Catalog
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &catalog))
+		require.Equal(t, "custom", catalog.DefaultStackID)
+	}
+}
+
+func TestWithStackWarnings(t *testing.T) {
+	t.Log("adds the stack warnings to the config warnings")
+	{
+		warnings := withStackWarnings(&utility.WarningItems{Config: []string{"existing"}}, `format_version: "13"
+meta:
+  bitrise.io:
+    stack: osx-xcode-12.5.x
+`)
+		require.Equal(t, []string{"existing", "meta.bitrise.io.stack: unknown stack osx-xcode-12.5.x"}, warnings.Config)
+	}
+
+	t.Log("no warnings")
+	{
+		require.Nil(t, withStackWarnings(nil, "format_version: \"13\"\n"))
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/templates"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+type templatesResponseModel struct {
+	Templates []templates.Template `json:"templates"`
+}
+
+type renderTemplateRequestModel struct {
+	TemplateID string            `json:"template_id"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+// GetTemplatesHandler lists the starter config templates and their parameters.
+func GetTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, templatesResponseModel{Templates: templates.List()})
+}
+
+// PostRenderTemplateHandler renders a starter config template with the given parameters. The
+// rendered config is returned, not saved; the editor saves it through /api/bitrise-yml.
+func PostRenderTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj renderTemplateRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+
+	content, err := templates.Render(reqObj.TemplateID, reqObj.Parameters)
+	if err != nil {
+		log.Errorf("Failed to render template, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to render template, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, Response{BitriseYML: content})
+}
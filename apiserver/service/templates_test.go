@@ -0,0 +1,38 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostRenderTemplateHandler(t *testing.T) {
+	t.Log("renders the template")
+	{
+		req, err := http.NewRequest("POST", "/api/templates/render", bytes.NewBufferString(`{"template_id":"android","parameters":{"module":"mobile"}}`))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostRenderTemplateHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Contains(t, resp.BitriseYML, "- MODULE: mobile\n")
+	}
+
+	t.Log("unknown template")
+	{
+		req, err := http.NewRequest("POST", "/api/templates/render", bytes.NewBufferString(`{"template_id":"cordova"}`))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PostRenderTemplateHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+		require.Equal(t, "{\"error\":\"Failed to render template, error: unknown template: cordova\"}\n", rr.Body.String())
+	}
+}
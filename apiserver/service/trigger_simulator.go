@@ -0,0 +1,517 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"gopkg.in/yaml.v2"
+)
+
+// Trigger event types.
+const (
+	TriggerEventPush        = "push"
+	TriggerEventPullRequest = "pull_request"
+	TriggerEventTag         = "tag"
+)
+
+// TriggerEvent is a synthetic git event to evaluate the config's triggers against.
+type TriggerEvent struct {
+	Type          string   `json:"type"`
+	Branch        string   `json:"branch,omitempty"`
+	SourceBranch  string   `json:"source_branch,omitempty"`
+	TargetBranch  string   `json:"target_branch,omitempty"`
+	Tag           string   `json:"tag,omitempty"`
+	ChangedFiles  []string `json:"changed_files,omitempty"`
+	CommitMessage string   `json:"commit_message,omitempty"`
+	IsDraftPR     bool     `json:"is_draft_pr,omitempty"`
+	Labels        []string `json:"labels,omitempty"`
+	Comment       string   `json:"comment,omitempty"`
+}
+
+// TriggerMatch is the result of evaluating one trigger against the event.
+type TriggerMatch struct {
+	// Source locates the trigger, e.g. `trigger_map[2]` or `workflows.primary.triggers.push[0]`.
+	Source     string `json:"source"`
+	TargetType string `json:"target_type"`
+	Target     string `json:"target"`
+	Matched    bool   `json:"matched"`
+	// Reasons explain the result: the conditions that matched, or the one that didn't.
+	Reasons []string `json:"reasons"`
+}
+
+// TriggerSimulation reports which workflows and pipelines the event would start.
+type TriggerSimulation struct {
+	Event TriggerEvent `json:"event"`
+	// Started are the triggers starting a build: the first matching trigger_map item, and every
+	// workflow or pipeline with a matching target-based trigger.
+	Started []TriggerMatch `json:"started"`
+	// Evaluated are all triggers in evaluation order.
+	Evaluated []TriggerMatch `json:"evaluated"`
+	Warnings  []string       `json:"warnings"`
+}
+
+// triggerPattern is a trigger condition value: a glob string or `{regex: ...}`.
+type triggerPattern struct {
+	raw   string
+	regex bool
+	re    *regexp.Regexp
+}
+
+func (p triggerPattern) String() string {
+	if p.regex {
+		return fmt.Sprintf("regex %q", p.raw)
+	}
+	return fmt.Sprintf("%q", p.raw)
+}
+
+func (p triggerPattern) match(value string) bool {
+	return p.re.MatchString(value)
+}
+
+// parseTriggerPattern parses a condition value; path globs (changed_files) don't match `/` with a
+// single `*`, while `**` matches across directories.
+func parseTriggerPattern(value interface{}, path bool) (*triggerPattern, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		re, err := regexp.Compile(globToRegexp(v, path))
+		if err != nil {
+			return nil, err
+		}
+		return &triggerPattern{raw: v, re: re}, nil
+	case map[interface{}]interface{}:
+		expression, ok := v["regex"].(string)
+		if !ok {
+			return nil, fmt.Errorf("pattern should be a string or have a regex key: %v", v)
+		}
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", expression, err)
+		}
+		return &triggerPattern{raw: expression, regex: true, re: re}, nil
+	default:
+		return nil, fmt.Errorf("pattern should be a string or have a regex key: %v", v)
+	}
+}
+
+func globToRegexp(glob string, path bool) string {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			if path && i+1 < len(glob) && glob[i+1] == '/' {
+				// `**/` matches zero or more directories.
+				i++
+				re.WriteString("(.*/)?")
+			} else {
+				re.WriteString(".*")
+			}
+		case c == '*':
+			if path {
+				re.WriteString("[^/]*")
+			} else {
+				re.WriteString(".*")
+			}
+		case c == '?':
+			if path {
+				re.WriteString("[^/]")
+			} else {
+				re.WriteString(".")
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// triggerCondition is a named condition of a trigger and the event values it's checked against.
+type triggerCondition struct {
+	name    string
+	pattern interface{}
+	path    bool
+	values  []string
+}
+
+// checkConditions reports whether every set condition matches at least one of its values.
+func checkConditions(conditions []triggerCondition) (bool, []string, error) {
+	var reasons []string
+	for _, condition := range conditions {
+		pattern, err := parseTriggerPattern(condition.pattern, condition.path)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s: %w", condition.name, err)
+		}
+		if pattern == nil {
+			continue
+		}
+
+		if len(condition.values) == 0 {
+			return false, []string{fmt.Sprintf("%s %s is set, but the event has none", condition.name, pattern)}, nil
+		}
+		matched := ""
+		for _, value := range condition.values {
+			if pattern.match(value) {
+				matched = value
+				break
+			}
+		}
+		if matched == "" {
+			return false, []string{fmt.Sprintf("%s %s doesn't match %s", condition.name, pattern, quoteAll(condition.values))}, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %s matches %q", condition.name, pattern, matched))
+	}
+	return true, reasons, nil
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, value := range values {
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+type legacyTriggerItem struct {
+	Type                    string      `yaml:"type"`
+	Enabled                 *bool       `yaml:"enabled"`
+	Workflow                string      `yaml:"workflow"`
+	Pipeline                string      `yaml:"pipeline"`
+	Pattern                 string      `yaml:"pattern"`
+	IsPullRequestAllowed    bool        `yaml:"is_pull_request_allowed"`
+	PushBranch              interface{} `yaml:"push_branch"`
+	Tag                     interface{} `yaml:"tag"`
+	PullRequestSourceBranch interface{} `yaml:"pull_request_source_branch"`
+	PullRequestTargetBranch interface{} `yaml:"pull_request_target_branch"`
+	PullRequestLabel        interface{} `yaml:"pull_request_label"`
+	PullRequestComment      interface{} `yaml:"pull_request_comment"`
+	CommitMessage           interface{} `yaml:"commit_message"`
+	ChangedFiles            interface{} `yaml:"changed_files"`
+	DraftPullRequestEnabled *bool       `yaml:"draft_pull_request_enabled"`
+}
+
+func (item legacyTriggerItem) eventType() string {
+	switch {
+	case item.Type != "":
+		return item.Type
+	case item.PushBranch != nil:
+		return TriggerEventPush
+	case item.Tag != nil:
+		return TriggerEventTag
+	case item.PullRequestSourceBranch != nil || item.PullRequestTargetBranch != nil || item.PullRequestLabel != nil || item.PullRequestComment != nil:
+		return TriggerEventPullRequest
+	}
+	return ""
+}
+
+// evaluateLegacyTrigger evaluates a trigger_map item.
+func evaluateLegacyTrigger(item legacyTriggerItem, event TriggerEvent) (bool, []string, error) {
+	if item.Enabled != nil && !*item.Enabled {
+		return false, []string{"the trigger is disabled"}, nil
+	}
+
+	// The deprecated `pattern` matches push branches, and PR source branches if allowed.
+	if item.Pattern != "" {
+		switch {
+		case event.Type == TriggerEventPush:
+			return checkConditions([]triggerCondition{{name: "pattern", pattern: item.Pattern, values: nonEmpty(event.Branch)}})
+		case event.Type == TriggerEventPullRequest && item.IsPullRequestAllowed:
+			return checkConditions([]triggerCondition{{name: "pattern", pattern: item.Pattern, values: nonEmpty(event.SourceBranch)}})
+		}
+		return false, []string{fmt.Sprintf("pattern triggers don't handle %s events", event.Type)}, nil
+	}
+
+	if itemType := item.eventType(); itemType != event.Type {
+		return false, []string{fmt.Sprintf("%s trigger, not a %s event", itemType, event.Type)}, nil
+	}
+
+	var conditions []triggerCondition
+	switch event.Type {
+	case TriggerEventPush:
+		conditions = []triggerCondition{{name: "push_branch", pattern: item.PushBranch, values: nonEmpty(event.Branch)}}
+	case TriggerEventTag:
+		conditions = []triggerCondition{{name: "tag", pattern: item.Tag, values: nonEmpty(event.Tag)}}
+	case TriggerEventPullRequest:
+		if event.IsDraftPR && item.DraftPullRequestEnabled != nil && !*item.DraftPullRequestEnabled {
+			return false, []string{"draft pull requests are disabled (draft_pull_request_enabled: false)"}, nil
+		}
+		conditions = []triggerCondition{
+			{name: "pull_request_source_branch", pattern: item.PullRequestSourceBranch, values: nonEmpty(event.SourceBranch)},
+			{name: "pull_request_target_branch", pattern: item.PullRequestTargetBranch, values: nonEmpty(event.TargetBranch)},
+			{name: "pull_request_label", pattern: item.PullRequestLabel, values: event.Labels},
+			{name: "pull_request_comment", pattern: item.PullRequestComment, values: nonEmpty(event.Comment)},
+		}
+	}
+	conditions = append(conditions,
+		triggerCondition{name: "commit_message", pattern: item.CommitMessage, values: nonEmpty(event.CommitMessage)},
+		triggerCondition{name: "changed_files", pattern: item.ChangedFiles, path: true, values: event.ChangedFiles},
+	)
+	return checkConditions(conditions)
+}
+
+type targetTriggersModel struct {
+	Enabled     *bool                    `yaml:"enabled"`
+	Push        []map[string]interface{} `yaml:"push"`
+	PullRequest []map[string]interface{} `yaml:"pull_request"`
+	Tag         []map[string]interface{} `yaml:"tag"`
+}
+
+type targetTriggerItem struct {
+	Enabled       *bool       `yaml:"enabled"`
+	Branch        interface{} `yaml:"branch"`
+	SourceBranch  interface{} `yaml:"source_branch"`
+	TargetBranch  interface{} `yaml:"target_branch"`
+	Label         interface{} `yaml:"label"`
+	Comment       interface{} `yaml:"comment"`
+	Name          interface{} `yaml:"name"`
+	CommitMessage interface{} `yaml:"commit_message"`
+	ChangedFiles  interface{} `yaml:"changed_files"`
+	DraftEnabled  *bool       `yaml:"draft_enabled"`
+}
+
+// evaluateTargetTrigger evaluates an item of a workflow's or pipeline's `triggers` block.
+func evaluateTargetTrigger(itemType string, item targetTriggerItem, event TriggerEvent) (bool, []string, error) {
+	if item.Enabled != nil && !*item.Enabled {
+		return false, []string{"the trigger is disabled"}, nil
+	}
+	if itemType != event.Type {
+		return false, []string{fmt.Sprintf("%s trigger, not a %s event", itemType, event.Type)}, nil
+	}
+
+	var conditions []triggerCondition
+	switch itemType {
+	case TriggerEventPush:
+		conditions = []triggerCondition{{name: "branch", pattern: item.Branch, values: nonEmpty(event.Branch)}}
+	case TriggerEventTag:
+		conditions = []triggerCondition{{name: "name", pattern: item.Name, values: nonEmpty(event.Tag)}}
+	case TriggerEventPullRequest:
+		if event.IsDraftPR && item.DraftEnabled != nil && !*item.DraftEnabled {
+			return false, []string{"draft pull requests are disabled (draft_enabled: false)"}, nil
+		}
+		conditions = []triggerCondition{
+			{name: "source_branch", pattern: item.SourceBranch, values: nonEmpty(event.SourceBranch)},
+			{name: "target_branch", pattern: item.TargetBranch, values: nonEmpty(event.TargetBranch)},
+			{name: "label", pattern: item.Label, values: event.Labels},
+			{name: "comment", pattern: item.Comment, values: nonEmpty(event.Comment)},
+		}
+	}
+	conditions = append(conditions,
+		triggerCondition{name: "commit_message", pattern: item.CommitMessage, values: nonEmpty(event.CommitMessage)},
+		triggerCondition{name: "changed_files", pattern: item.ChangedFiles, path: true, values: event.ChangedFiles},
+	)
+	return checkConditions(conditions)
+}
+
+type triggerConfigModel struct {
+	TriggerMap []map[string]interface{} `yaml:"trigger_map"`
+	Workflows  map[string]struct {
+		Triggers *targetTriggersModel `yaml:"triggers"`
+	} `yaml:"workflows"`
+	Pipelines map[string]struct {
+		Triggers *targetTriggersModel `yaml:"triggers"`
+	} `yaml:"pipelines"`
+}
+
+// SimulateTriggers evaluates the trigger_map and the target-based triggers of a config against
+// an event.
+func SimulateTriggers(contStr string, event TriggerEvent) (TriggerSimulation, error) {
+	switch event.Type {
+	case TriggerEventPush, TriggerEventPullRequest, TriggerEventTag:
+	default:
+		return TriggerSimulation{}, fmt.Errorf("unknown event type %q, expected one of: push, pull_request, tag", event.Type)
+	}
+
+	var cfg triggerConfigModel
+	if err := yaml.Unmarshal([]byte(contStr), &cfg); err != nil {
+		return TriggerSimulation{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	simulation := TriggerSimulation{Event: event, Started: []TriggerMatch{}, Evaluated: []TriggerMatch{}, Warnings: []string{}}
+
+	// trigger_map: the first matching item wins.
+	var first *TriggerMatch
+	for i, raw := range cfg.TriggerMap {
+		var item legacyTriggerItem
+		if err := reencode(raw, &item); err != nil {
+			return TriggerSimulation{}, fmt.Errorf("trigger_map[%d]: %w", i, err)
+		}
+
+		match := TriggerMatch{Source: fmt.Sprintf("trigger_map[%d]", i), TargetType: "workflow", Target: item.Workflow}
+		if item.Pipeline != "" {
+			match.TargetType, match.Target = "pipeline", item.Pipeline
+		}
+
+		matched, reasons, err := evaluateLegacyTrigger(item, event)
+		if err != nil {
+			return TriggerSimulation{}, fmt.Errorf("%s: %w", match.Source, err)
+		}
+		match.Matched, match.Reasons = matched, reasons
+		if matched && first != nil {
+			match.Matched = false
+			match.Reasons = append(match.Reasons, fmt.Sprintf("shadowed by %s, the first matching item", first.Source))
+		}
+		if match.Reasons == nil {
+			match.Reasons = []string{"the trigger has no conditions"}
+		}
+
+		simulation.Evaluated = append(simulation.Evaluated, match)
+		if match.Matched {
+			first = &simulation.Evaluated[len(simulation.Evaluated)-1]
+			simulation.Started = append(simulation.Started, match)
+		}
+	}
+
+	// Target-based triggers: every workflow and pipeline with a matching item starts.
+	hasTargetTriggers := false
+	evaluateTargets := func(targetType string, ids []string, triggersOf func(string) *targetTriggersModel) error {
+		for _, id := range ids {
+			triggers := triggersOf(id)
+			if triggers == nil {
+				continue
+			}
+			hasTargetTriggers = true
+
+			started := false
+			for _, group := range []struct {
+				itemType string
+				items    []map[string]interface{}
+			}{{TriggerEventPush, triggers.Push}, {TriggerEventPullRequest, triggers.PullRequest}, {TriggerEventTag, triggers.Tag}} {
+				for i, raw := range group.items {
+					var item targetTriggerItem
+					if err := reencode(raw, &item); err != nil {
+						return fmt.Errorf("%ss.%s.triggers.%s[%d]: %w", targetType, id, group.itemType, i, err)
+					}
+
+					match := TriggerMatch{Source: fmt.Sprintf("%ss.%s.triggers.%s[%d]", targetType, id, group.itemType, i), TargetType: targetType, Target: id}
+					if triggers.Enabled != nil && !*triggers.Enabled {
+						match.Reasons = []string{fmt.Sprintf("the triggers of %s %s are disabled", targetType, id)}
+					} else {
+						matched, reasons, err := evaluateTargetTrigger(group.itemType, item, event)
+						if err != nil {
+							return fmt.Errorf("%s: %w", match.Source, err)
+						}
+						match.Matched, match.Reasons = matched, reasons
+						if match.Reasons == nil {
+							match.Reasons = []string{fmt.Sprintf("any %s event matches", group.itemType)}
+						}
+					}
+
+					simulation.Evaluated = append(simulation.Evaluated, match)
+					if match.Matched && !started {
+						started = true
+						simulation.Started = append(simulation.Started, match)
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := evaluateTargets("workflow", sortedKeys(cfg.Workflows), func(id string) *targetTriggersModel { return cfg.Workflows[id].Triggers }); err != nil {
+		return TriggerSimulation{}, err
+	}
+	if err := evaluateTargets("pipeline", sortedKeys(cfg.Pipelines), func(id string) *targetTriggersModel { return cfg.Pipelines[id].Triggers }); err != nil {
+		return TriggerSimulation{}, err
+	}
+
+	if len(cfg.TriggerMap) > 0 && hasTargetTriggers {
+		simulation.Warnings = append(simulation.Warnings, "The config has both a trigger_map and target-based triggers; both are evaluated, which can start several builds for one event")
+	}
+	return simulation, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ReadMergedConfig returns the config at pth with its includes merged, resolved the same way as
+// for the editor's config tree. A config with unresolved includes is an error, as simulating a
+// partial config would be misleading.
+func ReadMergedConfig(pth string) (string, error) {
+	contStr, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return "", err
+	}
+	root, merged, err := resolveConfigTree(pth, contStr)
+	if err != nil {
+		return "", err
+	}
+	if unresolved := unresolvedIncludes(root); len(unresolved) > 0 {
+		return "", fmt.Errorf("some includes couldn't be resolved: %s", strings.Join(unresolved, ", "))
+	}
+	return merged, nil
+}
+
+type simulateTriggersRequestModel struct {
+	TriggerEvent
+	// BitriseYML is the editor's (possibly unsaved) config; the saved config is used if empty.
+	BitriseYML string `json:"bitrise_yml"`
+}
+
+// PostSimulateTriggersHandler reports which workflows and pipelines a synthetic event would start.
+func PostSimulateTriggersHandler(w http.ResponseWriter, r *http.Request) {
+	project := projectFor(r)
+
+	if r.Body == nil {
+		log.Errorf("Empty request body")
+		RespondWithJSONBadRequestErrorMessage(w, "Empty request body")
+		return
+	}
+
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Errorf("Failed to close request body, error: %s", err)
+		}
+	}()
+
+	var reqObj simulateTriggersRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+		log.Errorf("Failed to read JSON input, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to read JSON input, error: %s", err)
+		return
+	}
+
+	contStr := reqObj.BitriseYML
+	if contStr == "" {
+		var err error
+		if contStr, err = ReadMergedConfig(project.BitriseYMLPath); err != nil {
+			log.Errorf("Failed to merge bitrise.yml (%s), error: %s", project.BitriseYMLPath, err)
+			RespondWithJSONBadRequestErrorMessage(w, "Failed to merge bitrise.yml, error: %s", err)
+			return
+		}
+	}
+
+	simulation, err := SimulateTriggers(contStr, reqObj.TriggerEvent)
+	if err != nil {
+		log.Errorf("Failed to simulate triggers, error: %s", err)
+		RespondWithJSONBadRequestErrorMessage(w, "Failed to simulate triggers, error: %s", err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, simulation)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/stretchr/testify/require"
+)
+
+const triggerTestConfig = `format_version: "13"
+trigger_map:
+- push_branch: main
+  workflow: deploy
+- push_branch: "*"
+  changed_files:
+    regex: '^docs/'
+  enabled: false
+  workflow: docs
+- push_branch: "*"
+  workflow: primary
+- pull_request_source_branch: "*"
+  pull_request_target_branch: main
+  draft_pull_request_enabled: false
+  workflow: primary
+- tag: "v*"
+  pipeline: release
+workflows:
+  deploy: {}
+  docs: {}
+  primary: {}
+  ios:
+    triggers:
+      push:
+      - branch: "release/*"
+        changed_files: "ios/**"
+      pull_request:
+      - target_branch:
+          regex: '^(main|develop)$'
+        label: ios
+pipelines:
+  release:
+    triggers:
+      tag:
+      - name:
+          regex: '^v\d+\.\d+\.\d+$'
+        commit_message: "*[release]*"
+`
+
+func startedTargets(simulation TriggerSimulation) []string {
+	var targets []string
+	for _, match := range simulation.Started {
+		targets = append(targets, match.TargetType+":"+match.Target)
+	}
+	return targets
+}
+
+func TestSimulateTriggers(t *testing.T) {
+	t.Log("first matching trigger_map item wins")
+	{
+		simulation, err := SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventPush, Branch: "main"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"workflow:deploy"}, startedTargets(simulation))
+		require.Equal(t, []string{`push_branch "main" matches "main"`}, simulation.Started[0].Reasons)
+		require.Equal(t, "trigger_map[2]", simulation.Evaluated[2].Source)
+		require.False(t, simulation.Evaluated[2].Matched)
+		require.Contains(t, simulation.Evaluated[2].Reasons, "shadowed by trigger_map[0], the first matching item")
+		require.Equal(t, []string{"the trigger is disabled"}, simulation.Evaluated[1].Reasons)
+		require.Len(t, simulation.Warnings, 1)
+	}
+
+	t.Log("changed_files path globs and target-based push triggers")
+	{
+		simulation, err := SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventPush, Branch: "release/1.0", ChangedFiles: []string{"README.md", "ios/App/App.swift"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"workflow:primary", "workflow:ios"}, startedTargets(simulation))
+		require.Equal(t, []string{`branch "release/*" matches "release/1.0"`, `changed_files "ios/**" matches "ios/App/App.swift"`}, simulation.Started[1].Reasons)
+
+		simulation, err = SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventPush, Branch: "release/1.0", ChangedFiles: []string{"android/app/build.gradle"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"workflow:primary"}, startedTargets(simulation))
+	}
+
+	t.Log("pull requests: draft flag, regex and label conditions")
+	{
+		simulation, err := SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventPullRequest, SourceBranch: "feature/a", TargetBranch: "main", Labels: []string{"ios"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"workflow:primary", "workflow:ios"}, startedTargets(simulation))
+
+		simulation, err = SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventPullRequest, SourceBranch: "feature/a", TargetBranch: "develop", IsDraftPR: true})
+		require.NoError(t, err)
+		require.Empty(t, simulation.Started)
+		require.Equal(t, []string{"draft pull requests are disabled (draft_pull_request_enabled: false)"}, simulation.Evaluated[3].Reasons)
+
+		last := simulation.Evaluated[len(simulation.Evaluated)-2]
+		require.Equal(t, "workflows.ios.triggers.pull_request[0]", last.Source)
+		require.Equal(t, []string{`label "ios" is set, but the event has none`}, last.Reasons)
+	}
+
+	t.Log("tags with commit message conditions")
+	{
+		simulation, err := SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventTag, Tag: "v1.2.0", CommitMessage: "Bump version [release]"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"pipeline:release", "pipeline:release"}, startedTargets(simulation))
+		require.Equal(t, "pipelines.release.triggers.tag[0]", simulation.Started[1].Source)
+
+		simulation, err = SimulateTriggers(triggerTestConfig, TriggerEvent{Type: TriggerEventTag, Tag: "v1.2"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"pipeline:release"}, startedTargets(simulation))
+		require.Equal(t, "trigger_map[4]", simulation.Started[0].Source)
+	}
+
+	t.Log("invalid input")
+	{
+		_, err := SimulateTriggers(triggerTestConfig, TriggerEvent{Type: "merge"})
+		require.EqualError(t, err, `unknown event type "merge", expected one of: push, pull_request, tag`)
+
+		_, err = SimulateTriggers("trigger_map:\n- push_branch:\n    regex: '('\n  workflow: primary\n", TriggerEvent{Type: TriggerEventPush, Branch: "main"})
+		require.Error(t, err)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		glob    string
+		path    bool
+		value   string
+		matches bool
+	}{
+		{glob: "feature/*", value: "feature/a/b", matches: true},
+		{glob: "*.md", path: true, value: "docs/README.md", matches: false},
+		{glob: "**/*.md", path: true, value: "README.md", matches: true},
+		{glob: "**/*.md", path: true, value: "docs/a/README.md", matches: true},
+		{glob: "src/?.go", path: true, value: "src/a.go", matches: true},
+		{glob: "v1.0", value: "v1x0", matches: false},
+	} {
+		pattern, err := parseTriggerPattern(tc.glob, tc.path)
+		require.NoError(t, err)
+		require.Equal(t, tc.matches, pattern.match(tc.value), "%s ~ %s", tc.glob, tc.value)
+	}
+}
+
+func TestReadMergedConfig(t *testing.T) {
+	repoRoot := t.TempDir()
+	mirrorDir := t.TempDir()
+	createMirror(t, mirrorDir, "shared-modules", map[string]string{"shared/deploy.yml": "workflows:\n  deploy: {}\n"})
+	config.IncludeMirrorDir = mirrorDir
+	defer func() { config.IncludeMirrorDir = "" }()
+
+	pth := filepath.Join(repoRoot, "bitrise.yml")
+	require.NoError(t, fileutil.WriteStringToFile(pth, "format_version: \"13\"\ninclude:\n  - path: shared/deploy.yml\n    repository: shared-modules\n    branch: main\n"))
+	merged, err := ReadMergedConfig(pth)
+	require.NoError(t, err)
+	require.Contains(t, merged, "deploy: {}")
+
+	t.Log("unresolved includes")
+	require.NoError(t, fileutil.WriteStringToFile(pth, "format_version: \"13\"\ninclude:\n  - path: modules/missing.yml\n"))
+	_, err = ReadMergedConfig(pth)
+	require.ErrorContains(t, err, "some includes couldn't be resolved: modules/missing.yml: include not resolved")
+}
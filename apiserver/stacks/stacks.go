@@ -0,0 +1,228 @@
+package stacks
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The bundled catalog is a snapshot of the hosted stacks_and_machines response, so the editor's
+// pickers work offline; it's replaced by an updated copy with --stacks-catalog.
+//
+//go:embed catalog.json
+var bundled []byte
+
+// Stack statuses. Frozen stacks are deprecated: still available, but no longer updated.
+const (
+	StatusEdge   = "edge"
+	StatusStable = "stable"
+	StatusFrozen = "frozen"
+)
+
+// Stack is a build environment builds can run on.
+type Stack struct {
+	ID                              string   `json:"id"`
+	OS                              string   `json:"os,omitempty"`
+	Title                           string   `json:"title"`
+	Status                          string   `json:"status"`
+	Description                     string   `json:"description,omitempty"`
+	DescriptionLink                 string   `json:"description-link,omitempty"`
+	DescriptionLinkGen2             string   `json:"description-link-gen2,omitempty"`
+	DescriptionLinkGen2AppleSilicon string   `json:"description-link-gen2-applesilicon,omitempty"`
+	Machines                        []string `json:"machines,omitempty"`
+	// RollbackVersion is passed through as is, keyed by machine type id.
+	RollbackVersion json.RawMessage `json:"rollback_version,omitempty"`
+}
+
+// StackGroup is a group of stacks listed together in the stack picker.
+type StackGroup struct {
+	Label  string  `json:"label"`
+	Status string  `json:"status"`
+	Stacks []Stack `json:"stacks"`
+}
+
+// Machine is a machine type builds can run on.
+type Machine struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	IsDisabled   bool    `json:"is_disabled"`
+	OSID         string  `json:"os_id,omitempty"`
+	CreditPerMin float64 `json:"credit_per_min,omitempty"`
+	// AvailableOnStacks is only set on the default machines.
+	AvailableOnStacks []string `json:"available_on_stacks,omitempty"`
+	// AvailableInRegions maps a region id to one or more hardware descriptions, passed through as is.
+	AvailableInRegions map[string]json.RawMessage `json:"available_in_regions"`
+}
+
+// MachineGroup is a group of machine types listed together in the machine picker.
+type MachineGroup struct {
+	Label    string    `json:"label"`
+	Machines []Machine `json:"machines"`
+}
+
+// Catalog has the shape of the hosted /app/{app_slug}/stacks_and_machines response.
+type Catalog struct {
+	HasSelfHostedRunner         bool           `json:"has_self_hosted_runner"`
+	RunningBuildsOnPrivateCloud bool           `json:"running_builds_on_private_cloud"`
+	DefaultStackID              string         `json:"default_stack_id"`
+	DefaultMachineID            string         `json:"default_machine_id"`
+	DefaultMachines             []Machine      `json:"default_machines"`
+	GroupedStacks               []StackGroup   `json:"grouped_stacks"`
+	GroupedMachines             []MachineGroup `json:"grouped_machines"`
+	Region                      string         `json:"region,omitempty"`
+}
+
+// Load reads the catalog at pth, or the bundled one if pth is empty.
+func Load(pth string) (Catalog, error) {
+	content := bundled
+	if pth != "" {
+		var err error
+		if content, err = os.ReadFile(pth); err != nil {
+			return Catalog{}, fmt.Errorf("failed to read stacks catalog: %w", err)
+		}
+	}
+
+	var catalog Catalog
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		return Catalog{}, fmt.Errorf("invalid stacks catalog (%s): %w", catalogName(pth), err)
+	}
+	return catalog, nil
+}
+
+func catalogName(pth string) string {
+	if pth == "" {
+		return "bundled"
+	}
+	return pth
+}
+
+// Stack returns the stack with the given id.
+func (c Catalog) Stack(id string) (Stack, bool) {
+	for _, group := range c.GroupedStacks {
+		for _, stack := range group.Stacks {
+			if stack.ID == id {
+				return stack, true
+			}
+		}
+	}
+	return Stack{}, false
+}
+
+// Machine returns the machine type with the given id.
+func (c Catalog) Machine(id string) (Machine, bool) {
+	for _, group := range c.GroupedMachines {
+		for _, machine := range group.Machines {
+			if machine.ID == id {
+				return machine, true
+			}
+		}
+	}
+	for _, machine := range c.DefaultMachines {
+		if machine.ID == id {
+			return machine, true
+		}
+	}
+	return Machine{}, false
+}
+
+// replacement returns the first stable stack running the same OS as stack.
+func (c Catalog) replacement(stack Stack) (Stack, bool) {
+	for _, group := range c.GroupedStacks {
+		for _, candidate := range group.Stacks {
+			if candidate.Status == StatusStable && candidate.OS == stack.OS {
+				return candidate, true
+			}
+		}
+	}
+	return Stack{}, false
+}
+
+type bitriseIOMeta struct {
+	Stack         string `yaml:"stack"`
+	MachineTypeID string `yaml:"machine_type_id"`
+}
+
+type metaHolder struct {
+	Meta struct {
+		BitriseIO bitriseIOMeta `yaml:"bitrise.io"`
+	} `yaml:"meta"`
+}
+
+// Warnings checks the meta.bitrise.io stack and machine_type_id values of a bitrise config, at the
+// top level and per workflow, against the catalog: unknown stacks and machine types, frozen stacks,
+// and machine types the stack doesn't run on. Values referencing env vars aren't checked.
+func (c Catalog) Warnings(bitriseYML string) ([]string, error) {
+	var parsed struct {
+		metaHolder `yaml:",inline"`
+		Workflows  map[string]metaHolder `yaml:"workflows"`
+	}
+	if err := yaml.Unmarshal([]byte(bitriseYML), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse meta: %w", err)
+	}
+
+	topLevel := parsed.Meta.BitriseIO
+	warnings := c.metaWarnings("meta.bitrise.io", topLevel, "")
+
+	names := make([]string, 0, len(parsed.Workflows))
+	for name := range parsed.Workflows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		meta := parsed.Workflows[name].Meta.BitriseIO
+		warnings = append(warnings, c.metaWarnings("workflows."+name+".meta.bitrise.io", meta, topLevel.Stack)...)
+	}
+	return warnings, nil
+}
+
+// metaWarnings checks one meta.bitrise.io block; a workflow without a stack runs on inheritedStack.
+func (c Catalog) metaWarnings(at string, meta bitriseIOMeta, inheritedStack string) []string {
+	var warnings []string
+
+	stackID := meta.Stack
+	if stackID == "" {
+		stackID = inheritedStack
+	}
+	stack, stackKnown := c.Stack(stackID)
+	if meta.Stack != "" && !isReference(meta.Stack) {
+		switch {
+		case !stackKnown:
+			warnings = append(warnings, fmt.Sprintf("%s.stack: unknown stack %s", at, meta.Stack))
+		case stack.Status == StatusFrozen:
+			message := fmt.Sprintf("%s.stack: %s is deprecated, frozen stacks are no longer updated", at, meta.Stack)
+			if replacement, ok := c.replacement(stack); ok {
+				message += ", consider moving to " + replacement.ID
+			}
+			warnings = append(warnings, message)
+		}
+	}
+
+	if meta.MachineTypeID == "" || isReference(meta.MachineTypeID) {
+		return warnings
+	}
+	if _, ok := c.Machine(meta.MachineTypeID); !ok {
+		return append(warnings, fmt.Sprintf("%s.machine_type_id: unknown machine type %s", at, meta.MachineTypeID))
+	}
+	if stackKnown && len(stack.Machines) > 0 && !contains(stack.Machines, meta.MachineTypeID) {
+		warnings = append(warnings, fmt.Sprintf("%s.machine_type_id: %s is not available on stack %s, available: %s", at, meta.MachineTypeID, stack.ID, strings.Join(stack.Machines, ", ")))
+	}
+	return warnings
+}
+
+func isReference(value string) bool {
+	return strings.Contains(value, "$")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
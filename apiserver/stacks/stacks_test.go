@@ -0,0 +1,98 @@
+package stacks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Log("the bundled catalog knows its defaults")
+	{
+		catalog, err := Load("")
+		require.NoError(t, err)
+
+		_, ok := catalog.Stack(catalog.DefaultStackID)
+		require.True(t, ok)
+		_, ok = catalog.Machine(catalog.DefaultMachineID)
+		require.True(t, ok)
+	}
+
+	t.Log("a catalog file replaces the bundled one")
+	{
+		pth := filepath.Join(t.TempDir(), "stacks.json")
+		require.NoError(t, os.WriteFile(pth, []byte(`{"default_stack_id":"custom","grouped_stacks":[{"label":"Custom","status":"stable","stacks":[{"id":"custom","title":"Custom","status":"stable"}]}]}`), 0644))
+
+		catalog, err := Load(pth)
+		require.NoError(t, err)
+		_, ok := catalog.Stack("custom")
+		require.True(t, ok)
+		_, ok = catalog.Stack("osx-xcode-16.0.x")
+		require.False(t, ok)
+	}
+
+	t.Log("invalid catalog file")
+	{
+		pth := filepath.Join(t.TempDir(), "stacks.json")
+		require.NoError(t, os.WriteFile(pth, []byte(`{"grouped_stacks":{}}`), 0644))
+
+		_, err := Load(pth)
+		require.Error(t, err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	catalog, err := Load("")
+	require.NoError(t, err)
+
+	t.Log("known stacks and machine types")
+	{
+		warnings, err := catalog.Warnings(`format_version: "13"
+meta:
+  bitrise.io:
+    stack: osx-xcode-16.0.x
+    machine_type_id: g2.mac.medium
+workflows:
+  test:
+    meta:
+      bitrise.io:
+        stack: ubuntu-noble-24.04-bitrise-2025
+        machine_type_id: g2.linux.large
+  build:
+    meta:
+      bitrise.io:
+        machine_type_id: $MACHINE
+`)
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	}
+
+	t.Log("unknown, frozen and mismatched")
+	{
+		warnings, err := catalog.Warnings(`format_version: "13"
+meta:
+  bitrise.io:
+    stack: ubuntu-focal-20.04
+    machine_type_id: g2.linux.medium
+workflows:
+  ios:
+    meta:
+      bitrise.io:
+        machine_type_id: g2.mac.medium
+  old:
+    meta:
+      bitrise.io:
+        stack: osx-xcode-12.5.x
+        machine_type_id: standard
+`)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"meta.bitrise.io.stack: ubuntu-focal-20.04 is deprecated, frozen stacks are no longer updated, consider moving to ubuntu-noble-24.04-bitrise-2025",
+			"workflows.ios.meta.bitrise.io.machine_type_id: g2.mac.medium is not available on stack ubuntu-focal-20.04, available: g2.linux.medium, g2.linux.large",
+			"workflows.old.meta.bitrise.io.stack: unknown stack osx-xcode-12.5.x",
+			"workflows.old.meta.bitrise.io.machine_type_id: unknown machine type standard",
+		}, warnings)
+	}
+}
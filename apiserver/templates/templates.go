@@ -0,0 +1,212 @@
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"gopkg.in/yaml.v2"
+)
+
+// The starters use [[ ]] delimiters, so bitrise's own {{ }} run_if templates can be written as is.
+//
+//go:embed starters/*.yml
+var starters embed.FS
+
+// Parameter is a value the user can set when rendering a template.
+type Parameter struct {
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// Default is used when no value is given; a parameter without a default is required.
+	Default string `json:"default,omitempty"`
+	// Options, if set, are the only accepted values.
+	Options []string `json:"options,omitempty"`
+}
+
+// Template is a starter bitrise config for a project type.
+type Template struct {
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	ProjectType string      `json:"project_type"`
+	Workflows   []string    `json:"workflows"`
+	Parameters  []Parameter `json:"parameters"`
+}
+
+var branchParameter = Parameter{
+	Key:         "branch",
+	Title:       "Branch",
+	Description: "Pushes to this branch trigger the primary workflow.",
+	Default:     "main",
+}
+
+var all = []Template{
+	{
+		ID:          "generic",
+		Title:       "Generic",
+		Description: "Clone, run a test script and deploy the results. Fits any project type.",
+		ProjectType: "other",
+		Workflows:   []string{"primary"},
+		Parameters: []Parameter{
+			{Key: "test_command", Title: "Test command", Description: "Script run by the primary workflow.", Default: `echo "Add your test command here"`},
+			{Key: "project_type", Title: "Project type", Description: "Hybrid projects without a dedicated template use this one too.", Default: "other", Options: []string{"other", "react-native", "ionic", "cordova"}},
+			branchParameter,
+		},
+	},
+	{
+		ID:          "ios",
+		Title:       "iOS",
+		Description: "Xcode test on every push and an archive workflow exporting an .ipa.",
+		ProjectType: "ios",
+		Workflows:   []string{"primary", "archive_and_export_app"},
+		Parameters: []Parameter{
+			{Key: "project_path", Title: "Project path", Description: "Xcode project or workspace, relative to the repository root.", Default: "MyApp.xcodeproj"},
+			{Key: "scheme", Title: "Scheme", Description: "Shared scheme to test and archive.", Default: "MyApp"},
+			{Key: "distribution_method", Title: "Distribution method", Default: "development", Options: []string{"development", "ad-hoc", "app-store", "enterprise"}},
+			{Key: "stack", Title: "Stack", Default: "osx-xcode-16.0.x"},
+			branchParameter,
+		},
+	},
+	{
+		ID:          "android",
+		Title:       "Android",
+		Description: "Gradle unit tests on every push and a workflow building an APK.",
+		ProjectType: "android",
+		Workflows:   []string{"primary", "run_tests_and_build"},
+		Parameters: []Parameter{
+			{Key: "project_location", Title: "Project location", Description: "Directory of the root build.gradle, relative to the repository root.", Default: "."},
+			{Key: "module", Title: "Module", Default: "app"},
+			{Key: "variant", Title: "Variant", Default: "debug"},
+			branchParameter,
+		},
+	},
+	{
+		ID:          "flutter",
+		Title:       "Flutter",
+		Description: "Flutter tests on every push and a workflow building for Android and iOS.",
+		ProjectType: "flutter",
+		Workflows:   []string{"primary", "build"},
+		Parameters: []Parameter{
+			{Key: "project_location", Title: "Project location", Description: "Directory of pubspec.yaml, relative to the repository root.", Default: "."},
+			{Key: "platform", Title: "Build platform", Default: "both", Options: []string{"both", "android", "ios"}},
+			branchParameter,
+		},
+	},
+}
+
+// List returns the available templates.
+func List() []Template {
+	return append([]Template{}, all...)
+}
+
+// Get returns the template with the given id.
+func Get(id string) (Template, bool) {
+	for _, t := range all {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// Values returns the parameter values to render t with: the given params completed with defaults.
+func (t Template) Values(params map[string]string) (map[string]string, error) {
+	known := map[string]bool{}
+	values := map[string]string{}
+	for _, parameter := range t.Parameters {
+		known[parameter.Key] = true
+
+		value := params[parameter.Key]
+		if value == "" {
+			value = parameter.Default
+		}
+		if value == "" {
+			return nil, fmt.Errorf("missing required parameter: %s", parameter.Key)
+		}
+		if len(parameter.Options) > 0 && !contains(parameter.Options, value) {
+			return nil, fmt.Errorf("invalid value for %s: %s, accepted: %s", parameter.Key, value, strings.Join(parameter.Options, ", "))
+		}
+		values[parameter.Key] = value
+	}
+
+	var unknown []string
+	for key := range params {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters for template %s: %s", t.ID, strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// Render returns the bitrise config of the template rendered with params. Missing parameters take
+// their default value, and the rendered config is validated before it's returned.
+func Render(id string, params map[string]string) (string, error) {
+	t, ok := Get(id)
+	if !ok {
+		return "", fmt.Errorf("unknown template: %s", id)
+	}
+
+	values, err := t.Values(params)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := starters.ReadFile("starters/" + id + ".yml")
+	if err != nil {
+		return "", fmt.Errorf("failed to read template (%s): %w", id, err)
+	}
+
+	tmpl, err := template.New(id).
+		Delims("[[", "]]").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"yaml": yamlScalar, "indent": indent}).
+		Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template (%s): %w", id, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, values); err != nil {
+		return "", fmt.Errorf("failed to render template (%s): %w", id, err)
+	}
+
+	if _, err := utility.ValidateBitriseConfigAndSecret(rendered.String(), config.MinimalValidSecrets); err != nil {
+		return "", fmt.Errorf("template (%s) rendered an invalid config: %w", id, err)
+	}
+
+	return rendered.String(), nil
+}
+
+// yamlScalar encodes s as a YAML scalar, quoting it when needed.
+func yamlScalar(s string) (string, error) {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// indent prefixes every line of s but the first with n spaces, for use inside block scalars.
+func indent(n int, s string) string {
+	return strings.ReplaceAll(s, "\n", "\n"+strings.Repeat(" ", n))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
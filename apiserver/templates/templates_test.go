@@ -0,0 +1,64 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRender(t *testing.T) {
+	t.Log("every template renders with its defaults")
+	{
+		for _, template := range List() {
+			content, err := Render(template.ID, nil)
+			require.NoError(t, err, template.ID)
+
+			var parsed struct {
+				ProjectType string                 `yaml:"project_type"`
+				Workflows   map[string]interface{} `yaml:"workflows"`
+			}
+			require.NoError(t, yaml.Unmarshal([]byte(content), &parsed), template.ID)
+			require.Equal(t, template.ProjectType, parsed.ProjectType)
+			require.Len(t, parsed.Workflows, len(template.Workflows), template.ID)
+			for _, workflow := range template.Workflows {
+				require.Contains(t, parsed.Workflows, workflow, template.ID)
+			}
+		}
+	}
+
+	t.Log("parameter values are quoted")
+	{
+		content, err := Render("ios", map[string]string{"scheme": "My App: Debug", "branch": "release/*"})
+		require.NoError(t, err)
+
+		var parsed struct {
+			App struct {
+				Envs []map[string]string `yaml:"envs"`
+			} `yaml:"app"`
+			TriggerMap []map[string]string `yaml:"trigger_map"`
+		}
+		require.NoError(t, yaml.Unmarshal([]byte(content), &parsed))
+		require.Equal(t, "My App: Debug", parsed.App.Envs[1]["BITRISE_SCHEME"])
+		require.Equal(t, "release/*", parsed.TriggerMap[0]["push_branch"])
+	}
+
+	t.Log("multiline test command stays in the block scalar")
+	{
+		content, err := Render("generic", map[string]string{"test_command": "make lint\nmake test"})
+		require.NoError(t, err)
+		require.Contains(t, content, "            make lint\n            make test\n")
+	}
+
+	t.Log("invalid parameters")
+	{
+		_, err := Render("unknown", nil)
+		require.EqualError(t, err, "unknown template: unknown")
+
+		_, err = Render("flutter", map[string]string{"platform": "web"})
+		require.EqualError(t, err, "invalid value for platform: web, accepted: both, android, ios")
+
+		_, err = Render("android", map[string]string{"flavor": "free"})
+		require.EqualError(t, err, "unknown parameters for template android: flavor")
+	}
+}
@@ -0,0 +1,21 @@
+package tools
+
+import (
+	"os/exec"
+)
+
+// BitriseBinary is the bitrise CLI workflows are run with.
+var BitriseBinary = "bitrise"
+
+// BitriseRunCommand returns the command running `workflow` from the config at `configPth` with the
+// secrets at `inventoryPth`, in `dir`. An empty inventory path runs without secrets.
+func BitriseRunCommand(dir, workflow, configPth, inventoryPth string) *exec.Cmd {
+	args := []string{"run", workflow, "--config", configPth}
+	if inventoryPth != "" {
+		args = append(args, "--inventory", inventoryPth)
+	}
+
+	cmd := exec.Command(BitriseBinary, args...)
+	cmd.Dir = dir
+	return cmd
+}
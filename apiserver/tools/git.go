@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// ErrGitRefNotFound is returned when a ref can't be resolved in a local repository.
+var ErrGitRefNotFound = errors.New("ref not found")
+
+// GitResolveCommit resolves the first of the given revisions that exists in the repository at
+// `repoDir` to a commit sha. Returns ErrGitRefNotFound if none of them exist locally.
+func GitResolveCommit(repoDir string, revs ...string) (string, error) {
+	for _, rev := range revs {
+		out, err := command.New("git", "rev-parse", "--verify", "--quiet", rev+"^{commit}").SetDir(repoDir).RunAndReturnTrimmedOutput()
+		if err == nil && out != "" {
+			return out, nil
+		}
+
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to run git in %s: %w", repoDir, err)
+		}
+	}
+	return "", ErrGitRefNotFound
+}
+
+// GitShowFile returns the contents of `path` at `commit` in the repository at `repoDir`, byte for byte.
+func GitShowFile(repoDir, commit, path string) (string, error) {
+	cmd := command.New("git", "show", commit+":"+strings.TrimPrefix(path, "./")).SetDir(repoDir).GetCmd()
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to read %s at %s: %s", path, commit, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("failed to read %s at %s: %w", path, commit, err)
+	}
+	return string(out), nil
+}
+
+// GitLsRemote resolves the first of the given refs (e.g. refs/heads/main, HEAD) that exists in the
+// remote repository to a commit sha, without fetching anything. Returns ErrGitRefNotFound if none
+// of them exist.
+func GitLsRemote(repoURL string, refs ...string) (string, error) {
+	out, err := command.New("git", append([]string{"ls-remote", "--", repoURL}, refs...)...).AppendEnvs("GIT_TERMINAL_PROMPT=0").RunAndReturnTrimmedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to list refs of %s: %w", repoURL, err)
+	}
+
+	commits := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if sha, ref, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+			commits[ref] = sha
+		}
+	}
+	for _, ref := range refs {
+		if sha, ok := commits[ref]; ok {
+			return sha, nil
+		}
+	}
+	return "", ErrGitRefNotFound
+}
+
+// GitFetchCommit makes `commit` of the remote repository available in the bare repository at
+// `repoDir`, creating it if needed. Only the commit itself is fetched.
+func GitFetchCommit(repoDir, repoURL, commit string) error {
+	if _, err := GitResolveCommit(repoDir, commit); err == nil {
+		return nil
+	}
+	if out, err := command.New("git", "init", "-q", "--bare", repoDir).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("failed to init %s: %s", repoDir, out)
+	}
+	out, err := command.New("git", "fetch", "-q", "--depth", "1", "--", repoURL, commit).SetDir(repoDir).AppendEnvs("GIT_TERMINAL_PROMPT=0").RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s from %s: %s", commit, repoURL, out)
+	}
+	return nil
+}
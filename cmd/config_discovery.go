@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/goinp/goinp"
+)
+
+// configFileNames are the bitrise config names looked for in each directory, in order of preference.
+var configFileNames = []string{"bitrise.yml", "bitrise.yaml"}
+
+const secretsFileName = ".bitrise.secrets.yml"
+
+// findBitriseConfig searches dir and its parents for a bitrise config. The search stops at the git
+// root (the first directory containing .git) or at the filesystem root; an empty path means none was found.
+func findBitriseConfig(dir string) (string, error) {
+	for {
+		log.Printf("Searching for bitrise config in: %s", dir)
+
+		for _, name := range configFileNames {
+			pth := filepath.Join(dir, name)
+			if exist, err := pathutil.IsPathExists(pth); err != nil {
+				return "", fmt.Errorf("failed to check if %s exists: %w", pth, err)
+			} else if exist {
+				return pth, nil
+			}
+		}
+
+		if exist, err := pathutil.IsPathExists(filepath.Join(dir, ".git")); err != nil {
+			return "", fmt.Errorf("failed to check if %s is a git root: %w", dir, err)
+		} else if exist {
+			return "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// isInteractive reports whether stdin is a terminal the user can answer prompts on.
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// offerStarterConfig asks whether to create a starter config in dir from one of the templates.
+// It returns the path of the created config, or an empty path if the user declined.
+func offerStarterConfig(dir string) (string, error) {
+	create, err := goinp.AskForBoolWithDefault("No bitrise config found. Create a starter config?", true)
+	if err != nil {
+		return "", err
+	}
+	if !create {
+		return "", nil
+	}
+
+	templateID, err := selectTemplate()
+	if err != nil {
+		return "", err
+	}
+
+	pth := filepath.Join(dir, configFileNames[0])
+	if err := writeStarterConfig(pth, templateID, map[string]string{}, true); err != nil {
+		return "", err
+	}
+	return pth, nil
+}
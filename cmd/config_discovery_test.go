@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBitriseConfig(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "repo", "ios", "App")
+	require.NoError(t, os.MkdirAll(nested, 0755))
+
+	t.Log("finds the config in a parent directory")
+	{
+		require.NoError(t, os.WriteFile(filepath.Join(root, "repo", "bitrise.yaml"), []byte("format_version: \"13\""), 0644))
+
+		pth, err := findBitriseConfig(nested)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(root, "repo", "bitrise.yaml"), pth)
+	}
+
+	t.Log("prefers bitrise.yml over bitrise.yaml")
+	{
+		require.NoError(t, os.WriteFile(filepath.Join(root, "repo", "bitrise.yml"), []byte("format_version: \"13\""), 0644))
+
+		pth, err := findBitriseConfig(nested)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(root, "repo", "bitrise.yml"), pth)
+	}
+
+	t.Log("stops at the git root")
+	{
+		require.NoError(t, os.Mkdir(filepath.Join(root, "repo", "ios", ".git"), 0755))
+
+		pth, err := findBitriseConfig(nested)
+		require.NoError(t, err)
+		require.Equal(t, "", pth)
+	}
+}
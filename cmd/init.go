@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/templates"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/goinp/goinp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initTemplateID string
+	initParams     []string
+	initOutput     string
+	initForce      bool
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Creates a bitrise config from a starter template",
+	Long: `Creates a bitrise config from a starter template.
+
+Without --template the template and its parameters are asked for interactively.
+Available templates: ` + strings.Join(templateIDs(), ", "),
+	Run: func(cmd *cobra.Command, args []string) {
+		if exist, err := pathutil.IsPathExists(initOutput); err != nil {
+			failf("Failed to check if %s exists, error: %s", initOutput, err)
+		} else if exist && !initForce {
+			failf("%s already exists, use --force to overwrite it", initOutput)
+		}
+
+		params, err := parseTemplateParams(initParams)
+		if err != nil {
+			failf("Invalid --param, error: %s", err)
+		}
+
+		templateID := initTemplateID
+		if templateID == "" {
+			if !isInteractive() {
+				failf("--template is required when not running in a terminal")
+			}
+			if templateID, err = selectTemplate(); err != nil {
+				failf("Failed to select template, error: %s", err)
+			}
+		}
+
+		if err := writeStarterConfig(initOutput, templateID, params, isInteractive()); err != nil {
+			failf("Failed to create bitrise config, error: %s", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVar(&initTemplateID, "template", "", "Template to create the config from")
+	initCmd.Flags().StringArrayVar(&initParams, "param", nil, "Template parameter as key=value, can be repeated")
+	initCmd.Flags().StringVar(&initOutput, "output", configFileNames[0], "Path of the created config")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite the config if it already exists")
+}
+
+func templateIDs() []string {
+	var ids []string
+	for _, template := range templates.List() {
+		ids = append(ids, template.ID)
+	}
+	return ids
+}
+
+// parseTemplateParams parses key=value pairs given on the command line.
+func parseTemplateParams(pairs []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got: %s", pair)
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
+// selectTemplate asks the user to pick a template and returns its id.
+func selectTemplate() (string, error) {
+	available := templates.List()
+	options := make([]string, 0, len(available))
+	for _, template := range available {
+		options = append(options, fmt.Sprintf("%s: %s", template.Title, template.Description))
+	}
+
+	selected, err := goinp.SelectFromStringsWithDefault("Select a template for the bitrise config", 1, options)
+	if err != nil {
+		return "", err
+	}
+	for idx, option := range options {
+		if option == selected {
+			return available[idx].ID, nil
+		}
+	}
+	return "", fmt.Errorf("unknown template: %s", selected)
+}
+
+// askTemplateParams asks for the parameters of the template not given in params.
+func askTemplateParams(template templates.Template, params map[string]string) error {
+	for _, parameter := range template.Parameters {
+		if _, ok := params[parameter.Key]; ok {
+			continue
+		}
+
+		question := parameter.Title
+		if parameter.Description != "" {
+			question += " (" + parameter.Description + ")"
+		}
+
+		var value string
+		var err error
+		if len(parameter.Options) > 0 {
+			defaultIdx := 1
+			for idx, option := range parameter.Options {
+				if option == parameter.Default {
+					defaultIdx = idx + 1
+				}
+			}
+			value, err = goinp.SelectFromStringsWithDefault(question, defaultIdx, parameter.Options)
+		} else {
+			value, err = goinp.AskForStringWithDefault(question, parameter.Default)
+		}
+		if err != nil {
+			return err
+		}
+		params[parameter.Key] = value
+	}
+	return nil
+}
+
+// writeStarterConfig renders the template into pth, asking for the missing parameters first if interactive.
+func writeStarterConfig(pth, templateID string, params map[string]string, interactive bool) error {
+	template, ok := templates.Get(templateID)
+	if !ok {
+		return fmt.Errorf("unknown template: %s, available: %s", templateID, strings.Join(templateIDs(), ", "))
+	}
+
+	if interactive {
+		if err := askTemplateParams(template, params); err != nil {
+			return err
+		}
+	}
+
+	content, err := templates.Render(templateID, params)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(pth); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+	if err := fileutil.WriteStringToFile(pth, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", pth, err)
+	}
+	log.Donef("Created %s from the %s template", pth, templateID)
+
+	return nil
+}
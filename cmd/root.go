@@ -3,6 +3,7 @@ package cmd
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/bitrise-io/bitrise-workflow-editor/apiserver"
 	"github.com/bitrise-io/go-utils/log"
@@ -10,6 +11,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var serverOptions = apiserver.DefaultServerOptions()
+
 func failf(format string, v ...interface{}) {
 	log.Errorf(format, v...)
 	os.Exit(1)
@@ -24,17 +27,60 @@ var RootCmd = &cobra.Command{
 			failf("Failed to get current dir, error: %s", err)
 		}
 
-		bitriseConfigPth := filepath.Join(currentDir, "bitrise.yml")
-		log.Printf("Searching for bitrise.yml at: %s", bitriseConfigPth)
+		hasOtherProjects := len(serverOptions.ProjectConfigPaths) > 0 || serverOptions.ProjectsRoot != ""
+		if serverOptions.BitriseConfigPath == "" {
+			bitriseConfigPth, err := findBitriseConfig(currentDir)
+			if err != nil {
+				failf("Failed to search for bitrise config, error: %s", err)
+			}
+
+			if bitriseConfigPth == "" && !hasOtherProjects {
+				if !isInteractive() {
+					failf("No bitrise config (%s) found in the current directory or its parents up to the git root", strings.Join(configFileNames, ", "))
+				}
+				if bitriseConfigPth, err = offerStarterConfig(currentDir); err != nil {
+					failf("Failed to create starter config, error: %s", err)
+				} else if bitriseConfigPth == "" {
+					failf("No bitrise config found, use --config to point to one")
+				}
+			}
 
-		if exist, err := pathutil.IsPathExists(bitriseConfigPth); err != nil {
-			failf("Failed to check is bitrise.yml exist, error: %s", err)
+			if bitriseConfigPth != "" {
+				log.Printf("Using bitrise config: %s", bitriseConfigPth)
+				serverOptions.BitriseConfigPath = bitriseConfigPth
+				if serverOptions.SecretsPath == "" {
+					serverOptions.SecretsPath = filepath.Join(filepath.Dir(bitriseConfigPth), secretsFileName)
+				}
+			}
+		} else if exist, err := pathutil.IsPathExists(serverOptions.BitriseConfigPath); err != nil {
+			failf("Failed to check if bitrise config exists, error: %s", err)
 		} else if !exist {
-			failf("No bitrise config (bitrise.yml) found in the current directory")
+			failf("Bitrise config not found at: %s", serverOptions.BitriseConfigPath)
 		}
 
-		if err := apiserver.LaunchServer(); err != nil {
+		if err := apiserver.LaunchServer(serverOptions); err != nil {
 			failf("Failed to start server, error: %s", err)
 		}
 	},
 }
+
+func init() {
+	flags := RootCmd.Flags()
+	flags.StringVar(&serverOptions.Port, "port", serverOptions.Port, "Port to listen on (env: PORT). A free port is picked if empty")
+	flags.StringVar(&serverOptions.BindAddress, "bind", serverOptions.BindAddress, "Address to listen on (env: BIND_ADDRESS). Use 0.0.0.0 to listen on all interfaces")
+	flags.StringVar(&serverOptions.PublicHost, "public-host", "", "Host name the editor is reached at, used in the printed URL and accepted in Host/Origin headers")
+	flags.BoolVar(&serverOptions.Remote, "remote", false, "Remote dev mode: don't open a browser, print a URL ready for port forwarding instead")
+	flags.BoolVar(&serverOptions.NoBrowser, "no-browser", false, "Don't open the editor in the default browser")
+	flags.StringVar(&serverOptions.TLSCertFile, "tls-cert", "", "Serve HTTPS using this certificate file")
+	flags.StringVar(&serverOptions.TLSKeyFile, "tls-key", "", "Private key file for --tls-cert")
+	flags.BoolVar(&serverOptions.TLSSelfSigned, "tls-self-signed", false, "Serve HTTPS using a self-signed certificate generated at launch")
+	flags.StringVar(&serverOptions.BitriseConfigPath, "config", serverOptions.BitriseConfigPath, "Bitrise config to edit (env: BITRISE_CONFIG). Searched for upwards to the git root if empty")
+	flags.StringVar(&serverOptions.SecretsPath, "secrets", serverOptions.SecretsPath, "Secrets file to edit (env: BITRISE_SECRETS). Defaults to .bitrise.secrets.yml next to the config")
+	flags.BoolVar(&serverOptions.StrictSecretScan, "strict-secret-scan", serverOptions.StrictSecretScan, "Reject config saves with possible secrets in them instead of warning (env: BITRISE_SECRET_SCAN_STRICT)")
+	flags.StringVar(&serverOptions.StacksCatalogPath, "stacks-catalog", serverOptions.StacksCatalogPath, "Stacks and machines catalog JSON replacing the bundled one (env: BITRISE_STACKS_CATALOG)")
+	flags.StringVar(&serverOptions.SecretProvidersPath, "secret-providers", serverOptions.SecretProvidersPath, "Secret providers config (env, dotenv, command, vault) to read secrets from besides the secrets file (env: BITRISE_SECRET_PROVIDERS)")
+	flags.StringArrayVar(&serverOptions.ProjectConfigPaths, "project", nil, "Additional bitrise config to serve as a project, can be repeated")
+	flags.StringVar(&serverOptions.ProjectsRoot, "projects-root", "", "Serve every bitrise config (bitrise.yml, bitrise.yaml) found under this directory as a project")
+	flags.DurationVar(&serverOptions.IdleTimeout, "idle-timeout", serverOptions.IdleTimeout, "Shut down this long after the last editor tab is closed; 0 keeps the server running")
+	flags.BoolVar(&serverOptions.UseDevServer, "dev-server", serverOptions.UseDevServer, "Serve non-api resources through the frontend dev server (env: USE_DEV_SERVER)")
+}
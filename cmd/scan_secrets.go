@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/service"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/goinp/goinp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanConfigPath string
+	scanStrict     bool
+	scanFix        bool
+	scanFormat     string
+)
+
+var secretsScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Scans the config and its modules for pasted secrets",
+	Long: `Scans the config and its local module files for values that look like credentials:
+known token formats (GitHub, GitLab, AWS, Slack, Google, Stripe, npm, private keys) and
+high-entropy strings.
+
+With --fix each finding is offered to be moved into the secrets file as a new secret, replacing
+the value in the config with a reference to it. With --strict the command fails if any remain.`,
+	Example: `  workflow-editor secrets scan
+  workflow-editor secrets scan --fix
+  workflow-editor secrets scan --strict --format json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pth := scanConfigPath
+		if pth == "" {
+			currentDir, err := filepath.Abs("./")
+			if err != nil {
+				failf("Failed to get current dir, error: %s", err)
+			}
+			if pth, err = findBitriseConfig(currentDir); err != nil {
+				failf("Failed to search for bitrise config, error: %s", err)
+			} else if pth == "" {
+				failf("No bitrise config found, use --config to point to one")
+			}
+		}
+		if secretsFilePath == "" {
+			secretsFilePath = filepath.Join(filepath.Dir(pth), secretsFileName)
+		}
+
+		leaks, err := service.ScanConfigTreeForSecretLeaks(pth, scanStrict)
+		if err != nil {
+			failf("Failed to scan bitrise config (%s), error: %s", pth, err)
+		}
+		if scanFix && len(leaks) > 0 {
+			if !isInteractive() {
+				failf("--fix needs an interactive terminal")
+			}
+			if leaks, err = fixSecretLeaks(pth, leaks); err != nil {
+				failf("Failed to move secrets, error: %s", err)
+			}
+		}
+
+		switch scanFormat {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(leaks); err != nil {
+				failf("Failed to print result, error: %s", err)
+			}
+		case "text":
+			if len(leaks) == 0 {
+				log.Donef("No possible secrets found")
+			}
+			for _, leak := range leaks {
+				fmt.Printf("%s:%d:%d: %s %s at %s, move it to a secret: $%s\n", leak.File, leak.Line, leak.Column, leak.Rule, leak.Preview, leak.Path, leak.SuggestedKey)
+			}
+		default:
+			failf("Unknown --format: %s, expected text or json", scanFormat)
+		}
+
+		if scanStrict && len(leaks) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsScanCmd)
+	flags := secretsScanCmd.Flags()
+	flags.StringVar(&scanConfigPath, "config", "", "Bitrise config to scan. Searched for upwards to the git root if empty")
+	flags.BoolVar(&scanStrict, "strict", false, "Fail if any possible secret is found")
+	flags.BoolVar(&scanFix, "fix", false, "Offer to move each possible secret into the secrets file")
+	flags.StringVar(&scanFormat, "format", "text", "Output format: text or json")
+}
+
+// fixSecretLeaks asks for each leak whether to move it into the secrets file, and returns the
+// leaks left in place.
+func fixSecretLeaks(configPth string, leaks []service.SecretLeak) ([]service.SecretLeak, error) {
+	if cont, err := os.ReadFile(secretsFilePath); err == nil && secrets.IsEncrypted(cont) {
+		passphrase, err := secretsPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		config.SecretsPassphrase = passphrase
+	}
+
+	repoRoot := filepath.Dir(configPth)
+	skipped := map[string]bool{}
+	for {
+		if err := service.SuggestFreeSecretKeys(leaks, secretsFilePath); err != nil {
+			return nil, err
+		}
+		var leak *service.SecretLeak
+		for i := range leaks {
+			if !skipped[leakID(leaks[i])] {
+				leak = &leaks[i]
+				break
+			}
+		}
+		if leak == nil {
+			return leaks, nil
+		}
+
+		question := fmt.Sprintf("%s:%d: move %s (%s) to a secret?", leak.File, leak.Line, leak.Preview, leak.Rule)
+		move, err := goinp.AskForBoolWithDefault(question, true)
+		if err != nil {
+			return nil, err
+		}
+		if !move {
+			skipped[leakID(*leak)] = true
+			continue
+		}
+		key, err := goinp.AskForStringWithDefault("Secret key", leak.SuggestedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		filePth := filepath.Join(repoRoot, filepath.FromSlash(leak.File))
+		cont, err := os.ReadFile(filePth)
+		if err != nil {
+			return nil, err
+		}
+		fixed, err := service.MoveSecretLeak(string(cont), *leak, key, secretsFilePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filePth, []byte(fixed), 0644); err != nil {
+			return nil, err
+		}
+		log.Donef("Moved to $%s in %s", key, secretsFilePath)
+
+		if leaks, err = service.ScanConfigTreeForSecretLeaks(configPth, scanStrict); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func leakID(leak service.SecretLeak) string {
+	return fmt.Sprintf("%s:%s:%d:%s", leak.File, leak.Path, leak.Line, leak.Rule)
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/secrets"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/utility"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const secretsPassphraseEnvKey = "BITRISE_SECRETS_PASSPHRASE"
+
+var secretsFilePath string
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manages the secrets file",
+	Long: `Manages the encryption of the secrets file, and scans the config for secrets to move into it.
+
+The passphrase is read from the ` + secretsPassphraseEnvKey + ` env var, or asked for if not set.
+Run the editor with the same env var to edit an encrypted secrets file.`,
+}
+
+var secretsEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypts a plaintext secrets file in place",
+	Run: func(cmd *cobra.Command, args []string) {
+		pth := resolveSecretsPath()
+		cont, err := os.ReadFile(pth)
+		if err != nil {
+			failf("Failed to read secrets file, error: %s", err)
+		}
+		if secrets.IsEncrypted(cont) {
+			log.Donef("%s is already encrypted", pth)
+			return
+		}
+		if _, err := utility.ValidateBitriseConfigAndSecret(config.MinimalValidBitriseYML, string(cont)); err != nil {
+			failf("Invalid secrets file, error: %s", err)
+		}
+
+		passphrase, err := secretsPassphrase(true)
+		if err != nil {
+			failf("Failed to read passphrase, error: %s", err)
+		}
+		if err := secrets.WriteFile(pth, cont, passphrase); err != nil {
+			failf("Failed to write encrypted secrets file, error: %s", err)
+		}
+		if decrypted, err := secrets.ReadFile(pth, passphrase); err != nil || !bytes.Equal(decrypted, cont) {
+			// Don't leave the secrets unreadable.
+			if err := secrets.WriteFile(pth, cont, ""); err != nil {
+				failf("Failed to restore plaintext secrets file, error: %s", err)
+			}
+			failf("Failed to verify the encrypted secrets file, it was left in plaintext")
+		}
+		log.Donef("Encrypted %s", pth)
+	},
+}
+
+var secretsDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Decrypts an encrypted secrets file in place",
+	Run: func(cmd *cobra.Command, args []string) {
+		pth := resolveSecretsPath()
+		cont, err := os.ReadFile(pth)
+		if err != nil {
+			failf("Failed to read secrets file, error: %s", err)
+		}
+		if !secrets.IsEncrypted(cont) {
+			log.Donef("%s is not encrypted", pth)
+			return
+		}
+
+		passphrase, err := secretsPassphrase(false)
+		if err != nil {
+			failf("Failed to read passphrase, error: %s", err)
+		}
+		plaintext, err := secrets.Decrypt(cont, passphrase)
+		if err != nil {
+			failf("%s", err)
+		}
+		if err := secrets.WriteFile(pth, plaintext, ""); err != nil {
+			failf("Failed to write secrets file, error: %s", err)
+		}
+		log.Donef("Decrypted %s", pth)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsEncryptCmd, secretsDecryptCmd)
+	secretsCmd.PersistentFlags().StringVar(&secretsFilePath, "secrets", "", "Secrets file. Defaults to "+secretsFileName+" next to the discovered bitrise config")
+}
+
+// resolveSecretsPath returns the --secrets file, or the one next to the discovered config.
+func resolveSecretsPath() string {
+	if secretsFilePath != "" {
+		return secretsFilePath
+	}
+
+	currentDir, err := filepath.Abs("./")
+	if err != nil {
+		failf("Failed to get current dir, error: %s", err)
+	}
+	configPth, err := findBitriseConfig(currentDir)
+	if err != nil {
+		failf("Failed to search for bitrise config, error: %s", err)
+	}
+	if configPth == "" {
+		return filepath.Join(currentDir, secretsFileName)
+	}
+	return filepath.Join(filepath.Dir(configPth), secretsFileName)
+}
+
+// secretsPassphrase returns the passphrase from the env, or asks for it (twice if confirm).
+func secretsPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(secretsPassphraseEnvKey); passphrase != "" {
+		return passphrase, nil
+	}
+	if !isInteractive() {
+		return "", fmt.Errorf("%s is not set", secretsPassphraseEnvKey)
+	}
+
+	passphrase, err := readPassword("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := readPassword("Passphrase again: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("the passphrases don't match")
+		}
+	}
+	return passphrase, nil
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	defer fmt.Println()
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	return string(passphrase), err
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/config"
+	"github.com/bitrise-io/bitrise-workflow-editor/apiserver/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateConfigPath string
+	simulateEvent      service.TriggerEvent
+	simulateFormat     string
+)
+
+var simulateTriggerCmd = &cobra.Command{
+	Use:   "simulate-trigger",
+	Short: "Shows which workflows and pipelines a git event would start",
+	Long: `Shows which workflows and pipelines a git event would start.
+
+Evaluates the config's trigger_map and the triggers blocks of its workflows and pipelines
+against a synthetic event. The event type is inferred from the flags if --type is not given:
+--tag means a tag, --source-branch or --target-branch a pull request, otherwise a push.`,
+	Example: `  workflow-editor simulate-trigger --branch main --changed-file ios/App.swift
+  workflow-editor simulate-trigger --source-branch feature/login --target-branch main --draft
+  workflow-editor simulate-trigger --tag v1.2.0`,
+	Run: func(cmd *cobra.Command, args []string) {
+		event := simulateEvent
+		if event.Type == "" {
+			event.Type = inferTriggerEventType(event)
+		}
+
+		pth := simulateConfigPath
+		if pth == "" {
+			currentDir, err := filepath.Abs("./")
+			if err != nil {
+				failf("Failed to get current dir, error: %s", err)
+			}
+			if pth, err = findBitriseConfig(currentDir); err != nil {
+				failf("Failed to search for bitrise config, error: %s", err)
+			} else if pth == "" {
+				failf("No bitrise config found, use --config to point to one")
+			}
+		}
+
+		config.IncludeMirrorDir = os.Getenv("BITRISE_INCLUDE_MIRROR_DIR")
+		config.IncludeCacheDir = service.DefaultIncludeCacheDir()
+		contStr, err := service.ReadMergedConfig(pth)
+		if err != nil {
+			failf("Failed to read bitrise config (%s), error: %s", pth, err)
+		}
+
+		simulation, err := service.SimulateTriggers(contStr, event)
+		if err != nil {
+			failf("Failed to simulate triggers, error: %s", err)
+		}
+
+		switch simulateFormat {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(simulation); err != nil {
+				failf("Failed to print result, error: %s", err)
+			}
+		case "text":
+			fmt.Print(formatTriggerSimulation(simulation))
+		default:
+			failf("Unknown --format: %s, expected text or json", simulateFormat)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(simulateTriggerCmd)
+	flags := simulateTriggerCmd.Flags()
+	flags.StringVar(&simulateConfigPath, "config", "", "Bitrise config to evaluate. Searched for upwards to the git root if empty")
+	flags.StringVar(&simulateEvent.Type, "type", "", "Event type: push, pull_request or tag")
+	flags.StringVar(&simulateEvent.Branch, "branch", "", "Pushed branch")
+	flags.StringVar(&simulateEvent.SourceBranch, "source-branch", "", "Source branch of the pull request")
+	flags.StringVar(&simulateEvent.TargetBranch, "target-branch", "", "Target branch of the pull request")
+	flags.BoolVar(&simulateEvent.IsDraftPR, "draft", false, "The pull request is a draft")
+	flags.StringArrayVar(&simulateEvent.Labels, "label", nil, "Label of the pull request, can be repeated")
+	flags.StringVar(&simulateEvent.Comment, "comment", "", "Pull request comment")
+	flags.StringVar(&simulateEvent.Tag, "tag", "", "Pushed tag")
+	flags.StringArrayVar(&simulateEvent.ChangedFiles, "changed-file", nil, "Changed file path, can be repeated")
+	flags.StringVar(&simulateEvent.CommitMessage, "commit-message", "", "Commit message")
+	flags.StringVar(&simulateFormat, "format", "text", "Output format: text or json")
+}
+
+func inferTriggerEventType(event service.TriggerEvent) string {
+	switch {
+	case event.Tag != "":
+		return service.TriggerEventTag
+	case event.SourceBranch != "" || event.TargetBranch != "" || event.IsDraftPR || len(event.Labels) > 0 || event.Comment != "":
+		return service.TriggerEventPullRequest
+	}
+	return service.TriggerEventPush
+}
+
+func formatTriggerSimulation(simulation service.TriggerSimulation) string {
+	var out strings.Builder
+	for _, warning := range simulation.Warnings {
+		fmt.Fprintf(&out, "Warning: %s\n", warning)
+	}
+
+	if len(simulation.Started) == 0 {
+		out.WriteString("No workflow or pipeline would start.\n")
+	} else {
+		out.WriteString("Would start:\n")
+		for _, match := range simulation.Started {
+			fmt.Fprintf(&out, "  %s %s (%s: %s)\n", match.TargetType, match.Target, match.Source, strings.Join(match.Reasons, ", "))
+		}
+	}
+
+	var skipped []service.TriggerMatch
+	for _, match := range simulation.Evaluated {
+		if !match.Matched {
+			skipped = append(skipped, match)
+		}
+	}
+	if len(skipped) > 0 {
+		out.WriteString("Not matching:\n")
+		for _, match := range skipped {
+			fmt.Fprintf(&out, "  %s %s (%s: %s)\n", match.TargetType, match.Target, match.Source, strings.Join(match.Reasons, ", "))
+		}
+	}
+	return out.String()
+}
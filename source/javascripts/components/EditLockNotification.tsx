@@ -0,0 +1,23 @@
+import { BitkitAlert } from '@bitrise/bitkit-v2';
+
+import { useEditLockStore } from '@/core/stores/EditLockStore';
+
+const EditLockNotification = () => {
+  const heldElsewhere = useEditLockStore((s) => s.heldElsewhere);
+
+  if (!heldElsewhere) {
+    return null;
+  }
+
+  const since = new Date(heldElsewhere.acquiredAt).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+
+  return (
+    <BitkitAlert
+      variant="warning"
+      titleText="This configuration is open for editing in another tab"
+      messageText={`The other tab has been editing it since ${since}, so this tab is read-only. Close the other tab and reload this one to edit here.`}
+    />
+  );
+};
+
+export default EditLockNotification;
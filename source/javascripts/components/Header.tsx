@@ -30,6 +30,7 @@ import {
   recordActiveTabLocation,
 } from '@/core/stores/BitriseYmlStore';
 import { useCiConfigExpertStore } from '@/core/stores/CiConfigExpertStore';
+import { useEditLockStore } from '@/core/stores/EditLockStore';
 import PageProps from '@/core/utils/PageProps';
 import RuntimeUtils from '@/core/utils/RuntimeUtils';
 import useBitriseYmlStore from '@/hooks/useBitriseYmlStore';
@@ -75,6 +76,7 @@ const Header = () => {
   // YAML view on every schema-invalid load (SSW-3087).
   const ymlStatus = useYmlValidationStatus();
   const isParseError = useIsYmlParseError();
+  const isLockedElsewhere = useEditLockStore((s) => Boolean(s.heldElsewhere));
 
   const [path, navigate] = useHashLocation();
   const [searchParams] = useSearchParams();
@@ -328,7 +330,7 @@ const Header = () => {
   };
 
   useEventListener('keydown', (e: KeyboardEvent) => {
-    if ((e.ctrlKey || e.metaKey) && e.key === 's' && hasChanges && !isSavingConfig) {
+    if ((e.ctrlKey || e.metaKey) && e.key === 's' && hasChanges && !isSavingConfig && !isLockedElsewhere) {
       e.preventDefault();
 
       if (ymlStatus === 'invalid') {
@@ -436,7 +438,7 @@ const Header = () => {
             className="save"
             variant="primary"
             isLoading={isSavingConfig}
-            isDisabled={!hasChanges || ymlStatus === 'invalid'}
+            isDisabled={!hasChanges || ymlStatus === 'invalid' || isLockedElsewhere}
             onClick={() => saveCIConfig('save_changes_button')}
           >
             Save changes
@@ -3,9 +3,10 @@ import { BitkitAlert } from '@bitrise/bitkit-v2';
 import { useReadOnlyView } from '@/hooks/useTree';
 
 const ReadOnlyViewNotification = () => {
-  const { isReadOnly, isMergedConfig } = useReadOnlyView();
+  const { isReadOnly, isMergedConfig, isLockedElsewhere } = useReadOnlyView();
 
-  if (!isReadOnly) {
+  // The edit lock has its own banner on every page, see EditLockNotification.
+  if (!isReadOnly || isLockedElsewhere) {
     return null;
   }
 
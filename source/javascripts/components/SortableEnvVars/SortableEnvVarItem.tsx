@@ -16,6 +16,8 @@ export type SortableEnvVar = EnvVar & {
 export type SortableEnvVarItemProps = {
   env: SortableEnvVar;
   isDragging?: boolean;
+  /** Overrides the view's read-only state, e.g. for env vars written straight to disk locally. */
+  isReadOnly?: boolean;
   onRemove?: () => void;
   onKeyChange?: (key: string) => void;
   onValueChange?: (value: string) => void;
@@ -27,13 +29,15 @@ export type SortableEnvVarItemProps = {
 const SortableEnvVarItem = ({
   env,
   isDragging,
+  isReadOnly,
   onRemove,
   onKeyChange,
   onValueChange,
   onIsExpandChange,
   jumpButton,
 }: SortableEnvVarItemProps) => {
-  const isReadOnlyView = useIsReadOnlyView();
+  const isReadOnlyViewState = useIsReadOnlyView();
+  const isReadOnlyView = isReadOnly ?? isReadOnlyViewState;
   const sortable = useSortable({ id: env.uniqueId, data: env, disabled: isReadOnlyView });
 
   const [errors, setErrors] = useState({
@@ -5,6 +5,7 @@ import { SortableContext, verticalListSortingStrategy } from '@dnd-kit/sortable'
 import { ReactNode, useEffect } from 'react';
 
 import { EnvVar, EnvVarSource } from '@/core/models/EnvVar';
+import { LocalEnvVarTarget } from '@/hooks/useLocalEnvVarWrite';
 import { useIsReadOnlyView } from '@/hooks/useTree';
 
 import SortableEnvVarItem, { SortableEnvVar } from './SortableEnvVarItem';
@@ -23,6 +24,9 @@ export type SortableEnvVarsProps = {
   renderJumpButton?: (env: SortableEnvVar) => ReactNode;
   /** Placeholder shown on read-only views when there are no env vars (and thus no "Add new" button). */
   emptyText?: string;
+  /** Local (CLI) mode: the `initialEnvs` list is editable on read-only views too, written straight
+   * to its module on disk. */
+  localWrite?: LocalEnvVarTarget;
 };
 
 const SortableEnvVars = ({
@@ -35,8 +39,9 @@ const SortableEnvVars = ({
   initialEnvs,
   renderJumpButton,
   emptyText,
+  localWrite,
 }: SortableEnvVarsProps) => {
-  const isReadOnlyView = useIsReadOnlyView();
+  const isReadOnlyView = useIsReadOnlyView() && !localWrite;
   const {
     envs,
     activeItem,
@@ -49,7 +54,7 @@ const SortableEnvVars = ({
     onValueChange,
     onIsExpandChange,
     countValidationErrors,
-  } = useSortableEnvVars({ source, sourceId, listenForExternalChanges, initialEnvs });
+  } = useSortableEnvVars({ source, sourceId, listenForExternalChanges, initialEnvs, localWrite });
 
   useEffect(() => {
     if (onValidationErrorsChange) {
@@ -71,6 +76,7 @@ const SortableEnvVars = ({
             <SortableEnvVarItem
               key={env.uniqueId}
               env={env}
+              isReadOnly={isReadOnlyView}
               onRemove={onRemove(index)}
               onKeyChange={onKeyChange(index)}
               onValueChange={onValueChange(index)}
@@ -79,7 +85,9 @@ const SortableEnvVars = ({
             />
           ))}
         </SortableContext>
-        <DragOverlay>{activeItem && <SortableEnvVarItem env={activeItem} isDragging />}</DragOverlay>
+        <DragOverlay>
+          {activeItem && <SortableEnvVarItem env={activeItem} isReadOnly={isReadOnlyView} isDragging />}
+        </DragOverlay>
       </DndContext>
 
       {/* Read-only view with nothing to show (no rows, no "Add new") would leave an empty box. */}
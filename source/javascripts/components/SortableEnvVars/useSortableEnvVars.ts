@@ -1,12 +1,13 @@
 import { DragEndEvent, DragStartEvent } from '@dnd-kit/core';
 import { arrayMove } from '@dnd-kit/sortable';
-import { useEffect, useState } from 'react';
+import { useEffect, useRef, useState } from 'react';
 import { useDebounceCallback } from 'usehooks-ts';
 
 import { SortableEnvVar } from '@/components/SortableEnvVars/SortableEnvVarItem';
 import { EnvVar, EnvVarSource } from '@/core/models/EnvVar';
 import EnvVarService from '@/core/services/EnvVarService';
 import useBitriseYmlStore from '@/hooks/useBitriseYmlStore';
+import useLocalEnvVarWrite, { LocalEnvVarTarget } from '@/hooks/useLocalEnvVarWrite';
 
 import { listenToEnvVarCreated } from './SortableEnvVars.events';
 
@@ -17,16 +18,23 @@ type UseSortableEnvVarsProps = {
   /** Pre-resolved env vars to display instead of reading from the store — used by the merged read-only
    * per-file grouping (where the list comes from a specific file, not the active document). */
   initialEnvs?: EnvVar[];
+  /** Local (CLI) mode: write the changes of the `initialEnvs` list to its module on disk instead of
+   * editing the store. */
+  localWrite?: LocalEnvVarTarget;
 };
 
+/** A row of a `localWrite` list, with the key it's saved under (unset until first saved). */
+type LocalSortableEnvVar = SortableEnvVar & { savedKey?: string };
+
 export const useSortableEnvVars = ({
   source,
   sourceId,
   listenForExternalChanges = false,
   initialEnvs,
+  localWrite,
 }: UseSortableEnvVarsProps) => {
   const [activeItem, setActiveItem] = useState<SortableEnvVar>();
-  const [envs, setEnvs] = useState<SortableEnvVar[]>([]);
+  const [envs, setEnvs] = useState<LocalSortableEnvVar[]>([]);
   // In modular YAML mode the same `sourceId` can exist in multiple files, so the active
   // file is part of the identity of the env var list — re-seed when it changes.
   const activeFileId = useBitriseYmlStore((s) => s.selectedNodeId);
@@ -34,11 +42,50 @@ export const useSortableEnvVars = ({
   const updateKeyDebounced = useDebounceCallback(EnvVarService.updateKey, 250, { leading: false });
   const updateValueDebounced = useDebounceCallback(EnvVarService.updateValue, 250, { leading: false });
 
-  // `initialEnvs` is fixed to a specific file, so the active-file change must not re-seed it;
-  // only re-seed on active-file changes when the list is read from the store.
-  const reseedFileId = initialEnvs ? undefined : activeFileId;
+  const { mutate: writeLocally, isPending: isLocalWritePending } = useLocalEnvVarWrite(localWrite ?? { file: '' });
+  // The rows as of the last render, for the debounced saves.
+  const envsRef = useRef(envs);
+  useEffect(() => {
+    envsRef.current = envs;
+  }, [envs]);
+  // Saves a row of a `localWrite` list: updates it under its saved key, or creates it once it has a
+  // valid key. Writes run one after the other, so the row is saved under its new key right away.
+  const saveLocally = (uniqueId: string) => {
+    const env = envsRef.current.find((row) => row.uniqueId === uniqueId);
+    if (!env || (!env.savedKey && EnvVarService.validateKey(env.key) !== true)) {
+      return;
+    }
+    writeLocally(env.savedKey ? { type: 'update', key: env.savedKey, envVar: env } : { type: 'create', envVar: env });
+    setEnvs((oldEnvs) => oldEnvs.map((row) => (row.uniqueId === uniqueId ? { ...row, savedKey: env.key } : row)));
+  };
+  const saveLocallyDebounced = useDebounceCallback(saveLocally, 500, { leading: false });
+  // The debounce is shared by the rows, so a pending save is flushed before another row is edited.
+  const debouncedRowId = useRef<string>();
+  const scheduleLocalSave = (uniqueId: string) => {
+    if (debouncedRowId.current !== uniqueId) {
+      saveLocallyDebounced.flush();
+      debouncedRowId.current = uniqueId;
+    }
+    saveLocallyDebounced(uniqueId);
+  };
 
   useEffect(() => {
+    if (localWrite) {
+      // Re-seeded from the reloaded config after each write, keeping the rows' identity so the
+      // inputs keep focus; not while a write is on the way, as it'd revert the edits it carries.
+      if (isLocalWritePending || saveLocallyDebounced.isPending()) {
+        return;
+      }
+      setEnvs((oldEnvs) =>
+        (initialEnvs ?? []).map((env, index) => ({
+          ...env,
+          savedKey: env.key,
+          uniqueId: oldEnvs[index]?.uniqueId ?? crypto.randomUUID(),
+        })),
+      );
+      return;
+    }
+
     const base = initialEnvs ?? EnvVarService.getAll(source, sourceId || '');
     setEnvs(
       base.map((env) => ({
@@ -46,7 +93,8 @@ export const useSortableEnvVars = ({
         uniqueId: crypto.randomUUID(),
       })),
     );
-  }, [source, sourceId, reseedFileId, initialEnvs]);
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [source, sourceId, reseedFileId, initialEnvs, localWrite?.file, isLocalWritePending]);
 
   useEffect(() => {
     if (!listenForExternalChanges) return;
@@ -78,7 +126,12 @@ export const useSortableEnvVars = ({
       });
 
       setEnvs(reorderedEnvs);
-      EnvVarService.reorder(newIndices, { source, sourceId });
+      if (!localWrite) {
+        EnvVarService.reorder(newIndices, { source, sourceId });
+      } else if (reorderedEnvs.every((env) => env.savedKey)) {
+        saveLocallyDebounced.flush();
+        writeLocally({ type: 'reorder', keys: reorderedEnvs.map((env) => env.savedKey as string) });
+      }
     }
 
     setActiveItem(undefined);
@@ -90,25 +143,55 @@ export const useSortableEnvVars = ({
 
   const onAdd = () => {
     setEnvs([...envs, { uniqueId: crypto.randomUUID(), ...EnvVarService.EMPTY_ENV_VAR }]);
-    EnvVarService.create({ source, sourceId });
+    // A new row of a `localWrite` list is saved once it has a key.
+    if (!localWrite) {
+      EnvVarService.create({ source, sourceId });
+    }
   };
 
   const onRemove = (index: number) => () => {
+    const { savedKey } = envs[index];
     setEnvs(envs.filter((_, i) => i !== index));
-    EnvVarService.remove({ source, sourceId, index });
+    if (!localWrite) {
+      EnvVarService.remove({ source, sourceId, index });
+    } else if (savedKey) {
+      saveLocallyDebounced.flush();
+      writeLocally({ type: 'delete', key: savedKey });
+    }
+  };
+
+  // Updates a row of a `localWrite` list and schedules saving it.
+  const changeLocally = (index: number, change: Partial<EnvVar>) => {
+    const env = { ...envs[index], ...change };
+    const nextEnvs = envs.map((oldEnv, i) => (i === index ? env : oldEnv));
+    envsRef.current = nextEnvs;
+    setEnvs(nextEnvs);
+    scheduleLocalSave(env.uniqueId);
   };
 
   const onKeyChange = (index: number) => (key: string) => {
+    if (localWrite) {
+      changeLocally(index, { key });
+      return;
+    }
     setEnvs(envs.map((env, i) => (i === index ? { ...env, key } : env)));
     updateKeyDebounced(key, { source, sourceId, index, oldKey: envs[index].key });
   };
 
   const onValueChange = (index: number) => (value: string) => {
+    if (localWrite) {
+      changeLocally(index, { value });
+      return;
+    }
     setEnvs(envs.map((env, i) => (i === index ? { ...env, value } : env)));
     updateValueDebounced(value, { source, sourceId, index, key: envs[index].key });
   };
 
   const onIsExpandChange = (index: number) => (isExpand: boolean) => {
+    if (localWrite) {
+      changeLocally(index, { isExpand });
+      return;
+    }
     setEnvs(envs.map((env, i) => (i === index ? { ...env, isExpand } : env)));
     EnvVarService.updateIsExpand(isExpand, { source, sourceId, index });
   };
@@ -1,4 +1,4 @@
-import { EnvVar } from '@/core/models/EnvVar';
+import { EnvVar, EnvVarSource } from '@/core/models/EnvVar';
 
 import Client from './client';
 
@@ -62,9 +62,151 @@ async function getEnvVars({ appSlug, projectType, signal }: Partial<GetEnvVarsPr
   return getDefaultOutputs({ appSlug, signal, projectType });
 }
 
-export type { DefaultOutputsResponse };
+// Local (CLI mode) app and workflow env vars of the saved config, written to the module defining them.
+type LocalEnvVarItem = {
+  key: string;
+  value: string;
+  is_expand: boolean;
+  opts?: Record<string, unknown>;
+  file: string;
+  editable: boolean;
+};
+
+type LocalEnvVarsResponse = {
+  app: LocalEnvVarItem[];
+  workflows: Record<string, LocalEnvVarItem[]>;
+};
+
+type LocalEnvVar = EnvVar & { file: string; editable: boolean };
+
+type LocalEnvVars = {
+  app: LocalEnvVar[];
+  workflows: Record<string, LocalEnvVar[]>;
+};
+
+const ENV_VARS_LOCAL_PATH = '/api/env-vars';
+const CI_CONFIG_VERSION_HEADER = 'Bitrise-Config-Version';
+
+function getLocalEnvVarsPath(workflowId?: string, key?: string) {
+  const scopePath = workflowId
+    ? `${ENV_VARS_LOCAL_PATH}/workflows/${encodeURIComponent(workflowId)}`
+    : `${ENV_VARS_LOCAL_PATH}/app`;
+  return key ? `${scopePath}/${encodeURIComponent(key)}` : scopePath;
+}
+
+// The saved config's version, if known, so a write on top of a changed config fails with a conflict.
+function versionHeaders(version?: string): HeadersInit {
+  return version ? { [CI_CONFIG_VERSION_HEADER]: version } : {};
+}
+
+function fromLocalEnvVarItem(item: LocalEnvVarItem, source: string): LocalEnvVar {
+  return {
+    key: item.key,
+    value: item.value,
+    source,
+    isExpand: item.is_expand,
+    file: item.file,
+    editable: item.editable,
+  };
+}
+
+function fromLocalEnvVarsResponse(response?: LocalEnvVarsResponse): LocalEnvVars {
+  return {
+    app: (response?.app ?? []).map((item) => fromLocalEnvVarItem(item, EnvVarSource.App)),
+    workflows: Object.fromEntries(
+      Object.entries(response?.workflows ?? {}).map(([id, items]) => [
+        id,
+        items.map((item) => fromLocalEnvVarItem(item, EnvVarSource.Workflows)),
+      ]),
+    ),
+  };
+}
+
+function toLocalEnvVarRequest(envVar: EnvVar, file?: string) {
+  return JSON.stringify({ key: envVar.key, value: envVar.value, is_expand: envVar.isExpand, file });
+}
+
+async function getLocalEnvVars({ signal }: { signal?: AbortSignal } = {}): Promise<LocalEnvVars> {
+  return fromLocalEnvVarsResponse(await Client.get<LocalEnvVarsResponse>(ENV_VARS_LOCAL_PATH, { signal }));
+}
+
+type LocalEnvVarWriteProps = {
+  workflowId?: string;
+  // The module to write; for updates and deletes, the effective definition's module by default.
+  file?: string;
+  version?: string;
+  signal?: AbortSignal;
+};
+
+async function createLocalEnvVar({
+  workflowId,
+  file,
+  version,
+  envVar,
+  signal,
+}: LocalEnvVarWriteProps & { envVar: EnvVar }): Promise<LocalEnvVars> {
+  const response = await Client.post<LocalEnvVarsResponse>(getLocalEnvVarsPath(workflowId), {
+    headers: versionHeaders(version),
+    body: toLocalEnvVarRequest(envVar, file),
+    signal,
+  });
+  return fromLocalEnvVarsResponse(response);
+}
+
+async function updateLocalEnvVar({
+  workflowId,
+  file,
+  version,
+  key,
+  envVar,
+  signal,
+}: LocalEnvVarWriteProps & { key: string; envVar: EnvVar }): Promise<LocalEnvVars> {
+  const response = await Client.put<LocalEnvVarsResponse>(getLocalEnvVarsPath(workflowId, key), {
+    headers: versionHeaders(version),
+    body: toLocalEnvVarRequest(envVar, file),
+    signal,
+  });
+  return fromLocalEnvVarsResponse(response);
+}
+
+async function deleteLocalEnvVar({
+  workflowId,
+  file,
+  version,
+  key,
+  signal,
+}: LocalEnvVarWriteProps & { key: string }): Promise<LocalEnvVars> {
+  const path = getLocalEnvVarsPath(workflowId, key);
+  const response = await Client.del<LocalEnvVarsResponse>(
+    file ? `${path}?${new URLSearchParams({ file }).toString()}` : path,
+    { headers: versionHeaders(version), signal },
+  );
+  return fromLocalEnvVarsResponse(response);
+}
+
+async function reorderLocalEnvVars({
+  workflowId,
+  file,
+  version,
+  keys,
+  signal,
+}: LocalEnvVarWriteProps & { keys: string[] }): Promise<LocalEnvVars> {
+  const response = await Client.put<LocalEnvVarsResponse>(getLocalEnvVarsPath(workflowId), {
+    headers: versionHeaders(version),
+    body: JSON.stringify({ file, keys }),
+    signal,
+  });
+  return fromLocalEnvVarsResponse(response);
+}
+
+export type { DefaultOutputsResponse, LocalEnvVar, LocalEnvVars };
 
 export default {
   getEnvVars,
   getDefaultOutputsPath,
+  getLocalEnvVars,
+  createLocalEnvVar,
+  updateLocalEnvVar,
+  deleteLocalEnvVar,
+  reorderLocalEnvVars,
 };
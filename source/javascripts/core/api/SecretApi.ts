@@ -13,6 +13,9 @@ type ApiSecretItem = { [key: string]: unknown } & {
         is_expose?: boolean;
         is_protected?: boolean;
       };
+      workflow_editor?: {
+        source?: string;
+      };
     };
   };
 };
@@ -63,15 +66,23 @@ function toMonolithUpdateRequest(secret: Secret): SecretMonolithUpdateRequest {
   };
 }
 
+// The local API masks secret values with this sentinel; sending it back keeps the saved value.
+const MASKED_SECRET_VALUE = '__BITRISE_SECRET_UNCHANGED__';
+
+// Secrets from the secrets file are shown as 'Secrets', provided ones by their provider (e.g. 'dotenv:.env').
+function fromLocalSource(source?: string): string {
+  return !source || source === 'secrets-file' ? 'Secrets' : source;
+}
+
 function fromLocalResponse(response: LocalSecretItem): Secret {
   const keyValue = Object.entries(response).find(([key]) => key !== 'opts') ?? ['', ''];
 
   return {
     key: keyValue[0],
-    value: keyValue[1] as string,
-    source: 'Secrets',
+    value: keyValue[1] === MASKED_SECRET_VALUE ? undefined : (keyValue[1] as string),
+    source: fromLocalSource(response.opts?.meta?.workflow_editor?.source),
     scope: response.opts?.scope || 'app',
-    isExpand: Boolean(response.opts?.is_expand),
+    isExpand: response.opts?.is_expand !== false,
     isExpose: Boolean(response.opts?.meta?.['bitrise.io']?.is_expose),
     isShared: response.opts?.scope === SecretScope.WORKSPACE,
     isProtected: Boolean(response.opts?.meta?.['bitrise.io']?.is_protected),
@@ -82,8 +93,10 @@ function fromLocalResponse(response: LocalSecretItem): Secret {
 }
 
 function toLocalUpdateRequest(secret: Secret): LocalSecretItem {
+  const value = secret.value === undefined && secret.isSaved ? MASKED_SECRET_VALUE : secret.value;
+
   return {
-    [secret.key]: secret.value ?? null,
+    [secret.key]: value ?? null,
     opts: {
       is_expand: secret.isExpand,
       meta: {
@@ -114,6 +127,10 @@ function getSecretLocalPath() {
   return SECRETS_LOCAL_PATH;
 }
 
+function getSecretItemLocalPath(secretKey: string) {
+  return `${SECRETS_LOCAL_PATH}/${encodeURIComponent(secretKey)}`;
+}
+
 async function getSecrets({ signal, ...params }: { appSlug: string; signal?: AbortSignal }): Promise<Secret[]> {
   if (RuntimeUtils.isWebsiteMode()) {
     const response = await Client.get<SecretsMonolithResponse>(getSecretPath(params.appSlug), { signal });
@@ -144,7 +161,10 @@ async function getSecretValue({
   }
 
   // CLI mode
-  return undefined;
+  const response = await Client.get<{ key: string; value: string }>(getSecretItemLocalPath(params.secretKey), {
+    signal,
+  });
+  return response.value;
 }
 
 async function upsertSecret({
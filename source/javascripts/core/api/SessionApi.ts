@@ -0,0 +1,73 @@
+import SessionUtils from '@/core/utils/SessionUtils';
+
+import Client, { ClientError } from './client';
+
+// Local (CLI mode) editor sessions and the advisory edit locks they hold on the server.
+type EditLockApiItem = {
+  resource: string;
+  session_id: string;
+  acquired_at: string;
+  expires_at: string;
+};
+
+type EditLockResponse = {
+  lock: EditLockApiItem;
+  granted: boolean;
+};
+
+type EditLock = {
+  resource: string;
+  sessionId: string;
+  acquiredAt: string;
+  expiresAt: string;
+};
+
+const CONNECTION_PATH = '/api/connection';
+const LOCKS_PATH = '/api/locks';
+
+// The whole config is locked as one resource: the visual editor changes several modules at once.
+const CONFIG_LOCK_RESOURCE = 'bitrise.yml';
+
+function sessionBody(extra?: Record<string, string>) {
+  return JSON.stringify({ session_id: SessionUtils.getSessionId(), ...extra });
+}
+
+function connect() {
+  return Client.post(CONNECTION_PATH, { body: sessionBody() });
+}
+
+function disconnect() {
+  return Client.del(CONNECTION_PATH, { body: sessionBody(), keepalive: true });
+}
+
+function toEditLock(item: EditLockApiItem): EditLock {
+  return {
+    resource: item.resource,
+    sessionId: item.session_id,
+    acquiredAt: item.acquired_at,
+    expiresAt: item.expires_at,
+  };
+}
+
+async function acquireEditLock(resource = CONFIG_LOCK_RESOURCE): Promise<{ granted: boolean; lock: EditLock }> {
+  try {
+    const response = await Client.post<EditLockResponse>(LOCKS_PATH, { body: sessionBody({ resource }) });
+    if (!response) {
+      throw new Error('Empty edit lock response');
+    }
+    return { granted: true, lock: toEditLock(response.lock) };
+  } catch (error) {
+    // Another tab holds the lock: the response names it.
+    if (error instanceof ClientError && error.status === 409 && error.data?.lock) {
+      return { granted: false, lock: toEditLock(error.data.lock as EditLockApiItem) };
+    }
+    throw error;
+  }
+}
+
+function releaseEditLock(resource = CONFIG_LOCK_RESOURCE) {
+  return Client.del(LOCKS_PATH, { body: sessionBody({ resource }), keepalive: true });
+}
+
+export type { EditLock };
+export default { connect, disconnect, acquireEditLock, releaseEditLock };
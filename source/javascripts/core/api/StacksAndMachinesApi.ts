@@ -1,3 +1,5 @@
+import RuntimeUtils from '@/core/utils/RuntimeUtils';
+
 import { MachineRegionName, MachineType, Stack, StackOS, StackStatus } from '../models/StackAndMachine';
 import Client from './client';
 
@@ -126,8 +128,14 @@ function toMachineType(item: MachineApiItem): MachineType {
 }
 
 const GET_STACKS_AND_MACHINES_PATH = `/app/:appSlug/stacks_and_machines`;
+const GET_STACKS_AND_MACHINES_PATH_LOCAL = '/api/stacks-and-machines';
 
+// In CLI mode the catalog is served by the local server (bundled or --stacks-catalog).
 function getStacksAndMachinesPath(appSlug: string): string {
+  if (RuntimeUtils.isLocalMode()) {
+    return GET_STACKS_AND_MACHINES_PATH_LOCAL;
+  }
+
   return GET_STACKS_AND_MACHINES_PATH.replace(':appSlug', appSlug);
 }
 
@@ -0,0 +1,12 @@
+import { create } from 'zustand';
+
+import { EditLock } from '@/core/api/SessionApi';
+
+type State = {
+  // The config's edit lock when another tab holds it; this tab is read-only then.
+  heldElsewhere: EditLock | undefined;
+};
+
+export const useEditLockStore = create<State>(() => ({
+  heldElsewhere: undefined,
+}));
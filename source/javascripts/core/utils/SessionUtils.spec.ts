@@ -0,0 +1,32 @@
+import SessionUtils from './SessionUtils';
+
+describe('SessionUtils', () => {
+  describe('generateSessionId', () => {
+    it('should use crypto.randomUUID when available', () => {
+      const randomUUID = jest.spyOn(crypto, 'randomUUID').mockReturnValue('8a3f2c4e-0000-4000-8000-000000000000');
+
+      expect(SessionUtils.generateSessionId()).toBe('8a3f2c4e-0000-4000-8000-000000000000');
+
+      randomUUID.mockRestore();
+    });
+
+    it('should fall back to crypto.getRandomValues outside secure contexts', () => {
+      const { randomUUID } = crypto;
+      Object.defineProperty(crypto, 'randomUUID', { value: undefined, configurable: true });
+
+      try {
+        const id = SessionUtils.generateSessionId();
+        expect(id).toMatch(/^[0-9a-f]{32}$/);
+        expect(SessionUtils.generateSessionId()).not.toBe(id);
+      } finally {
+        Object.defineProperty(crypto, 'randomUUID', { value: randomUUID, configurable: true });
+      }
+    });
+  });
+
+  describe('getSessionId', () => {
+    it('should return the same id for the lifetime of the tab', () => {
+      expect(SessionUtils.getSessionId()).toBe(SessionUtils.getSessionId());
+    });
+  });
+});
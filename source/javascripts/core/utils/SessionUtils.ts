@@ -0,0 +1,22 @@
+// crypto.randomUUID is only defined in secure contexts, but the editor can be served over plain HTTP
+// (e.g. with --remote or --bind), so fall back to crypto.getRandomValues there.
+function generateSessionId(): string {
+  if (typeof crypto.randomUUID === 'function') {
+    return crypto.randomUUID();
+  }
+
+  const bytes = crypto.getRandomValues(new Uint8Array(16));
+  return Array.from(bytes, (byte) => byte.toString(16).padStart(2, '0')).join('');
+}
+
+// The id this tab registers with the local API server, for its heartbeats and edit locks.
+const sessionId = generateSessionId();
+
+function getSessionId() {
+  return sessionId;
+}
+
+export default {
+  generateSessionId,
+  getSessionId,
+};
@@ -0,0 +1,81 @@
+import { useEffect } from 'react';
+
+import SessionApi from '@/core/api/SessionApi';
+import { useEditLockStore } from '@/core/stores/EditLockStore';
+import RuntimeUtils from '@/core/utils/RuntimeUtils';
+
+// The lock is a lease on the server: the holder renews it (by acquiring it again) on this interval
+// and as soon as it's visible again, so a crashed tab's lock frees up shortly.
+const RENEW_INTERVAL = 20_000;
+
+// In CLI mode the first tab opening the config takes its advisory edit lock on the local server;
+// later tabs open read-only until they're reloaded after the holder is gone. The server also
+// releases the lock when the holder's session disconnects or its lease isn't renewed.
+const useEditLock = () => {
+  useEffect(() => {
+    if (!RuntimeUtils.isProduction() || !RuntimeUtils.isLocalMode()) {
+      return undefined;
+    }
+
+    let isUnmounted = false;
+    let isGranted = false;
+    let renewTimer: number | undefined;
+
+    const renew = () => {
+      SessionApi.acquireEditLock()
+        .then(({ granted, lock }) => {
+          if (isUnmounted) {
+            return;
+          }
+          if (!granted) {
+            // The lease lapsed (e.g. the tab was asleep) and another tab took the lock.
+            isGranted = false;
+            window.clearInterval(renewTimer);
+            useEditLockStore.setState({ heldElsewhere: lock });
+          }
+        })
+        .catch((error) => {
+          // eslint-disable-next-line no-console
+          console.error('Failed to renew the edit lock:', error);
+        });
+    };
+    const renewWhenVisible = () => {
+      if (isGranted && document.visibilityState === 'visible') {
+        renew();
+      }
+    };
+
+    SessionApi.connect()
+      .then(() => SessionApi.acquireEditLock())
+      .then(({ granted, lock }) => {
+        isGranted = granted;
+        if (isUnmounted) {
+          if (granted) {
+            SessionApi.releaseEditLock();
+          }
+          return;
+        }
+        useEditLockStore.setState({ heldElsewhere: granted ? undefined : lock });
+        if (granted) {
+          renewTimer = window.setInterval(renew, RENEW_INTERVAL);
+          document.addEventListener('visibilitychange', renewWhenVisible);
+        }
+      })
+      .catch((error) => {
+        // Locking is advisory: if it fails, the tab stays editable like before.
+        // eslint-disable-next-line no-console
+        console.error('Failed to acquire the edit lock:', error);
+      });
+
+    return () => {
+      isUnmounted = true;
+      window.clearInterval(renewTimer);
+      document.removeEventListener('visibilitychange', renewWhenVisible);
+      if (isGranted) {
+        SessionApi.releaseEditLock();
+      }
+    };
+  }, []);
+};
+
+export default useEditLock;
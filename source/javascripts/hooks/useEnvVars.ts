@@ -9,6 +9,7 @@ import EnvVarService from '@/core/services/EnvVarService';
 import StepService from '@/core/services/StepService';
 import WorkflowService from '@/core/services/WorkflowService';
 import PageProps from '@/core/utils/PageProps';
+import RuntimeUtils from '@/core/utils/RuntimeUtils';
 import YmlUtils from '@/core/utils/YmlUtils';
 import useBitriseYmlStore from '@/hooks/useBitriseYmlStore';
 
@@ -28,6 +29,17 @@ const useDefaultEnvVars = (enabled: boolean) => {
   });
 };
 
+// Local (CLI) mode: the apiserver lists the env vars of every module of the saved config, so the
+// workflows' envs are offered even when the workflow is defined in another module than the active
+// one. Unsaved edits in the store override them.
+const useLocalEnvVars = (enabled: boolean) => {
+  return useQuery({
+    enabled: enabled && RuntimeUtils.isLocalMode(),
+    queryKey: ['local-env-vars'],
+    queryFn: ({ signal }) => EnvVarsApi.getLocalEnvVars({ signal }),
+  });
+};
+
 const useAppLevelEnvVars = () => {
   return useBitriseYmlStore((s) => {
     const envVarMap = new Map<string, EnvVar>();
@@ -166,11 +178,20 @@ const useEnvVars = ({ enabled, stepBundleIds, workflowIds }: Props) => {
   const workflowLevelEnvVars = useWorkflowLevelEnvVars(workflowIds);
   const { data: defaultEnvVars, isLoading: isLoadingDefaultEnvVars } = useDefaultEnvVars(enabled);
   const { data: stepLevelEnvVars, isLoading: isLoadingStepLevelEnvVars } = useStepLevelEnvVars(workflowIds, enabled);
+  const { data: localEnvVars, isLoading: isLoadingLocalEnvVars } = useLocalEnvVars(enabled);
 
-  const isLoading = isLoadingDefaultEnvVars || isLoadingStepLevelEnvVars;
+  const isLoading = isLoadingDefaultEnvVars || isLoadingStepLevelEnvVars || isLoadingLocalEnvVars;
 
   if (!isLoading && defaultEnvVars && stepLevelEnvVars) {
     defaultEnvVars.forEach((env) => envVarMap.set(env.key, env));
+    localEnvVars?.app.forEach((env) =>
+      envVarMap.set(env.key, { ...env, source: `Project env vars • defined in ${env.file}` }),
+    );
+    workflowIds.forEach((id) => {
+      localEnvVars?.workflows[id]?.forEach((env) =>
+        envVarMap.set(env.key, { ...env, source: `Workflow: ${id} • defined in ${env.file}` }),
+      );
+    });
     appLevelEnvVars.forEach((env) => envVarMap.set(env.key, env));
     stepBundleLevelEnvVars.forEach((env) => envVarMap.set(env.key, env));
     workflowLevelEnvVars.forEach((env) => envVarMap.set(env.key, env));
@@ -0,0 +1,79 @@
+import { useToast } from '@bitrise/bitkit';
+import { useMutation, useQueryClient } from '@tanstack/react-query';
+
+import BitriseYmlApi from '@/core/api/BitriseYmlApi';
+import { ClientError } from '@/core/api/client';
+import EnvVarsApi from '@/core/api/EnvVarsApi';
+import { EnvVar } from '@/core/models/EnvVar';
+import { applyModularSaveResult, bitriseYmlStore, initializeBitriseYmlDocument } from '@/core/stores/BitriseYmlStore';
+import PageProps from '@/core/utils/PageProps';
+import { CI_CONFIG_TREE_QUERY_KEY } from '@/hooks/useCiConfigTree';
+
+export type LocalEnvVarTarget = {
+  /** The module the list is in, relative to the repository root. */
+  file: string;
+  /** The workflow whose envs are written; the app envs if unset. */
+  workflowId?: string;
+};
+
+export type LocalEnvVarWrite =
+  | { type: 'create'; envVar: EnvVar }
+  | { type: 'update'; key: string; envVar: EnvVar }
+  | { type: 'delete'; key: string }
+  | { type: 'reorder'; keys: string[] };
+
+/**
+ * Local (CLI) mode: writes an env var change straight to the module on disk via `/api/env-vars`,
+ * then reloads the saved config so the store (and the version the next write is checked against)
+ * reflects it. The store isn't written, so edits made elsewhere and not saved yet would be
+ * dropped by the reload — callers only offer this while the config has no unsaved changes.
+ */
+function useLocalEnvVarWrite(target: LocalEnvVarTarget) {
+  const toast = useToast();
+  const queryClient = useQueryClient();
+  const appSlug = PageProps.appSlug();
+
+  return useMutation({
+    // Writes run one after the other, each on top of the previous one.
+    scope: { id: 'local-env-var-write' },
+    mutationFn: (write: LocalEnvVarWrite) => {
+      const props = { ...target, version: bitriseYmlStore.getState().version };
+      switch (write.type) {
+        case 'create':
+          return EnvVarsApi.createLocalEnvVar({ ...props, envVar: write.envVar });
+        case 'update':
+          return EnvVarsApi.updateLocalEnvVar({ ...props, key: write.key, envVar: write.envVar });
+        case 'delete':
+          return EnvVarsApi.deleteLocalEnvVar({ ...props, key: write.key });
+        case 'reorder':
+          return EnvVarsApi.reorderLocalEnvVars({ ...props, keys: write.keys });
+      }
+    },
+    onSuccess: async (envVars) => {
+      queryClient.setQueryData(['local-env-vars'], envVars);
+
+      if (bitriseYmlStore.getState().tree) {
+        const config = await BitriseYmlApi.getConfig({ projectSlug: appSlug });
+        applyModularSaveResult({ root: config.root });
+        // Keep React Query's cache in sync, else a re-seed from it would revert the write.
+        queryClient.setQueriesData({ queryKey: [CI_CONFIG_TREE_QUERY_KEY, appSlug] }, config);
+      } else {
+        initializeBitriseYmlDocument(await BitriseYmlApi.getCiConfig({ projectSlug: appSlug }));
+      }
+    },
+    onError: (error) => {
+      toast({
+        title: 'Failed to save the Environment Variable',
+        description:
+          (error instanceof ClientError ? error.getResponseErrorMessage() : undefined) ||
+          error.message ||
+          'Something went wrong',
+        status: 'error',
+        duration: null,
+        isClosable: true,
+      });
+    },
+  });
+}
+
+export default useLocalEnvVarWrite;
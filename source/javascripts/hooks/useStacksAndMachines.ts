@@ -9,7 +9,7 @@ const useStacksAndMachines = () => {
   const isWebsiteMode = RuntimeUtils.isWebsiteMode();
 
   return useQuery({
-    enabled: Boolean(appSlug) && isWebsiteMode,
+    enabled: !isWebsiteMode || Boolean(appSlug),
     queryKey: [StacksAndMachinesApi.getStacksAndMachinesPath(appSlug)],
     queryFn: ({ signal }) => StacksAndMachinesApi.getStacksAndMachines({ appSlug, signal }),
     staleTime: Infinity,
@@ -4,6 +4,7 @@ import { EntityKind, TreeNode } from '@/core/models/Tree';
 import EntityIndexService from '@/core/services/EntityIndexService';
 import TreeService from '@/core/services/TreeService';
 import { bitriseYmlStore, isFileDirty, MERGED_CONFIG_NODE_ID } from '@/core/stores/BitriseYmlStore';
+import { useEditLockStore } from '@/core/stores/EditLockStore';
 import { buildNodeUris } from '@/core/utils/lspModelUris';
 import YmlUtils from '@/core/utils/YmlUtils';
 import useBitriseYmlStore from '@/hooks/useBitriseYmlStore';
@@ -59,15 +60,22 @@ export type ReadOnlyViewInfo = {
   /** True when the active view can't be edited: the merged config preview, or a cross-repo/ref file. */
   isReadOnly: boolean;
   isMergedConfig: boolean;
+  /** Another local editor tab holds the config's edit lock (CLI mode). */
+  isLockedElsewhere?: boolean;
   /** Effective source ref of the read-only file (e.g. `repo@branch`), when that's the reason. */
   sourceLabel?: string;
 };
 
 const EDITABLE_VIEW: ReadOnlyViewInfo = { isReadOnly: false, isMergedConfig: false };
+const LOCKED_VIEW: ReadOnlyViewInfo = { isReadOnly: true, isMergedConfig: false, isLockedElsewhere: true };
 
-/** Whether the active view is read-only ("ghost"), and why: merged preview or cross-repo/ref file. */
+/**
+ * Whether the active view is read-only ("ghost"), and why: merged preview, cross-repo/ref file, or
+ * the config being edited in another local tab.
+ */
 export function useReadOnlyView(): ReadOnlyViewInfo {
-  return useBitriseYmlStore((s) => {
+  const isLockedElsewhere = useEditLockStore((s) => Boolean(s.heldElsewhere));
+  const view = useBitriseYmlStore((s) => {
     if (!s.tree) {
       return EDITABLE_VIEW;
     }
@@ -84,9 +92,10 @@ export function useReadOnlyView(): ReadOnlyViewInfo {
     }
     return EDITABLE_VIEW;
   });
+  return isLockedElsewhere ? LOCKED_VIEW : view;
 }
 
-/** Boolean shorthand for `useReadOnlyView` — true on the merged config tab and on cross-repo/ref files. */
+/** Boolean shorthand for `useReadOnlyView` — true on the merged config tab, on cross-repo/ref files and while locked. */
 export function useIsReadOnlyView(): boolean {
   return useReadOnlyView().isReadOnly;
 }
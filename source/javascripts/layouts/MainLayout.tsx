@@ -1,11 +1,13 @@
 import { Box } from '@chakra-ui/react/box';
 import { Redirect, Router, Switch } from 'wouter';
 
+import EditLockNotification from '@/components/EditLockNotification';
 import Header from '@/components/Header';
 import LazyRoute from '@/components/LazyRoute';
 import LoadingState from '@/components/LoadingState';
 import Navigation from '@/components/Navigation';
 import RuntimeUtils from '@/core/utils/RuntimeUtils';
+import useEditLock from '@/hooks/useEditLock';
 import useHashLocation from '@/hooks/useHashLocation';
 import useHashSearch from '@/hooks/useHashSearch';
 import useMergedConfigSync from '@/hooks/useMergedConfigSync';
@@ -31,10 +33,12 @@ const MainLayout = () => {
   const isConfigLoading = useIsConfigLoading();
 
   useMergedConfigSync();
+  useEditLock();
 
   return (
     <Box height="100dvh" display="flex" flexDirection="column">
       <Header />
+      <EditLockNotification />
       {tabsUnderHeader && <OpenFileTabs />}
       <Box display="flex" flex="1" minHeight={0}>
         {/* style instead of conditional unmount — keeps CI_CONFIG_RECEIVED / REQUEST_AI_DRAWER_OPEN listeners alive on the YAML page. */}
@@ -11,7 +11,7 @@ import { ComponentProps, PropsWithChildren, StrictMode, useEffect, useRef } from
 import { createRoot } from 'react-dom/client';
 import { useEventListener } from 'usehooks-ts';
 
-import Client from '@/core/api/client';
+import SessionApi from '@/core/api/SessionApi';
 import { initializeBitriseYmlDocument, initializeModularConfig } from '@/core/stores/BitriseYmlStore';
 import PageProps from '@/core/utils/PageProps';
 import RuntimeUtils from '@/core/utils/RuntimeUtils';
@@ -50,14 +50,32 @@ try {
 }
 
 if (RuntimeUtils.isProduction() && RuntimeUtils.isLocalMode()) {
-  // NOTE: The API server running in local mode, has a built-in termination timer
-  // This will stop the termination timer when the window is loaded
-  window.addEventListener('load', () => Client.post('/api/connection'), {
-    once: true,
+  // NOTE: The API server running in local mode shuts down once every editor tab is gone.
+  // Each tab registers its own session on load, keeps it alive with heartbeats
+  // and unregisters it when the page is hidden for good (closed, navigated away or put into the
+  // back/forward cache). Browsers throttle timers in hidden tabs and the server expires sessions
+  // without heartbeats after a couple of minutes, so a tab also sends a heartbeat as soon as it
+  // becomes visible again, re-registering its session if it expired.
+  const { connect } = SessionApi;
+  window.addEventListener(
+    'load',
+    () => {
+      connect();
+      window.setInterval(connect, 20_000);
+    },
+    { once: true },
+  );
+  document.addEventListener('visibilitychange', () => {
+    if (document.visibilityState === 'visible') {
+      connect();
+    }
   });
-  // This will restart the termination timer when the window is closed
-  window.addEventListener('beforeunload', () => Client.del('/api/connection'), {
-    once: true,
+  window.addEventListener('pagehide', () => SessionApi.disconnect());
+  window.addEventListener('pageshow', (e) => {
+    // Restored from the back/forward cache: the session was unregistered on pagehide.
+    if (e.persisted) {
+      connect();
+    }
   });
 }
 
@@ -4,6 +4,7 @@ import { ReactNode } from 'react';
 import { SortableEnvVar } from '@/components/SortableEnvVars/SortableEnvVarItem';
 import SortableEnvVars from '@/components/SortableEnvVars/SortableEnvVars';
 import { EnvVar, EnvVarSource } from '@/core/models/EnvVar';
+import { LocalEnvVarTarget } from '@/hooks/useLocalEnvVarWrite';
 
 const EnvVarsTableHeader = () => {
   return (
@@ -37,9 +38,19 @@ type Props = {
    * per-file sections show "No Environment Variables defined." instead of an empty, addable table. */
   emptyText?: string;
   renderJumpButton?: (env: SortableEnvVar) => ReactNode;
+  /** Local (CLI) mode: the merged view's per-file list, editable and written straight to its module. */
+  localWrite?: LocalEnvVarTarget;
 };
 
-const EnvVarsTable = ({ source, sourceId, initialEnvs, hideAddButton, emptyText, renderJumpButton }: Props) => {
+const EnvVarsTable = ({
+  source,
+  sourceId,
+  initialEnvs,
+  hideAddButton,
+  emptyText,
+  renderJumpButton,
+  localWrite,
+}: Props) => {
   return (
     <Card as="section" variant="outline" overflow="hidden">
       <EnvVarsTableHeader />
@@ -55,6 +66,7 @@ const EnvVarsTable = ({ source, sourceId, initialEnvs, hideAddButton, emptyText,
             initialEnvs={initialEnvs}
             hideAddButton={hideAddButton}
             renderJumpButton={renderJumpButton}
+            localWrite={localWrite}
           />
         )}
       </Box>
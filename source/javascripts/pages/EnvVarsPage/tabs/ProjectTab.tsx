@@ -9,15 +9,18 @@ import { useIsMergedConfigSelected } from '@/hooks/useTree';
 
 import EnvVarsTable from '../components/EnvVarsTable';
 import PrivateInfoNotification from '../components/PrivateInfoNotification';
-import { useProjectEnvVarFileGroups } from '../useProjectEnvVarFileGroups';
+import { useCanWriteEnvVarsLocally, useProjectEnvVarFileGroups } from '../useProjectEnvVarFileGroups';
 
 const ProjectTab = () => {
   const isMergedView = useIsMergedConfigSelected();
   const fileGroups = useProjectEnvVarFileGroups();
   const hasAnyEnvs = fileGroups.some((group) => group.envs.length > 0);
+  const canWriteLocally = useCanWriteEnvVarsLocally();
+  const canAddLocally = canWriteLocally && fileGroups.some((group) => group.editable);
 
-  // Merged view with no project env vars in any module: a single empty state, no per-file breakdown.
-  if (isMergedView && !hasAnyEnvs) {
+  // Merged view with no project env vars in any module: a single empty state, no per-file breakdown
+  // (unless they can be added locally).
+  if (isMergedView && !hasAnyEnvs && !canAddLocally) {
     return (
       <TabContainer>
         <EmptyState
@@ -38,21 +41,25 @@ const ProjectTab = () => {
       />
       {isMergedView ? (
         // Merged (read-only) view: one table per module file — empty files show a placeholder — with
-        // per-row jump-to-definition arrows.
-        fileGroups.map((group) => (
-          <Fragment key={group.nodeId}>
-            <Text as="h3" textStyle="heading/h3">
-              {group.path}
-            </Text>
-            <EnvVarsTable
-              source={EnvVarSource.App}
-              initialEnvs={group.envs}
-              hideAddButton
-              emptyText="No Environment Variables defined."
-              renderJumpButton={(_env) => <JumpToFileButton nodeId={group.nodeId} />}
-            />
-          </Fragment>
-        ))
+        // per-row jump-to-definition arrows. Locally, the tables of editable modules are written to disk.
+        fileGroups.map((group) => {
+          const localWrite = canWriteLocally && group.editable ? { file: group.path } : undefined;
+          return (
+            <Fragment key={group.nodeId}>
+              <Text as="h3" textStyle="heading/h3">
+                {group.path}
+              </Text>
+              <EnvVarsTable
+                source={EnvVarSource.App}
+                initialEnvs={group.envs}
+                hideAddButton={!localWrite}
+                emptyText={localWrite ? undefined : 'No Environment Variables defined.'}
+                renderJumpButton={(_env) => <JumpToFileButton nodeId={group.nodeId} />}
+                localWrite={localWrite}
+              />
+            </Fragment>
+          );
+        })
       ) : (
         <EnvVarsTable source={EnvVarSource.App} />
       )}
@@ -10,36 +10,48 @@ import { useIsMergedConfigSelected } from '@/hooks/useTree';
 
 import EnvVarsTable from '../components/EnvVarsTable';
 import PrivateInfoNotification from '../components/PrivateInfoNotification';
-import { useWorkflowEnvVarFileGroups, WorkflowEnvVarFileGroup } from '../useProjectEnvVarFileGroups';
+import {
+  useCanWriteEnvVarsLocally,
+  useWorkflowEnvVarFileGroups,
+  WorkflowEnvVarFileGroup,
+} from '../useProjectEnvVarFileGroups';
 
 const MergedWorkflowsTab = ({ groups }: { groups: WorkflowEnvVarFileGroup[] }) => {
+  const canWriteLocally = useCanWriteEnvVarsLocally();
+
   // One section per (workflow, source file) that defines the workflow — empty ones show a
-  // placeholder — with the arrow jumping straight to the file the vars live in.
+  // placeholder — with the arrow jumping straight to the file the vars live in. Locally, the
+  // sections of editable modules are written to disk.
   return (
     <>
-      {groups.map((group, index) => (
-        <Fragment key={`${group.workflowId}@${group.nodeId}`}>
-          <Box display="flex" alignItems="flex-start" justifyContent="space-between" gap="8">
-            <Box>
-              <Text as="h3" textStyle="heading/h3">
-                {group.workflowId}
-              </Text>
-              <Text textStyle="body/sm/regular" color="text/secondary">
-                Defined in {group.path}
-              </Text>
+      {groups.map((group, index) => {
+        const localWrite =
+          canWriteLocally && group.editable ? { file: group.path, workflowId: group.workflowId } : undefined;
+        return (
+          <Fragment key={`${group.workflowId}@${group.nodeId}`}>
+            <Box display="flex" alignItems="flex-start" justifyContent="space-between" gap="8">
+              <Box>
+                <Text as="h3" textStyle="heading/h3">
+                  {group.workflowId}
+                </Text>
+                <Text textStyle="body/sm/regular" color="text/secondary">
+                  Defined in {group.path}
+                </Text>
+              </Box>
             </Box>
-          </Box>
-          <EnvVarsTable
-            source={EnvVarSource.Workflows}
-            sourceId={group.workflowId}
-            initialEnvs={group.envs}
-            hideAddButton
-            emptyText="No Environment Variables defined."
-            renderJumpButton={(_env) => <JumpToFileButton nodeId={group.nodeId} />}
-          />
-          {groups.length - 1 > index && <Divider />}
-        </Fragment>
-      ))}
+            <EnvVarsTable
+              source={EnvVarSource.Workflows}
+              sourceId={group.workflowId}
+              initialEnvs={group.envs}
+              hideAddButton={!localWrite}
+              emptyText={localWrite ? undefined : 'No Environment Variables defined.'}
+              renderJumpButton={(_env) => <JumpToFileButton nodeId={group.nodeId} />}
+              localWrite={localWrite}
+            />
+            {groups.length - 1 > index && <Divider />}
+          </Fragment>
+        );
+      })}
     </>
   );
 };
@@ -66,9 +78,11 @@ const WorkflowsTab = () => {
   const isMergedView = useIsMergedConfigSelected();
   const groups = useWorkflowEnvVarFileGroups();
   const hasAnyEnvs = groups.some((group) => group.envs.length > 0);
+  const canAddLocally = useCanWriteEnvVarsLocally() && groups.some((group) => group.editable);
 
-  // Merged view with no workflow env vars in any module: a single empty state, no per-file breakdown.
-  if (isMergedView && !hasAnyEnvs) {
+  // Merged view with no workflow env vars in any module: a single empty state, no per-file breakdown
+  // (unless they can be added locally).
+  if (isMergedView && !hasAnyEnvs && !canAddLocally) {
     return (
       <TabContainer>
         <EmptyState
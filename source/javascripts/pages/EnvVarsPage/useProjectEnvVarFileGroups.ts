@@ -2,14 +2,22 @@ import { EnvVar } from '@/core/models/EnvVar';
 import EnvVarService from '@/core/services/EnvVarService';
 import TreeService from '@/core/services/TreeService';
 import { MERGED_CONFIG_NODE_ID } from '@/core/stores/BitriseYmlStore';
+import RuntimeUtils from '@/core/utils/RuntimeUtils';
 import YmlUtils from '@/core/utils/YmlUtils';
 import useBitriseYmlStore from '@/hooks/useBitriseYmlStore';
+import useYmlHasChanges from '@/hooks/useYmlHasChanges';
 
 type FromYmlEnv = Parameters<typeof EnvVarService.fromYml>[0];
 
-export type ProjectEnvVarFileGroup = { nodeId: string; path: string; envs: EnvVar[] };
+export type ProjectEnvVarFileGroup = { nodeId: string; path: string; editable: boolean; envs: EnvVar[] };
 
-export type WorkflowEnvVarFileGroup = { nodeId: string; path: string; workflowId: string; envs: EnvVar[] };
+export type WorkflowEnvVarFileGroup = {
+  nodeId: string;
+  path: string;
+  editable: boolean;
+  workflowId: string;
+  envs: EnvVar[];
+};
 
 /**
  * Project env vars (`app.envs`) grouped by module file, in tree pre-order — for the merged view's
@@ -34,7 +42,12 @@ export function useProjectEnvVarFileGroups(): ProjectEnvVarFileGroup[] {
       const envs = Array.isArray(rawEnvs)
         ? rawEnvs.map((env) => EnvVarService.fromYml(env as FromYmlEnv, 'Project envs'))
         : [];
-      groups.push({ nodeId: node.nodeId, path: s.files[node.nodeId]?.path ?? node.path, envs });
+      groups.push({
+        nodeId: node.nodeId,
+        path: s.files[node.nodeId]?.path ?? node.path,
+        editable: Boolean(s.files[node.nodeId]?.editable),
+        envs,
+      });
     });
     return groups;
   });
@@ -57,7 +70,7 @@ export function useWorkflowEnvVarFileGroups(): WorkflowEnvVarFileGroup[] {
 
     // Parse each file's own workflow→envs once. `byWorkflow` holds every workflow the file defines,
     // with an empty array when it defines the workflow but no env vars for it.
-    const perFile = new Map<string, { path: string; byWorkflow: Record<string, EnvVar[]> }>();
+    const perFile = new Map<string, { path: string; editable: boolean; byWorkflow: Record<string, EnvVar[]> }>();
     TreeService.walk(s.tree, (node) => {
       const doc = s.files[node.nodeId]?.ymlDocument;
       if (!doc) {
@@ -77,7 +90,11 @@ export function useWorkflowEnvVarFileGroups(): WorkflowEnvVarFileGroup[] {
           : [];
       });
       if (Object.keys(byWorkflow).length > 0) {
-        perFile.set(node.nodeId, { path: s.files[node.nodeId]?.path ?? node.path, byWorkflow });
+        perFile.set(node.nodeId, {
+          path: s.files[node.nodeId]?.path ?? node.path,
+          editable: Boolean(s.files[node.nodeId]?.editable),
+          byWorkflow,
+        });
       }
     });
 
@@ -89,10 +106,20 @@ export function useWorkflowEnvVarFileGroups(): WorkflowEnvVarFileGroup[] {
         const envs = file?.byWorkflow[workflowId];
         // `envs` is defined (possibly empty) only when this file defines the workflow.
         if (file && envs !== undefined) {
-          groups.push({ nodeId, path: file.path, workflowId, envs });
+          groups.push({ nodeId, path: file.path, editable: file.editable, workflowId, envs });
         }
       });
     });
     return groups;
   });
 }
+
+/**
+ * Local (CLI) mode: whether the merged view's per-file lists of editable modules are editable,
+ * written straight to the module on disk. Not with unsaved changes, as the config is reloaded after
+ * each write.
+ */
+export function useCanWriteEnvVarsLocally(): boolean {
+  const hasChanges = useYmlHasChanges();
+  return RuntimeUtils.isLocalMode() && !hasChanges;
+}
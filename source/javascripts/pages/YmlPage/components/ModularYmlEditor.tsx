@@ -10,6 +10,7 @@ import {
   MERGED_CONFIG_NODE_ID,
   updateBitriseYmlDocumentByString,
 } from '@/core/stores/BitriseYmlStore';
+import { useEditLockStore } from '@/core/stores/EditLockStore';
 import { MERGED_MODEL_URI } from '@/core/utils/lspModelUris';
 import YmlUtils from '@/core/utils/YmlUtils';
 import { useFile } from '@/hooks/useFile';
@@ -29,6 +30,8 @@ const MERGED_CONFIG_PATH = MERGED_MODEL_URI;
  * (see `useYmlLanguageServices`); `keepCurrentModel` stops the remount from disposing that shared model.
  */
 const EditableFileEditor = ({ file, path }: { file: FileSlice; path: string }) => {
+  const isLockedElsewhere = useEditLockStore((s) => Boolean(s.heldElsewhere));
+
   return (
     <Editor
       theme="vs-dark"
@@ -41,7 +44,7 @@ const EditableFileEditor = ({ file, path }: { file: FileSlice; path: string }) =
           updateBitriseYmlDocumentByString(value);
         }
       }}
-      options={{ minimap: { enabled: false } }}
+      options={{ readOnly: isLockedElsewhere, minimap: { enabled: false } }}
     />
   );
 };
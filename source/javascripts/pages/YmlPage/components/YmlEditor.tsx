@@ -4,6 +4,7 @@ import { useUnmount } from 'usehooks-ts';
 
 import LoadingState from '@/components/LoadingState';
 import { getYmlString, updateBitriseYmlDocumentByString } from '@/core/stores/BitriseYmlStore';
+import { useEditLockStore } from '@/core/stores/EditLockStore';
 import { useCiConfigSettings } from '@/hooks/useCiConfigSettings';
 import useFeatureFlag from '@/hooks/useFeatureFlag';
 import { BACKGROUND_MODEL_URI } from '@/hooks/useYmlLanguageServices';
@@ -12,6 +13,7 @@ const YmlEditor = () => {
   const monacoEditorRef = useRef<Parameters<OnMount>[0]>();
   const enableBranchSwitching = useFeatureFlag('enable-branch-switching');
   const { data: ymlSettings, isLoading: isLoadingSetting } = useCiConfigSettings();
+  const isLockedElsewhere = useEditLockStore((s) => Boolean(s.heldElsewhere));
 
   useUnmount(() => {
     if (monacoEditorRef.current) {
@@ -46,7 +48,8 @@ const YmlEditor = () => {
       onChange={handleEditorChange}
       onMount={handleEditorDidMount}
       options={{
-        readOnly: isLoadingSetting || (!enableBranchSwitching && ymlSettings?.usesRepositoryYml),
+        readOnly:
+          isLoadingSetting || isLockedElsewhere || (!enableBranchSwitching && ymlSettings?.usesRepositoryYml),
       }}
     />
   );